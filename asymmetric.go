@@ -0,0 +1,240 @@
+package dangerous
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	// register the hashes used by the algorithms below
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// PublicKeySignature is a Signature made with a private key and verified with
+// the matching public key. Signer uses its SigningKey and VerificationKeys
+// with such algorithms instead of a key derived from the secret.
+//
+// The GetSignature and VerifySignature methods take the keys DER encoded,
+// PKCS #8 for the private key and PKIX for the public key. As Signature has
+// no error to return, GetSignature gives an empty signature when the key can
+// not be parsed or used; SignWithKey reports why.
+type PublicKeySignature interface {
+	Signature
+	SignWithKey(key crypto.Signer, value []byte) ([]byte, error)
+	VerifyWithKey(key crypto.PublicKey, value, sig []byte) bool
+}
+
+func getSignatureDER(alg PublicKeySignature, key, value []byte) []byte {
+	private, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return []byte{}
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return []byte{}
+	}
+	sig, err := alg.SignWithKey(signer, value)
+	if err != nil {
+		return []byte{}
+	}
+	return sig
+}
+
+func verifySignatureDER(alg PublicKeySignature, key, value, sig []byte) bool {
+	public, err := x509.ParsePKIXPublicKey(key)
+	if err != nil {
+		return false
+	}
+	return alg.VerifyWithKey(public, value, sig)
+}
+
+func digest(hash crypto.Hash, value []byte) ([]byte, error) {
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash function %d is not available", hash)
+	}
+	h := hash.New()
+	h.Write(value)
+	return h.Sum(nil), nil
+}
+
+// Ed25519Algorithm signs with Ed25519 (EdDSA in JWS).
+type Ed25519Algorithm struct {
+}
+
+func (ea Ed25519Algorithm) SignWithKey(key crypto.Signer, value []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("Ed25519Algorithm needs an Ed25519 key, got %T", key)
+	}
+	return key.Sign(rand.Reader, value, crypto.Hash(0))
+}
+
+func (ea Ed25519Algorithm) VerifyWithKey(key crypto.PublicKey, value, sig []byte) bool {
+	public, ok := key.(ed25519.PublicKey)
+	return ok && len(public) == ed25519.PublicKeySize && ed25519.Verify(public, value, sig)
+}
+
+func (ea Ed25519Algorithm) GetSignature(key, value []byte) []byte {
+	return getSignatureDER(ea, key, value)
+}
+
+func (ea Ed25519Algorithm) VerifySignature(key, value, sig []byte) bool {
+	return verifySignatureDER(ea, key, value, sig)
+}
+
+// ECDSAAlgorithm signs with ECDSA (ES256, ES384, ES512 in JWS). Signatures
+// are the fixed size R || S concatenation of RFC 7518, not ASN.1. If Curve is
+// set, keys on other curves are rejected.
+type ECDSAAlgorithm struct {
+	Hash  crypto.Hash
+	Curve elliptic.Curve
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func (ea ECDSAAlgorithm) validCurve(public *ecdsa.PublicKey) bool {
+	return ea.Curve == nil || ea.Curve.Params().Name == public.Curve.Params().Name
+}
+
+func ecdsaKeySize(public *ecdsa.PublicKey) int {
+	return (public.Curve.Params().BitSize + 7) / 8
+}
+
+func (ea ECDSAAlgorithm) SignWithKey(key crypto.Signer, value []byte) ([]byte, error) {
+	public, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("ECDSAAlgorithm needs an ECDSA key, got %T", key)
+	}
+	if !ea.validCurve(public) {
+		return nil, fmt.Errorf("ECDSAAlgorithm needs a key on %s, got %s", ea.Curve.Params().Name, public.Curve.Params().Name)
+	}
+	hashed, err := digest(ea.Hash, value)
+	if err != nil {
+		return nil, err
+	}
+	der, err := key.Sign(rand.Reader, hashed, ea.Hash)
+	if err != nil {
+		return nil, err
+	}
+	var parsed ecdsaSignature
+	if _, err := asn1.Unmarshal(der, &parsed); err != nil {
+		return nil, err
+	}
+	size := ecdsaKeySize(public)
+	sig := make([]byte, 2*size)
+	r, ss := parsed.R.Bytes(), parsed.S.Bytes()
+	copy(sig[size-len(r):size], r)
+	copy(sig[2*size-len(ss):], ss)
+	return sig, nil
+}
+
+func (ea ECDSAAlgorithm) VerifyWithKey(key crypto.PublicKey, value, sig []byte) bool {
+	public, ok := key.(*ecdsa.PublicKey)
+	if !ok || !ea.validCurve(public) {
+		return false
+	}
+	size := ecdsaKeySize(public)
+	if len(sig) != 2*size {
+		return false
+	}
+	hashed, err := digest(ea.Hash, value)
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	return ecdsa.Verify(public, hashed, r, s)
+}
+
+func (ea ECDSAAlgorithm) GetSignature(key, value []byte) []byte {
+	return getSignatureDER(ea, key, value)
+}
+
+func (ea ECDSAAlgorithm) VerifySignature(key, value, sig []byte) bool {
+	return verifySignatureDER(ea, key, value, sig)
+}
+
+// RSAPKCS1v15Algorithm signs with RSASSA-PKCS1-v1_5 (RS256, RS384, RS512 in JWS).
+type RSAPKCS1v15Algorithm struct {
+	Hash crypto.Hash
+}
+
+func (ra RSAPKCS1v15Algorithm) SignWithKey(key crypto.Signer, value []byte) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("RSAPKCS1v15Algorithm needs an RSA key, got %T", key)
+	}
+	hashed, err := digest(ra.Hash, value)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, hashed, ra.Hash)
+}
+
+func (ra RSAPKCS1v15Algorithm) VerifyWithKey(key crypto.PublicKey, value, sig []byte) bool {
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	hashed, err := digest(ra.Hash, value)
+	if err != nil {
+		return false
+	}
+	return rsa.VerifyPKCS1v15(public, ra.Hash, hashed, sig) == nil
+}
+
+func (ra RSAPKCS1v15Algorithm) GetSignature(key, value []byte) []byte {
+	return getSignatureDER(ra, key, value)
+}
+
+func (ra RSAPKCS1v15Algorithm) VerifySignature(key, value, sig []byte) bool {
+	return verifySignatureDER(ra, key, value, sig)
+}
+
+// RSAPSSAlgorithm signs with RSASSA-PSS (PS256, PS384, PS512 in JWS), using
+// a salt as long as the hash as RFC 7518 requires.
+type RSAPSSAlgorithm struct {
+	Hash crypto.Hash
+}
+
+func (ra RSAPSSAlgorithm) options() *rsa.PSSOptions {
+	return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: ra.Hash}
+}
+
+func (ra RSAPSSAlgorithm) SignWithKey(key crypto.Signer, value []byte) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("RSAPSSAlgorithm needs an RSA key, got %T", key)
+	}
+	hashed, err := digest(ra.Hash, value)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, hashed, ra.options())
+}
+
+func (ra RSAPSSAlgorithm) VerifyWithKey(key crypto.PublicKey, value, sig []byte) bool {
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	hashed, err := digest(ra.Hash, value)
+	if err != nil {
+		return false
+	}
+	return rsa.VerifyPSS(public, ra.Hash, hashed, sig, ra.options()) == nil
+}
+
+func (ra RSAPSSAlgorithm) GetSignature(key, value []byte) []byte {
+	return getSignatureDER(ra, key, value)
+}
+
+func (ra RSAPSSAlgorithm) VerifySignature(key, value, sig []byte) bool {
+	return verifySignatureDER(ra, key, value, sig)
+}
@@ -0,0 +1,140 @@
+package dangerous
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+var (
+	testRSAKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	testP256Key, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testP384Key, _  = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	testP521Key, _  = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, testEdKey, _ = ed25519.GenerateKey(rand.Reader)
+
+	publicKeyAlgorithms = []struct {
+		alg     PublicKeySignature
+		key     crypto.Signer
+		other   crypto.Signer
+		sigsize int
+	}{
+		{Ed25519Algorithm{}, testEdKey, testP256Key, ed25519.SignatureSize},
+		{ECDSAAlgorithm{Hash: crypto.SHA256}, testP256Key, testP384Key, 64},
+		{ECDSAAlgorithm{Hash: crypto.SHA384}, testP384Key, testP256Key, 96},
+		{ECDSAAlgorithm{Hash: crypto.SHA512}, testP521Key, testP256Key, 132},
+		{RSAPKCS1v15Algorithm{Hash: crypto.SHA256}, testRSAKey, testP256Key, 256},
+		{RSAPKCS1v15Algorithm{Hash: crypto.SHA512}, testRSAKey, testP256Key, 256},
+		{RSAPSSAlgorithm{Hash: crypto.SHA256}, testRSAKey, testP256Key, 256},
+		{RSAPSSAlgorithm{Hash: crypto.SHA512}, testRSAKey, testP256Key, 256},
+	}
+)
+
+func TestPublicKeySigner(t *testing.T) {
+	for _, v := range publicKeyAlgorithms {
+		_signer, err := NewSigner("", WithAlgorithm(v.alg), WithSigningKey(v.key))
+		if err != nil {
+			t.Fatalf("%T: unexpected error:%s", v.alg, err)
+		}
+		signed := _signer.Sign(value)
+		verifier, err := NewSigner("", WithAlgorithm(v.alg), WithVerificationKeys(v.key.Public()))
+		if err != nil {
+			t.Fatalf("%T: unexpected error:%s", v.alg, err)
+		}
+		if unsigned, err := verifier.UnSign(string(signed)); err != nil || string(unsigned) != value {
+			t.Fatalf("%T: unsign failed. Error:%s", v.alg, err)
+		}
+		_, sig := RSplit(signed, []byte(DefaultSep))
+		if decoded, _ := B64decode(sig); len(decoded) != v.sigsize {
+			t.Fatalf("%T: unexpected signature size %d, expected %d", v.alg, len(decoded), v.sigsize)
+		}
+
+		tampered := append([]byte("x"), signed...)
+		if _, err := verifier.UnSign(string(tampered)); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%T: tampered value should not verify.", v.alg)
+		}
+		wrong := Signer{Algorithm: v.alg, VerificationKeys: []crypto.PublicKey{v.other.Public()}}
+		if wrong.Validate(string(signed)) {
+			t.Fatalf("%T: wrong key should not verify.", v.alg)
+		}
+		if _, ok := v.alg.(ECDSAAlgorithm); !ok {
+			if _, err := v.alg.SignWithKey(v.other, []byte(value)); err == nil {
+				t.Fatalf("%T: signing with the wrong key type should fail.", v.alg)
+			}
+		}
+	}
+}
+
+func TestPublicKeyRotation(t *testing.T) {
+	alg := ECDSAAlgorithm{Hash: crypto.SHA256}
+	old := Signer{Algorithm: alg, SigningKey: testP256Key}
+	signed := old.Sign(value)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rotated := Signer{Algorithm: alg, SigningKey: newKey,
+		VerificationKeys: []crypto.PublicKey{testP256Key.Public(), newKey.Public()}}
+	if _, key, err := rotated.UnSignKey(string(signed)); err != nil || key != 0 {
+		t.Fatalf("Rotated signer should verify the old key. key:%d, error:%s", key, err)
+	}
+	signed = rotated.Sign(value)
+	if _, key, err := rotated.UnSignKey(string(signed)); err != nil || key != 1 {
+		t.Fatalf("Rotated signer should sign with the new key. key:%d, error:%s", key, err)
+	}
+}
+
+func TestPublicKeyDER(t *testing.T) {
+	for _, v := range publicKeyAlgorithms {
+		private, _ := x509.MarshalPKCS8PrivateKey(v.key)
+		public, _ := x509.MarshalPKIXPublicKey(v.key.Public())
+		sig := v.alg.GetSignature(private, []byte(value))
+		if !v.alg.VerifySignature(public, []byte(value), sig) {
+			t.Fatalf("%T: DER keys failed to verify.", v.alg)
+		}
+		if v.alg.VerifySignature(public, []byte("other"), sig) {
+			t.Fatalf("%T: DER keys verified another value.", v.alg)
+		}
+		if len(v.alg.GetSignature([]byte("not a key"), []byte(value))) != 0 {
+			t.Fatalf("%T: invalid DER key should give an empty signature.", v.alg)
+		}
+	}
+}
+
+func TestPublicKeySignerInvalid(t *testing.T) {
+	if _, err := NewSigner("", WithAlgorithm(Ed25519Algorithm{})); err == nil {
+		t.Fatalf("NewSigner should reject a public key algorithm without keys.")
+	}
+	verifier := Signer{Algorithm: Ed25519Algorithm{}, VerificationKeys: []crypto.PublicKey{testEdKey.Public()}}
+	if _, err := verifier.SignE(value); err == nil {
+		t.Fatalf("Signing without a signing key should fail.")
+	}
+	if signed := verifier.Sign(value); len(signed) != 0 {
+		t.Fatalf("Sign without a signing key should return an empty token, got %s", signed)
+	}
+	if _, err := verifier.SignTimestampE(value); err == nil {
+		t.Fatalf("Signing without a signing key should fail.")
+	}
+	ser, _ := NewSerializer("", WithAlgorithm(ECDSAAlgorithm{Hash: crypto.SHA256}), WithVerificationKeys(testP256Key.Public()))
+	if _, err := ser.Dumps(value); err == nil {
+		t.Fatalf("Dumps without a signing key should fail.")
+	}
+	if _, err := ser.URLSafeTimedDumps(value); err == nil {
+		t.Fatalf("Dumps without a signing key should fail.")
+	}
+}
+
+func TestPublicKeySerializer(t *testing.T) {
+	ser, err := NewSerializer("", WithAlgorithm(Ed25519Algorithm{}), WithSigningKey(testEdKey))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	dump, _ := ser.URLSafeDumps(map[string]interface{}{"id": 5})
+	verifier, _ := NewSerializer("", WithAlgorithm(Ed25519Algorithm{}), WithVerificationKeys(testEdKey.Public()))
+	if load, err := verifier.URLSafeLoads(string(dump)); err != nil || load.(map[string]interface{})["id"] != float64(5) {
+		t.Fatalf("Loading failed. Error:%s", err)
+	}
+}
@@ -0,0 +1,384 @@
+package dangerous
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// CBORCodec encodes payloads as CBOR (RFC 8949), with the map keys sorted as
+// its core deterministic encoding requires. time.Time is encoded as an epoch
+// date, tag 1, or a date string, tag 0. Other tags are decoded as their content, except bignums which
+// are refused. Decoded integers are int64, or uint64 if they do not fit.
+type CBORCodec struct{}
+
+func (CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &cborWriter{}
+	if err := encodeValue(w, reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error {
+	d := cborDecoder{data: data}
+	g, err := d.decode(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("cbor: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return unmarshalGeneric(g, v)
+}
+
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+type cborWriter struct {
+	buf []byte
+}
+
+func (w *cborWriter) bytes() []byte          { return w.buf }
+func (w *cborWriter) newWriter() valueWriter { return &cborWriter{} }
+func (w *cborWriter) writeRaw(b []byte)      { w.buf = append(w.buf, b...) }
+func (w *cborWriter) writeNil()              { w.buf = append(w.buf, cborSimple|22) }
+
+// writeHead writes the initial byte of major type major with argument n in
+// its shortest form.
+func (w *cborWriter) writeHead(major byte, n uint64) {
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = appendUint16(append(w.buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		w.buf = appendUint32(append(w.buf, major|26), uint32(n))
+	default:
+		w.buf = appendUint64(append(w.buf, major|27), n)
+	}
+}
+
+func (w *cborWriter) writeBool(b bool) {
+	if b {
+		w.buf = append(w.buf, cborSimple|21)
+	} else {
+		w.buf = append(w.buf, cborSimple|20)
+	}
+}
+
+func (w *cborWriter) writeInt(i int64) {
+	if i < 0 {
+		w.writeHead(cborNegint, uint64(-1-i))
+		return
+	}
+	w.writeHead(cborUint, uint64(i))
+}
+
+func (w *cborWriter) writeUint(u uint64) { w.writeHead(cborUint, u) }
+
+func (w *cborWriter) writeFloat32(f float32) {
+	w.buf = appendUint32(append(w.buf, cborSimple|26), math.Float32bits(f))
+}
+
+// writeFloat64 writes f as a single precision float if that loses nothing.
+func (w *cborWriter) writeFloat64(f float64) {
+	if float64(float32(f)) == f || math.IsNaN(f) {
+		w.writeFloat32(float32(f))
+		return
+	}
+	w.buf = appendUint64(append(w.buf, cborSimple|27), math.Float64bits(f))
+}
+
+func (w *cborWriter) writeString(s string) {
+	w.writeHead(cborText, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *cborWriter) writeBytes(b []byte) {
+	w.writeHead(cborBytes, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *cborWriter) writeArrayHeader(n int) { w.writeHead(cborArray, uint64(n)) }
+func (w *cborWriter) writeMapHeader(n int)   { w.writeHead(cborMap, uint64(n)) }
+
+// writeTime writes an integer epoch date, or an RFC 3339 date string if t
+// has fractional seconds, which a float epoch date could not hold exactly.
+func (w *cborWriter) writeTime(t time.Time) {
+	if t.Nanosecond() == 0 {
+		w.writeHead(cborTag, 1)
+		w.writeInt(t.Unix())
+		return
+	}
+	w.writeHead(cborTag, 0)
+	w.writeString(t.UTC().Format(time.RFC3339Nano))
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.pos) < n {
+		return nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readHead reads an initial byte and its argument. indefinite is true for
+// the indefinite length marker.
+func (d *cborDecoder) readHead() (major byte, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b[0]&0xe0, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info == cborIndefinite:
+		return major, info, 0, true, nil
+	case info > 27:
+		return 0, 0, 0, false, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+	raw, err := d.read(1 << (info - 24))
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	switch len(raw) {
+	case 1:
+		arg = uint64(raw[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(raw))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(raw))
+	default:
+		arg = binary.BigEndian.Uint64(raw)
+	}
+	return major, info, arg, false, nil
+}
+
+// checkLength refuses a length of more items than there are bytes left,
+// which could only come from a hostile token.
+func (d *cborDecoder) checkLength(n uint64) error {
+	if n > uint64(len(d.data)-d.pos) {
+		return fmt.Errorf("cbor: length %d exceeds the data", n)
+	}
+	return nil
+}
+
+func (d *cborDecoder) atBreak() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == cborBreak {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, fmt.Errorf("cbor: data is nested too deeply")
+	}
+	major, info, arg, indefinite, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && (major == cborUint || major == cborNegint || major == cborTag) {
+		return nil, fmt.Errorf("cbor: invalid indefinite length item")
+	}
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: integer -1-%d overflows int64", arg)
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		b, err := d.decodeString(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(b), nil
+		}
+		return b, nil
+	case cborArray:
+		return d.decodeArray(arg, indefinite, depth)
+	case cborMap:
+		return d.decodeMap(arg, indefinite, depth)
+	case cborTag:
+		return d.decodeTag(arg, depth)
+	}
+	return d.decodeSimple(info, arg, indefinite)
+}
+
+func (d *cborDecoder) decodeString(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		if err := d.checkLength(n); err != nil {
+			return nil, err
+		}
+		b, err := d.read(n)
+		return append([]byte{}, b...), err
+	}
+	b := []byte{}
+	for !d.atBreak() {
+		chunkMajor, _, chunkLen, chunkIndefinite, err := d.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkIndefinite {
+			return nil, fmt.Errorf("cbor: invalid indefinite length string chunk")
+		}
+		chunk, err := d.read(chunkLen)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+	return b, nil
+}
+
+func (d *cborDecoder) decodeArray(n uint64, indefinite bool, depth int) (interface{}, error) {
+	if indefinite {
+		items := []interface{}{}
+		for !d.atBreak() {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	if err := d.checkLength(n); err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (d *cborDecoder) decodeMap(n uint64, indefinite bool, depth int) (interface{}, error) {
+	if !indefinite {
+		if err := d.checkLength(n); err != nil {
+			return nil, err
+		}
+	}
+	var keys, values []interface{}
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite && d.atBreak() {
+			break
+		}
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		keys, values = append(keys, k), append(values, v)
+	}
+	return genericMap(keys, values)
+}
+
+func (d *cborDecoder) decodeTag(tag uint64, depth int) (interface{}, error) {
+	content, err := d.decode(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case 0:
+		s, ok := content.(string)
+		if !ok {
+			return nil, fmt.Errorf("cbor: tag 0 content must be a string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case 1:
+		switch n := content.(type) {
+		case int64:
+			return time.Unix(n, 0).UTC(), nil
+		case float64:
+			sec, frac := math.Modf(n)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, fmt.Errorf("cbor: tag 1 content must be a number")
+	case 2, 3:
+		return nil, fmt.Errorf("cbor: bignums are not supported")
+	}
+	return content, nil
+}
+
+func (d *cborDecoder) decodeSimple(info byte, arg uint64, indefinite bool) (interface{}, error) {
+	if indefinite {
+		return nil, fmt.Errorf("cbor: unexpected break")
+	}
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfToFloat64(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+}
+
+// halfToFloat64 converts an IEEE 754 half precision float, as in appendix D
+// of RFC 8949.
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,25 @@
+package dangerous
+
+import "time"
+
+// Clock tells the current time to timestamp signing and verification.
+// Tests can replace it with a fake, see dangeroustest.FakeClock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// DefaultClock is the Clock used when none is configured.
+var DefaultClock Clock = systemClock{}
+
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return DefaultClock
+	}
+	return clock
+}
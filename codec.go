@@ -0,0 +1,585 @@
+package dangerous
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Codec turns payloads into bytes and back. Unlike JSONAPI it is not tied to
+// a text format, so a Serializer with a binary Codec can carry []byte fields
+// as they are. Unmarshal takes a pointer, as json.Unmarshal does.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Marshal is json.Marshal.
+func (js JSON) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal is LoadInto.
+func (js JSON) Unmarshal(data []byte, v interface{}) error {
+	return js.LoadInto(data, v)
+}
+
+// GobCodec encodes payloads with encoding/gob. Gob needs to know the
+// concrete type it decodes into, so load with the LoadsInto methods or a
+// TypedSerializer rather than Loads.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// codecAPI lets a Codec stand where the payload functions expect a JSONAPI.
+type codecAPI struct {
+	Codec
+}
+
+func (c codecAPI) Load(data []byte) (interface{}, error) {
+	var result interface{}
+	err := c.Unmarshal(data, &result)
+	return result, err
+}
+
+func (c codecAPI) Dump(v interface{}) (string, error) {
+	b, err := c.Marshal(v)
+	return string(b), err
+}
+
+func (c codecAPI) LoadInto(data []byte, v interface{}) error {
+	return c.Unmarshal(data, v)
+}
+
+// codecJSONAPI returns codec as a JSONAPI.
+func codecJSONAPI(codec Codec) JSONAPI {
+	if api, ok := codec.(JSONAPI); ok {
+		return api
+	}
+	return codecAPI{codec}
+}
+
+/*-------------------------------------------------------------------------------*/
+// Shared by the MessagePack and CBOR codecs. Both encode Go values by
+// reflection through a valueWriter, and decode to generic values first: nil,
+// bool, int64, uint64, float64, string, []byte, time.Time, []interface{} and
+// map[string]interface{}, or map[interface{}]interface{} if a key is not a
+// string. setGeneric then stores a generic value in the destination. Struct
+// fields are named by their json tags so the same payload types work with
+// every codec.
+
+const maxCodecDepth = 1000
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type valueWriter interface {
+	writeNil()
+	writeBool(b bool)
+	writeInt(i int64)
+	writeUint(u uint64)
+	writeFloat32(f float32)
+	writeFloat64(f float64)
+	writeString(s string)
+	writeBytes(b []byte)
+	writeTime(t time.Time)
+	writeArrayHeader(n int)
+	writeMapHeader(n int)
+	writeRaw(b []byte)
+	newWriter() valueWriter
+	bytes() []byte
+}
+
+func encodeValue(w valueWriter, v reflect.Value, depth int) error {
+	if depth > maxCodecDepth {
+		return fmt.Errorf("value is nested too deeply")
+	}
+	if !v.IsValid() {
+		w.writeNil()
+		return nil
+	}
+	if v.Type() == timeType {
+		w.writeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		return encodeValue(w, v.Elem(), depth+1)
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(v.Uint())
+	case reflect.Float32:
+		w.writeFloat32(float32(v.Float()))
+	case reflect.Float64:
+		w.writeFloat64(v.Float())
+	case reflect.String:
+		w.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice && v.IsNil() {
+				w.writeNil()
+				return nil
+			}
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			w.writeBytes(b)
+			return nil
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		w.writeArrayHeader(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(w, v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		return encodeMap(w, v, depth)
+	case reflect.Struct:
+		return encodeStruct(w, v, depth)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// encodeMap writes the entries of v sorted by their encoded keys, so the
+// output does not depend on map iteration order.
+func encodeMap(w valueWriter, v reflect.Value, depth int) error {
+	type entry struct{ key, value []byte }
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		kw, vw := w.newWriter(), w.newWriter()
+		if err := encodeValue(kw, iter.Key(), depth+1); err != nil {
+			return err
+		}
+		if err := encodeValue(vw, iter.Value(), depth+1); err != nil {
+			return err
+		}
+		entries = append(entries, entry{kw.bytes(), vw.bytes()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	w.writeMapHeader(len(entries))
+	for _, e := range entries {
+		w.writeRaw(e.key)
+		w.writeRaw(e.value)
+	}
+	return nil
+}
+
+func encodeStruct(w valueWriter, v reflect.Value, depth int) error {
+	fields := structFields(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		values = append(values, fv)
+		names = append(names, f.name)
+	}
+	w.writeMapHeader(len(values))
+	for i, fv := range values {
+		w.writeString(names[i])
+		if err := encodeValue(w, fv, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type codecField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields lists the fields of t as encoding/json names them. Untagged
+// embedded structs are flattened; on a name clash the shallower field wins.
+func structFields(t reflect.Type) []codecField {
+	var fields []codecField
+	seen := map[string]bool{}
+	visited := map[reflect.Type]bool{t: true}
+	current := []codecField{{index: nil}}
+	for len(current) > 0 {
+		var next []codecField
+		var level []codecField
+		for _, parent := range current {
+			st := t
+			if len(parent.index) > 0 {
+				st = t.FieldByIndex(parent.index).Type
+				if st.Kind() == reflect.Ptr {
+					st = st.Elem()
+				}
+			}
+			for i := 0; i < st.NumField(); i++ {
+				sf := st.Field(i)
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if comma := strings.IndexByte(tag, ','); comma >= 0 {
+					name, opts = tag[:comma], tag[comma+1:]
+				}
+				index := append(append([]int(nil), parent.index...), i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					if !visited[ft] {
+						visited[ft] = true
+						next = append(next, codecField{index: index})
+					}
+					continue
+				}
+				if sf.PkgPath != "" {
+					continue
+				}
+				if name == "" {
+					name = sf.Name
+				}
+				level = append(level, codecField{name: name, index: index,
+					omitEmpty: strings.Contains(","+opts+",", ",omitempty,")})
+			}
+		}
+		for _, f := range level {
+			if !seen[f.name] {
+				seen[f.name] = true
+				fields = append(fields, f)
+			}
+		}
+		current = next
+	}
+	return fields
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex that reports false instead of
+// panicking on a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+// genericMap returns the decoded entries as a map[string]interface{}, or as
+// a map[interface{}]interface{} if a key is not a string.
+func genericMap(keys, values []interface{}) (interface{}, error) {
+	allStrings := true
+	for _, k := range keys {
+		if _, ok := k.(string); !ok {
+			allStrings = false
+			break
+		}
+	}
+	if allStrings {
+		m := make(map[string]interface{}, len(keys))
+		for i, k := range keys {
+			m[k.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, len(keys))
+	for i, k := range keys {
+		if k != nil && !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("map key of type %T is not supported", k)
+		}
+		m[k] = values[i]
+	}
+	return m, nil
+}
+
+// unmarshalGeneric stores the generic value g in the value v points to.
+func unmarshalGeneric(g interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("can not unmarshal into non-pointer %T", v)
+	}
+	return setGeneric(g, rv.Elem())
+}
+
+// plainGeneric is implemented by generic values of named types, such as
+// FlaskTuple, that setGeneric stores as their plain counterpart unless the
+// destination has their type.
+type plainGeneric interface {
+	plainGeneric() interface{}
+}
+
+func setGeneric(g interface{}, dst reflect.Value) error {
+	if g == nil {
+		switch dst.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			dst.Set(reflect.Zero(dst.Type()))
+		}
+		return nil
+	}
+	if p, ok := g.(plainGeneric); ok && dst.Kind() != reflect.Interface && dst.Kind() != reflect.Ptr {
+		if reflect.TypeOf(g) == dst.Type() {
+			dst.Set(reflect.ValueOf(g))
+			return nil
+		}
+		return setGeneric(p.plainGeneric(), dst)
+	}
+	mismatch := fmt.Errorf("can not unmarshal %T into %s", g, dst.Type())
+	if dst.Type() == timeType {
+		t, ok := g.(time.Time)
+		if !ok {
+			return mismatch
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Interface:
+		gv := reflect.ValueOf(g)
+		if !gv.Type().AssignableTo(dst.Type()) {
+			return mismatch
+		}
+		dst.Set(gv)
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return setGeneric(g, dst.Elem())
+	case reflect.Bool:
+		b, ok := g.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch n := g.(type) {
+		case int64:
+			i = n
+		case uint64:
+			if n > 1<<63-1 {
+				return fmt.Errorf("%d overflows %s", n, dst.Type())
+			}
+			i = int64(n)
+		default:
+			return mismatch
+		}
+		if dst.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, dst.Type())
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch n := g.(type) {
+		case uint64:
+			u = n
+		case int64:
+			if n < 0 {
+				return fmt.Errorf("%d overflows %s", n, dst.Type())
+			}
+			u = uint64(n)
+		default:
+			return mismatch
+		}
+		if dst.OverflowUint(u) {
+			return fmt.Errorf("%d overflows %s", u, dst.Type())
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch n := g.(type) {
+		case float64:
+			dst.SetFloat(n)
+		case int64:
+			dst.SetFloat(float64(n))
+		case uint64:
+			dst.SetFloat(float64(n))
+		default:
+			return mismatch
+		}
+	case reflect.String:
+		s, ok := g.(string)
+		if !ok {
+			return mismatch
+		}
+		dst.SetString(s)
+	case reflect.Slice:
+		if b, ok := g.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		items, ok := g.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setGeneric(item, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+	case reflect.Array:
+		if b, ok := g.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			if len(b) != dst.Len() {
+				return mismatch
+			}
+			reflect.Copy(dst, reflect.ValueOf(b))
+			return nil
+		}
+		items, ok := g.([]interface{})
+		if !ok || len(items) != dst.Len() {
+			return mismatch
+		}
+		for i, item := range items {
+			if err := setGeneric(item, dst.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		return eachGenericEntry(g, mismatch, func(k, v interface{}) error {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := setGeneric(k, key); err != nil {
+				return err
+			}
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if err := setGeneric(v, value); err != nil {
+				return err
+			}
+			dst.SetMapIndex(key, value)
+			return nil
+		})
+	case reflect.Struct:
+		fields := structFields(dst.Type())
+		return eachGenericEntry(g, mismatch, func(k, v interface{}) error {
+			name, ok := k.(string)
+			if !ok {
+				return nil
+			}
+			f, ok := findField(fields, name)
+			if !ok {
+				return nil
+			}
+			return setGeneric(v, allocFieldByIndex(dst, f.index))
+		})
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+func eachGenericEntry(g interface{}, mismatch error, fn func(k, v interface{}) error) error {
+	switch m := g.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// findField matches name exactly first and then case-insensitively, as
+// encoding/json does.
+func findField(fields []codecField, name string) (codecField, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return codecField{}, false
+}
+
+// allocFieldByIndex is reflect.Value.FieldByIndex that allocates nil
+// embedded pointers on the way.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
@@ -0,0 +1,256 @@
+package dangerous
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Claims
+	Key     []byte            `json:"key"`
+	Count   uint16            `json:"count"`
+	Ratio   float64           `json:"ratio"`
+	Tags    []string          `json:"tags,omitempty"`
+	Extra   map[string]int64  `json:"extra"`
+	Created time.Time         `json:"created"`
+	Nested  *testPayload      `json:"nested,omitempty"`
+	Ignored string            `json:"-"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+var (
+	codecs = []Codec{JSON{}, MsgPackCodec{}, CBORCodec{}}
+
+	payload = testPayload{
+		Claims:  Claims{Subject: "user", ExpiresAt: 1 << 60},
+		Key:     []byte{0, 1, 2, 0xff},
+		Count:   65535,
+		Ratio:   0.1,
+		Extra:   map[string]int64{"min": math.MinInt64, "max": math.MaxInt64},
+		Created: time.Unix(1580000000, 123000000).UTC(),
+		Nested:  &testPayload{Count: 1, Created: time.Unix(0, 0).UTC()},
+		Ignored: "ignored",
+	}
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range append(codecs, GobCodec{}) {
+		data, err := codec.Marshal(payload)
+		if err != nil {
+			t.Fatalf("%T: unexpected error:%s", codec, err)
+		}
+		var loaded testPayload
+		if err := codec.Unmarshal(data, &loaded); err != nil {
+			t.Fatalf("%T: unexpected error:%s", codec, err)
+		}
+		expected := payload
+		expected.Ignored = ""
+		if _, ok := codec.(GobCodec); ok {
+			expected.Ignored = payload.Ignored
+		}
+		if !reflect.DeepEqual(loaded, expected) {
+			t.Fatalf("%T: got %+v, expected %+v", codec, loaded, expected)
+		}
+	}
+}
+
+func TestCodecGeneric(t *testing.T) {
+	for _, codec := range []Codec{MsgPackCodec{}, CBORCodec{}} {
+		data, _ := codec.Marshal(map[string]interface{}{
+			"id": uint64(math.MaxUint64), "neg": -5, "list": []interface{}{"a", true, nil, 1.5},
+			"bin": []byte("bytes"), "map": map[int]string{1: "one"},
+		})
+		var loaded interface{}
+		if err := codec.Unmarshal(data, &loaded); err != nil {
+			t.Fatalf("%T: unexpected error:%s", codec, err)
+		}
+		expected := map[string]interface{}{
+			"id": uint64(math.MaxUint64), "neg": int64(-5), "list": []interface{}{"a", true, nil, 1.5},
+			"bin": []byte("bytes"), "map": map[interface{}]interface{}{int64(1): "one"},
+		}
+		if !reflect.DeepEqual(loaded, expected) {
+			t.Fatalf("%T: got %#v", codec, loaded)
+		}
+		var small struct {
+			Neg uint8 `json:"neg"`
+		}
+		if err := codec.Unmarshal(data, &small); err == nil {
+			t.Fatalf("%T: a negative number should not decode into an uint8.", codec)
+		}
+	}
+}
+
+func TestMsgPackVectors(t *testing.T) {
+	for _, v := range []struct {
+		value   interface{}
+		encoded string
+	}{
+		{nil, "c0"},
+		{false, "c2"},
+		{true, "c3"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{256, "cd0100"},
+		{65536, "ce00010000"},
+		{int64(1) << 32, "cf0000000100000000"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{-129, "d1ff7f"},
+		{-32769, "d2ffff7fff"},
+		{int64(math.MinInt64), "d38000000000000000"},
+		{float32(1.5), "ca3fc00000"},
+		{1.1, "cb3ff199999999999a"},
+		{"", "a0"},
+		{"a", "a161"},
+		{strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{[]byte{1, 2}, "c4020102"},
+		{[]int{1, 2, 3}, "93010203"},
+		{map[string]int{"b": 2, "a": 1}, "82a16101a16202"},
+		{time.Unix(1, 0), "d6ff00000001"},
+		{time.Unix(1, 1), "d7ff0000000400000001"},
+		{time.Unix(-1, 0).UTC(), "c70cff00000000ffffffffffffffff"},
+	} {
+		data, err := MsgPackCodec{}.Marshal(v.value)
+		if err != nil || hex.EncodeToString(data) != v.encoded {
+			t.Fatalf("%#v: got %x, expected %s. Error:%v", v.value, data, v.encoded, err)
+		}
+	}
+}
+
+func TestCBORVectors(t *testing.T) {
+	// From appendix A of RFC 8949.
+	for _, v := range []struct {
+		value   interface{}
+		encoded string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{int64(math.MinInt64), "3b7fffffffffffffff"},
+		{1.1, "fb3ff199999999999a"},
+		{100000.0, "fa47c35000"},
+		{math.Inf(1), "fa7f800000"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]int{}, "80"},
+		{[]interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{map[int]int{1: 2, 3: 4}, "a201020304"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+		{time.Unix(1363896240, 0), "c11a514b67b0"},
+		{time.Unix(1363896240, 500000000), "c076323031332d30332d32315432303a30343a30302e355a"},
+	} {
+		data, err := CBORCodec{}.Marshal(v.value)
+		if err != nil || hex.EncodeToString(data) != v.encoded {
+			t.Fatalf("%#v: got %x, expected %s. Error:%v", v.value, data, v.encoded, err)
+		}
+	}
+
+	for _, v := range []struct {
+		encoded string
+		value   interface{}
+	}{
+		{"f90000", 0.0},
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"f9c400", -4.0},
+		{"f97c00", math.Inf(1)},
+		{"f7", nil},
+		{"c1fb41d452d9ec200000", time.Unix(1363896240, 500000000).UTC()},
+		{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	} {
+		data, _ := hex.DecodeString(v.encoded)
+		var loaded interface{}
+		if err := (CBORCodec{}).Unmarshal(data, &loaded); err != nil || !reflect.DeepEqual(loaded, v.value) {
+			t.Fatalf("%s: got %#v, expected %#v. Error:%v", v.encoded, loaded, v.value, err)
+		}
+	}
+}
+
+func TestCodecInvalid(t *testing.T) {
+	deep := func(open, end string) []byte {
+		data, _ := hex.DecodeString(strings.Repeat(open, 2000) + end)
+		return data
+	}
+	for _, v := range []struct {
+		codec Codec
+		data  []byte
+	}{
+		{MsgPackCodec{}, []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{MsgPackCodec{}, []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{MsgPackCodec{}, []byte{0xc0, 0xc0}},
+		{MsgPackCodec{}, []byte{0xc1}},
+		{MsgPackCodec{}, []byte{0xd4, 0x01, 0x00}},
+		{MsgPackCodec{}, deep("91", "c0")},
+		{CBORCodec{}, []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{CBORCodec{}, []byte{0x7a, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{CBORCodec{}, []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{CBORCodec{}, []byte{0xc2, 0x41, 0x01}},
+		{CBORCodec{}, []byte{0xff}},
+		{CBORCodec{}, []byte{0x9f, 0x01}},
+		{CBORCodec{}, []byte{0xf6, 0xf6}},
+		{CBORCodec{}, deep("81", "f6")},
+	} {
+		var loaded interface{}
+		if err := v.codec.Unmarshal(v.data, &loaded); err == nil {
+			t.Fatalf("%T: %x should not decode.", v.codec, v.data)
+		}
+	}
+}
+
+func TestSerializerCodec(t *testing.T) {
+	for _, codec := range codecs {
+		ser, err := NewSerializer("secret-key", WithCodec(codec))
+		if err != nil {
+			t.Fatalf("%T: unexpected error:%s", codec, err)
+		}
+		signed, _ := ser.URLSafeDumps(map[string]interface{}{"id": "5"})
+		if loaded, err := ser.URLSafeLoads(string(signed)); err != nil || loaded.(map[string]interface{})["id"] != "5" {
+			t.Fatalf("%T: loading failed. Error:%v", codec, err)
+		}
+		signed, _ = ser.TimedDumps(payload)
+		var loaded testPayload
+		if err := ser.TimedLoadsInto(string(signed), 10, &loaded); err != nil || !bytes.Equal(loaded.Key, payload.Key) {
+			t.Fatalf("%T: loading failed. Error:%v", codec, err)
+		}
+	}
+
+	ts, _ := NewTypedSerializer[testPayload]("secret-key", WithCodec(GobCodec{}))
+	signed, _ := ts.URLSafeTimedDumps(payload)
+	if loaded, err := ts.URLSafeTimedLoads(string(signed), 10); err != nil || loaded.Count != payload.Count {
+		t.Fatalf("Gob loading failed. Error:%v", err)
+	}
+
+	json, _ := NewSerializer("secret-key")
+	cbor, _ := NewSerializer("secret-key", WithCodec(CBORCodec{}))
+	jsonSigned, _ := json.URLSafeDumps(payload)
+	cborSigned, _ := cbor.URLSafeDumps(payload)
+	if len(cborSigned) >= len(jsonSigned) {
+		t.Fatalf("CBOR token should be smaller than JSON: %d >= %d", len(cborSigned), len(jsonSigned))
+	}
+	if _, err := cbor.URLSafeLoads(string(jsonSigned)); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+}
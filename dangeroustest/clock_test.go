@@ -0,0 +1,22 @@
+package dangeroustest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1580000000, 0)
+	clock := NewFakeClock(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("Unexpected time:%s", clock.Now())
+	}
+	clock.Advance(2 * time.Second)
+	if !clock.Now().Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("Unexpected time after Advance:%s", clock.Now())
+	}
+	clock.Set(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("Unexpected time after Set:%s", clock.Now())
+	}
+}
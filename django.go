@@ -0,0 +1,401 @@
+package dangerous
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Salts of django.core.signing and of Django's signed cookie sessions.
+const (
+	DjangoSignerSalt          = "django.core.signing.Signer"
+	DjangoTimestampSignerSalt = "django.core.signing.TimestampSigner"
+	DjangoDumpsSalt           = "django.core.signing"
+	DjangoSessionSalt         = "django.contrib.sessions.backends.signed_cookies"
+)
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// DjangoSigner signs and verifies values as django.core.signing.Signer and
+// TimestampSigner do: the signature is the unpadded URL-safe base64 of an
+// HMAC keyed with the digest of salt + "signer" + secret, it follows a ":",
+// and timestamps are written in base62.
+//
+// SecretKeys is ordered from oldest to newest, so SECRET_KEY goes last and
+// SECRET_KEY_FALLBACKS before it. Without a Salt, Sign uses DjangoSignerSalt
+// and SignTimestamp DjangoTimestampSignerSalt, the defaults of the Django
+// classes. DigestMethod is Django's algorithm. With Legacy, the sha1
+// signatures of Django before 3.1, or of DEFAULT_HASHING_ALGORITHM = "sha1",
+// are accepted too.
+//
+// Unlike Django, timestamps more than Leeway seconds in the future are
+// refused, as Signer.UnSignTimestamp does.
+type DjangoSigner struct {
+	Secret       string
+	SecretKeys   []string // oldest to newest
+	Salt         string
+	DigestMethod func() hash.Hash // sha256 if nil
+	Legacy       bool
+	Clock        Clock // DefaultClock if nil
+	Leeway       int64 // seconds
+
+	signers          []Signer // main, then legacy
+	timestampSigners []Signer
+	initialized      bool
+}
+
+// NewDjangoSigner returns a validated DjangoSigner. It accepts the
+// WithSecretKeys, WithSalt, WithDigestMethod, WithDjangoLegacy, WithClock
+// and WithLeeway options.
+func NewDjangoSigner(secret string, opts ...Option) (DjangoSigner, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return DjangoSigner{}, err
+	}
+	ds := DjangoSigner{
+		Secret:       secret,
+		SecretKeys:   o.secretKeys,
+		Salt:         o.salt,
+		DigestMethod: o.digestMethod,
+		Legacy:       o.djangoLegacy,
+		Clock:        o.clock,
+		Leeway:       o.leeway,
+	}
+	if err := ds.init(); err != nil {
+		return DjangoSigner{}, err
+	}
+	return ds, nil
+}
+
+func (ds *DjangoSigner) init() error {
+	if ds.initialized {
+		return nil
+	}
+	if ds.Secret == "" && len(ds.SecretKeys) == 0 {
+		return fmt.Errorf("DjangoSigner secret is empty")
+	}
+	ds.SetDefault()
+	ds.initialized = true
+	return nil
+}
+
+// SetDefault fills in the defaults of a DjangoSigner built as a struct
+// literal.
+func (ds *DjangoSigner) SetDefault() {
+	if ds.initialized {
+		return
+	}
+	if ds.DigestMethod == nil {
+		ds.DigestMethod = sha256.New
+	}
+	salt, timestampSalt := ds.Salt, ds.Salt
+	if ds.Salt == "" {
+		salt, timestampSalt = DjangoSignerSalt, DjangoTimestampSignerSalt
+	}
+	ds.signers = ds.newSigners(salt)
+	ds.timestampSigners = ds.newSigners(timestampSalt)
+}
+
+func (ds DjangoSigner) newSigners(salt string) []Signer {
+	signer := Signer{
+		Secret:        ds.Secret,
+		SecretKeys:    ds.SecretKeys,
+		Salt:          salt,
+		Sep:           ":",
+		KeyDerivation: "django-concat",
+		DigestMethod:  ds.DigestMethod,
+		Clock:         ds.Clock,
+		Leeway:        ds.Leeway,
+	}
+	signers := []Signer{signer}
+	if ds.Legacy {
+		legacy := signer
+		legacy.DigestMethod = sha1.New
+		signers = append(signers, legacy)
+	}
+	for p := range signers {
+		signers[p].SetDefault()
+	}
+	return signers
+}
+
+// Sign returns value followed by ":" and its signature, as Signer.sign.
+func (ds DjangoSigner) Sign(value string) []byte {
+	signed, _ := ds.SignE(value)
+	return signed
+}
+
+// SignE is Sign that returns an error when the signer can not sign.
+func (ds DjangoSigner) SignE(value string) ([]byte, error) {
+	(&ds).SetDefault()
+	return ds.signers[0].SignE(value)
+}
+
+// UnSign returns the value of a signed value, as Signer.unsign.
+func (ds DjangoSigner) UnSign(signedvalue string) ([]byte, error) {
+	(&ds).SetDefault()
+	return unSignAny(ds.signers, signedvalue)
+}
+
+// SignTimestamp signs value and the current time, as TimestampSigner.sign.
+func (ds DjangoSigner) SignTimestamp(value string) []byte {
+	signed, _ := ds.SignTimestampE(value)
+	return signed
+}
+
+// SignTimestampE is SignTimestamp that returns an error when the signer can
+// not sign.
+func (ds DjangoSigner) SignTimestampE(value string) ([]byte, error) {
+	(&ds).SetDefault()
+	signer := ds.timestampSigners[0]
+	return signer.SignE(value + signer.Sep + b62encode(signer.GetTimestamp()))
+}
+
+// UnSignTimestamp returns the value and timestamp of a value signed by
+// SignTimestamp, as TimestampSigner.unsign. Like Signer.UnSignTimestamp, a
+// negative MaxAge disables the timestamp checks and the value is returned
+// alongside time errors.
+func (ds DjangoSigner) UnSignTimestamp(signedvalue string, MaxAge int64) ([]byte, int64, error) {
+	(&ds).SetDefault()
+	result, err := unSignAny(ds.timestampSigners, signedvalue)
+	if err != nil {
+		return result, 0, err
+	}
+	signer := ds.timestampSigners[0]
+	if !bytes.Contains(result, signer.SepBytes) {
+		return result, 0, newBadTimeSignature(result, time.Time{}, nil, "timestamp missing")
+	}
+	value, ts := RSplit(result, signer.SepBytes)
+	timestamp, err := b62decode(ts)
+	if err != nil {
+		return value, 0, newBadTimeSignature(value, time.Time{}, err, "Malformed timestamp")
+	}
+	return value, timestamp, signer.checkTimestamp(value, timestamp, MaxAge)
+}
+
+// unSignAny unsigns with the first of signers that verifies signedvalue, and
+// otherwise returns the error of the first one.
+func unSignAny(signers []Signer, signedvalue string) ([]byte, error) {
+	value, err := signers[0].UnSign(signedvalue)
+	for _, signer := range signers[1:] {
+		if err == nil {
+			break
+		}
+		if legacy, errLegacy := signer.UnSign(signedvalue); errLegacy == nil {
+			return legacy, nil
+		}
+	}
+	return value, err
+}
+
+func b62encode(n int64) string {
+	if n == 0 {
+		return "0"
+	}
+	sign := ""
+	u := uint64(n)
+	if n < 0 {
+		sign, u = "-", uint64(-n)
+	}
+	var encoded []byte
+	for ; u > 0; u /= 62 {
+		encoded = append([]byte{base62Alphabet[u%62]}, encoded...)
+	}
+	return sign + string(encoded)
+}
+
+func b62decode(encoded []byte) (int64, error) {
+	sign := int64(1)
+	if len(encoded) > 0 && encoded[0] == '-' {
+		sign, encoded = -1, encoded[1:]
+	}
+	if len(encoded) == 0 {
+		return 0, fmt.Errorf("empty base62 number")
+	}
+	var decoded int64
+	for _, c := range encoded {
+		digit := strings.IndexByte(base62Alphabet, c)
+		if digit == -1 {
+			return 0, fmt.Errorf("invalid base62 digit %q", c)
+		}
+		if decoded > (math.MaxInt64-int64(digit))/62 {
+			return 0, fmt.Errorf("base62 number overflows")
+		}
+		decoded = decoded*62 + int64(digit)
+	}
+	return sign * decoded, nil
+}
+
+// DjangoSerializer dumps and loads objects as django.core.signing.dumps and
+// loads do: the payload is the unpadded URL-safe base64 of the dumped
+// object, prefixed with "." when it is zlib-compressed, and it is signed by
+// a DjangoSigner with a timestamp. Salt is DjangoDumpsSalt if empty; Django
+// signs its cookie sessions with DjangoSessionSalt and Compress.
+//
+// With Compress, payloads are compressed when that makes them shorter, as
+// with compress=True. Compressed payloads load either way, but Go's zlib
+// output differs from Python's, so compressed tokens are not byte-identical
+// to Django's.
+type DjangoSerializer struct {
+	Secret       string
+	SecretKeys   []string // oldest to newest
+	Salt         string
+	DigestMethod func() hash.Hash // sha256 if nil
+	Legacy       bool
+	Compress     bool
+	SerializerOP JSONAPI // DjangoJSON if nil
+	Clock        Clock   // DefaultClock if nil
+	Leeway       int64   // seconds
+
+	signer      DjangoSigner
+	initialized bool
+}
+
+// NewDjangoSerializer returns a validated DjangoSerializer. It accepts the
+// options of NewDjangoSigner, WithCompress and WithSerializer.
+func NewDjangoSerializer(secret string, opts ...Option) (DjangoSerializer, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return DjangoSerializer{}, err
+	}
+	ds := DjangoSerializer{
+		Secret:       secret,
+		SecretKeys:   o.secretKeys,
+		Salt:         o.salt,
+		DigestMethod: o.digestMethod,
+		Legacy:       o.djangoLegacy,
+		Compress:     o.compress,
+		SerializerOP: o.serializer,
+		Clock:        o.clock,
+		Leeway:       o.leeway,
+	}
+	if err := ds.init(); err != nil {
+		return DjangoSerializer{}, err
+	}
+	return ds, nil
+}
+
+func (ds *DjangoSerializer) init() error {
+	if ds.initialized {
+		return nil
+	}
+	if ds.Secret == "" && len(ds.SecretKeys) == 0 {
+		return fmt.Errorf("DjangoSerializer secret is empty")
+	}
+	ds.SetDefault()
+	ds.initialized = true
+	return nil
+}
+
+// SetDefault fills in the defaults of a DjangoSerializer built as a struct
+// literal.
+func (ds *DjangoSerializer) SetDefault() {
+	if ds.initialized {
+		return
+	}
+	if ds.Salt == "" {
+		ds.Salt = DjangoDumpsSalt
+	}
+	if ds.SerializerOP == nil {
+		ds.SerializerOP = DjangoJSON{}
+	}
+	ds.signer = DjangoSigner{
+		Secret:       ds.Secret,
+		SecretKeys:   ds.SecretKeys,
+		Salt:         ds.Salt,
+		DigestMethod: ds.DigestMethod,
+		Legacy:       ds.Legacy,
+		Clock:        ds.Clock,
+		Leeway:       ds.Leeway,
+	}
+	ds.signer.SetDefault()
+}
+
+// Dumps returns obj signed with a timestamp, as signing.dumps.
+func (ds DjangoSerializer) Dumps(obj interface{}) ([]byte, error) {
+	(&ds).SetDefault()
+	data, err := DumpPayload(obj, ds.SerializerOP)
+	if err != nil {
+		return BlankBytes, err
+	}
+	payload := WantBytes(B64encode(WantBytes(data)))
+	if ds.Compress {
+		payload, _ = PreURLSafeDumpPayload(WantBytes(data))
+	}
+	return ds.signer.SignTimestampE(string(payload))
+}
+
+// Loads returns the object of a token from Dumps, as signing.loads. Unless
+// MaxAge is negative, tokens older than MaxAge seconds are refused. Like
+// Serializer.TimedLoads, the object is also returned with a SignatureExpired.
+func (ds DjangoSerializer) Loads(s string, MaxAge int64) (interface{}, error) {
+	(&ds).SetDefault()
+	value, _, err := ds.signer.UnSignTimestamp(s, MaxAge)
+	if err != nil && !errors.Is(err, ErrBadTimeSignature) {
+		return nil, err
+	}
+	obj, errload := URLSafeLoadPayload(value, ds.SerializerOP)
+	if err == nil {
+		err = errload
+	}
+	return obj, err
+}
+
+// LoadsInto is Loads that decodes the object into dst.
+func (ds DjangoSerializer) LoadsInto(s string, MaxAge int64, dst interface{}) error {
+	(&ds).SetDefault()
+	value, _, err := ds.signer.UnSignTimestamp(s, MaxAge)
+	if err != nil && !errors.Is(err, ErrBadTimeSignature) {
+		return err
+	}
+	if errload := URLSafeLoadPayloadInto(value, ds.SerializerOP, dst); err == nil {
+		err = errload
+	}
+	return err
+}
+
+// DjangoJSON is the JSONAPI of Django's JSONSerializer. It dumps compact JSON
+// with non-ASCII characters escaped, as json.dumps(separators=(",", ":"))
+// does, and unlike JSON it leaves <, > and & alone.
+type DjangoJSON struct {
+}
+
+func (dj DjangoJSON) Load(data []byte) (interface{}, error) {
+	return JSON{}.Load(data)
+}
+
+func (dj DjangoJSON) Dump(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	var ascii strings.Builder
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		if r < 0x7f {
+			ascii.WriteRune(r)
+			continue
+		}
+		for _, unit := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&ascii, `\u%04x`, unit)
+		}
+	}
+	return ascii.String(), nil
+}
+
+func (dj DjangoJSON) LoadInto(data []byte, v interface{}) error {
+	return JSON{}.LoadInto(data, v)
+}
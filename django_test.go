@@ -0,0 +1,159 @@
+package dangerous
+
+import (
+	"crypto/sha1"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+func TestDjangoSignerVectors(t *testing.T) {
+	// From Django's test suite and django.core.signing.
+	for _, v := range []struct {
+		signer DjangoSigner
+		value  string
+		signed string
+	}{
+		{DjangoSigner{Secret: "\xe7"}, "foo", "foo:EE4qGC5MEKyQG5msxYA0sBohAxLC0BJf8uRhemh0BGU"},
+		{DjangoSigner{Secret: "predictable-secret"}, "hello", "hello:T8oWtiMIRTzcoR3NRO-2PQNf5dTweZy0EL25Kt6lUo0"},
+	} {
+		if signed, err := v.signer.SignE(v.value); err != nil || string(signed) != v.signed {
+			t.Fatalf("Got %s, expected %s. Error:%v", signed, v.signed, err)
+		}
+		if value, err := v.signer.UnSign(v.signed); err != nil || string(value) != v.value {
+			t.Fatalf("Got %s, expected %s. Error:%v", value, v.value, err)
+		}
+	}
+
+	clock := dangeroustest.NewFakeClock(time.Unix(123456789, 0))
+	ds, _ := NewDjangoSigner("predictable-secret", WithClock(clock))
+	signed := "hello:8M0kX:nXbF104UdRf8vt2AwdpfwkaosYWTI_M3eizzUfVYqKM"
+	if got, err := ds.SignTimestampE("hello"); err != nil || string(got) != signed {
+		t.Fatalf("Got %s, expected %s. Error:%v", got, signed, err)
+	}
+	clock.Advance(10 * time.Second)
+	if value, ts, err := ds.UnSignTimestamp(signed, 10); err != nil || string(value) != "hello" || ts != 123456789 {
+		t.Fatalf("Got %s at %d. Error:%v", value, ts, err)
+	}
+	clock.Advance(time.Second)
+	if value, _, err := ds.UnSignTimestamp(signed, 10); !errors.Is(err, ErrSignatureExpired) || string(value) != "hello" {
+		t.Fatalf("Expected SignatureExpired, got %v", err)
+	}
+	if _, err := ds.UnSign(signed); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Timestamp signatures should use another salt.")
+	}
+}
+
+func TestDjangoSerializerVectors(t *testing.T) {
+	obj := map[string]interface{}{"a": 1, "b": "<tag> & café \U0001f600", "c": []interface{}{true, nil}}
+	clock := dangeroustest.NewFakeClock(time.Unix(1600000000, 0))
+	for _, v := range []struct {
+		opts  []Option
+		token string
+	}{
+		{nil, "eyJhIjoxLCJiIjoiPHRhZz4gJiBjYWZcdTAwZTkgXHVkODNkXHVkZTAwIiwiYyI6W3RydWUsbnVsbF19:1kHR5c:WimWv0L9_BCaP8my0NrxjZOcxY9bRRH-eL8DxbPqW8o"},
+		{[]Option{WithDigestMethod(sha1.New)}, "eyJhIjoxLCJiIjoiPHRhZz4gJiBjYWZcdTAwZTkgXHVkODNkXHVkZTAwIiwiYyI6W3RydWUsbnVsbF19:1kHR5c:kqUSbTXI6q6h9ZKgF0BW-XkcIpc"},
+	} {
+		ds, err := NewDjangoSerializer("secret-key", append(v.opts, WithClock(clock))...)
+		if err != nil {
+			t.Fatalf("Unexpected error:%s", err)
+		}
+		token, err := ds.Dumps(obj)
+		if err != nil || string(token) != v.token {
+			t.Fatalf("Got %s, expected %s. Error:%v", token, v.token, err)
+		}
+		loaded, err := ds.Loads(v.token, 10)
+		if err != nil || loaded.(map[string]interface{})["b"] != obj["b"] {
+			t.Fatalf("Loading failed. Got %v, error:%v", loaded, err)
+		}
+	}
+
+	// A cookie session, compressed by Python's zlib.
+	session := ".eJyrVopPLC3JiC8tTi2KT0pMzk7NS1GyUkrJSsxLz9dLzs8rKcpM0gMp0YPKFuv55qek5jhB1eogG5CRWJwB1G1AIUA1NBPkIEOlWgCz4zJN:1kHR5c:5pLDNcesTG35gZoUdA-_XuO3Ljjo17PivKegJmDnrVA"
+	ds, _ := NewDjangoSerializer("secret-key", WithSalt(DjangoSessionSalt), WithCompress(), WithClock(clock))
+	var user struct {
+		ID      string `json:"_auth_user_id"`
+		Backend string `json:"_auth_user_backend"`
+	}
+	if err := ds.LoadsInto(session, 1209600, &user); err != nil || user.ID != "1" || user.Backend != "django.contrib.auth.backends.ModelBackend" {
+		t.Fatalf("Loading the session failed. Got %+v, error:%v", user, err)
+	}
+	token, _ := ds.Dumps(map[string]string{"_auth_user_id": "1", "_auth_user_hash": "0000000000000000000000000000000000000000000000000000000000000000"})
+	if token[0] != '.' {
+		t.Fatalf("Payload should be compressed: %s", token)
+	}
+	if loaded, err := ds.Loads(string(token), 10); err != nil || loaded.(map[string]interface{})["_auth_user_id"] != "1" {
+		t.Fatalf("Loading failed. Error:%v", err)
+	}
+}
+
+func TestDjangoLegacy(t *testing.T) {
+	// Signed with sha1 by Django 3.1's test suite.
+	signed := "ImEgc3RyaW5nIFx1MjAyMCI:1k1beT:ZfNhN1kdws7KosUleOvuYroPHEc"
+	ds, _ := NewDjangoSerializer("django_tests_secret_key")
+	if _, err := ds.Loads(signed, -1); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("sha1 signatures should need Legacy.")
+	}
+	legacy, _ := NewDjangoSerializer("django_tests_secret_key", WithDjangoLegacy())
+	if loaded, err := legacy.Loads(signed, -1); err != nil || loaded != "a string †" {
+		t.Fatalf("Got %v. Error:%v", loaded, err)
+	}
+	token, _ := legacy.Dumps("value")
+	if _, err := ds.Loads(string(token), 10); err != nil {
+		t.Fatalf("Legacy should still sign with sha256. Error:%s", err)
+	}
+}
+
+func TestDjangoSecretKeys(t *testing.T) {
+	old, _ := NewDjangoSigner("secret")
+	current, _ := NewDjangoSigner("newsecret", WithSecretKeys("secret", "othersecret", "newsecret"))
+	signed := old.Sign("abc")
+	if value, err := current.UnSign(string(signed)); err != nil || string(value) != "abc" {
+		t.Fatalf("Fallback keys should verify. Error:%v", err)
+	}
+	signed = current.Sign("abc")
+	if _, err := old.UnSign(string(signed)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("The newest key should sign.")
+	}
+	if _, err := NewDjangoSigner(""); err == nil {
+		t.Fatalf("An empty secret should be refused.")
+	}
+}
+
+func TestDjangoInvalid(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1600000000, 0))
+	ds, _ := NewDjangoSigner("secret-key", WithSalt("salt"), WithClock(clock))
+	for _, value := range []string{"abc", "abc:", "abc:-", "abc:zzzzzzzzzzzz", "abc:1!"} {
+		signed := ds.Sign(value)
+		if _, _, err := ds.UnSignTimestamp(string(signed), -1); !errors.Is(err, ErrBadTimeSignature) {
+			t.Fatalf("%q: expected BadTimeSignature, got %v", value, err)
+		}
+	}
+	future := ds.Sign("abc:" + b62encode(1600000001))
+	if _, _, err := ds.UnSignTimestamp(string(future), 60); !errors.Is(err, ErrSignatureFromFuture) {
+		t.Fatalf("Expected SignatureFromFuture, got %v", err)
+	}
+	if _, err := ds.UnSign("abc"); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("A value without separator should be refused.")
+	}
+	for _, n := range []int64{0, 1, 61, 62, -5, 1600000000, math.MaxInt64, math.MinInt64 + 1} {
+		if decoded, err := b62decode([]byte(b62encode(n))); err != nil || decoded != n {
+			t.Fatalf("%d: got %d. Error:%v", n, decoded, err)
+		}
+	}
+}
+
+func TestDjangoJSON(t *testing.T) {
+	dumped, err := DjangoJSON{}.Dump(map[string]interface{}{"b": []int{1}, "a": "é <>"})
+	if err != nil || dumped != `{"a":"\u00e9 <>","b":[1]}` {
+		t.Fatalf("Got %s. Error:%v", dumped, err)
+	}
+	loaded, err := DjangoJSON{}.Load([]byte(dumped))
+	if err != nil || !reflect.DeepEqual(loaded, map[string]interface{}{"a": "é <>", "b": []interface{}{1.0}}) {
+		t.Fatalf("Got %v. Error:%v", loaded, err)
+	}
+}
@@ -15,6 +15,11 @@ func WantBytes(str string, chartype ...interface{}) []byte {
 	if len(types) == 1 {
 		types = chartype[0].(string)
 	}
+	// Go strings are already UTF-8 bytes. Decoding them again would replace
+	// the invalid sequences that binary payloads are made of.
+	if types == "utf-8" {
+		return []byte(str)
+	}
 	r, err := charset.NewReader(strings.NewReader(str), types)
 	if err != nil && !strings.Contains(err.Error(), "EOF") {
 		panic(fmt.Sprintf("Erorr occurred when using WantBytes, error:%s input:%s", err.Error(), str))
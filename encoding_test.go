@@ -21,6 +21,7 @@ var (
 			181}},
 		{"Prüfung", []byte{80, 114, 195, 188, 102, 117, 110, 103}},
 		{"테스트", []byte{237, 133, 140, 236, 138, 164, 237, 138, 184}},
+		{"\x00\xff\xc3", []byte{0, 255, 195}}, // binary payloads are kept as they are
 	}
 
 	ValidB64 = []struct {
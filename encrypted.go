@@ -0,0 +1,423 @@
+package dangerous
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Ciphers of an EncryptedSerializer.
+const (
+	CipherAES256GCM     = "aes-256-gcm"
+	CipherAES256CBCHMAC = "aes-256-cbc-hmac-sha256"
+)
+
+var cipherIDs = map[string]byte{
+	CipherAES256GCM:     1,
+	CipherAES256CBCHMAC: 2,
+}
+
+// EncryptedSerializer is a Serializer whose tokens are encrypted as well as
+// authenticated, so that their payload can not be read without the secret.
+//
+// Its keys are derived from the secret like the key of a Signer, with
+// KeyDerivation and DigestMethod, from Salt plus a suffix naming their
+// purpose, so they never equal the key of a Signer with the same secret and
+// salt, nor each other. The "none" key derivation, which would make the
+// secret itself every key, is refused. The digest must give at least 32
+// bytes, SHA-256 by default. SecretKeys is ordered from oldest to newest:
+// tokens are encrypted with the newest key and decrypted with any of them.
+//
+// A token is a cipher byte, a flag byte telling whether it is timed, the
+// timestamp of a timed token, and the ciphertext, all of it authenticated.
+// With CipherAES256GCM the ciphertext is a 12 byte nonce followed by the
+// sealed payload. With CipherAES256CBCHMAC it is a 16 byte IV, the padded
+// AES-256-CBC ciphertext and an HMAC-SHA256 of everything before it
+// (encrypt-then-MAC).
+//
+// Errors never hold the plaintext. Payloads are not compressed, since that
+// would leak information about them through the token length.
+type EncryptedSerializer struct {
+	Secret        string
+	SecretKeys    []string // oldest to newest
+	Salt          string
+	Cipher        string // CipherAES256GCM if empty
+	KeyDerivation string
+	DigestMethod  func() hash.Hash // sha256.New if nil
+	SerializerOP  JSONAPI
+	Codec         Codec // replaces SerializerOP if set
+	Clock         Clock // DefaultClock if nil
+	Leeway        int64 // seconds
+
+	signer      Signer
+	encKeys     [][]byte // oldest to newest
+	macKeys     [][]byte
+	initialized bool
+}
+
+// NewEncryptedSerializer returns a validated EncryptedSerializer with its keys
+// derived once. Like NewSigner, the result can be shared between goroutines as
+// long as its fields are not changed.
+func NewEncryptedSerializer(secret string, opts ...Option) (EncryptedSerializer, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return EncryptedSerializer{}, err
+	}
+	es := EncryptedSerializer{
+		Secret:        secret,
+		SecretKeys:    o.secretKeys,
+		Salt:          o.salt,
+		Cipher:        o.cipher,
+		KeyDerivation: o.keyDerivation,
+		DigestMethod:  o.digestMethod,
+		SerializerOP:  o.serializer,
+		Codec:         o.codec,
+		Clock:         o.clock,
+		Leeway:        o.leeway,
+	}
+	if err := es.init(); err != nil {
+		return EncryptedSerializer{}, err
+	}
+	return es, nil
+}
+
+func (es *EncryptedSerializer) init() error {
+	if es.initialized {
+		return nil
+	}
+	if es.Secret == "" && len(es.SecretKeys) == 0 {
+		return fmt.Errorf("EncryptedSerializer secret is empty")
+	}
+	es.SetDefault()
+	if _, ok := cipherIDs[es.Cipher]; !ok {
+		return fmt.Errorf("Unknown cipher %q", es.Cipher)
+	}
+	if es.KeyDerivation == "none" {
+		return fmt.Errorf(`EncryptedSerializer can not use the "none" key derivation`)
+	}
+	var err error
+	if es.encKeys, err = es.deriveKeys("encrypt"); err != nil {
+		return err
+	}
+	if es.Cipher == CipherAES256CBCHMAC {
+		if es.macKeys, err = es.deriveKeys("mac"); err != nil {
+			return err
+		}
+	}
+	es.initialized = true
+	return nil
+}
+
+// SetDefault fills in the defaults of a serializer built as a struct literal.
+func (es *EncryptedSerializer) SetDefault() {
+	if es.initialized {
+		return
+	}
+	if len(es.SecretKeys) > 0 {
+		es.Secret = es.SecretKeys[len(es.SecretKeys)-1]
+	}
+	if es.Salt == "" {
+		es.Salt = "itsdangerous"
+	}
+	if es.Cipher == "" {
+		es.Cipher = CipherAES256GCM
+	}
+	if es.Codec != nil {
+		es.SerializerOP = codecJSONAPI(es.Codec)
+	}
+	if es.SerializerOP == nil {
+		es.SerializerOP = JSON{}
+	}
+	if es.DigestMethod == nil {
+		es.DigestMethod = sha256.New
+	}
+	es.signer = Signer{
+		Secret:        es.Secret,
+		SecretKeys:    es.SecretKeys,
+		Salt:          es.Salt,
+		KeyDerivation: es.KeyDerivation,
+		DigestMethod:  es.DigestMethod,
+		Clock:         es.Clock,
+		Leeway:        es.Leeway,
+	}
+	es.signer.SetDefault()
+}
+
+// deriveKeys derives a 32 byte key from every secret, oldest to newest.
+func (es EncryptedSerializer) deriveKeys(purpose string) ([][]byte, error) {
+	signer := es.signer
+	signer.Salt = es.Salt + "." + purpose
+	signer.SaltBytes = WantBytes(signer.Salt)
+	keys := make([][]byte, len(signer.SecretKeysBytes))
+	for p, secret := range signer.SecretKeysBytes {
+		key, err := signer.deriveKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("Derived key is %d bytes, %s needs 32", len(key), es.Cipher)
+		}
+		keys[p] = key[:32]
+	}
+	return keys, nil
+}
+
+// seal encrypts the dumped obj with the newest key.
+func (es EncryptedSerializer) seal(obj interface{}, timed bool) ([]byte, error) {
+	if err := (&es).init(); err != nil {
+		return BlankBytes, err
+	}
+	dumped, err := es.SerializerOP.Dump(obj)
+	if err != nil {
+		return BlankBytes, err
+	}
+	header := []byte{cipherIDs[es.Cipher], 0}
+	if timed {
+		header[1] = 1
+		header = appendUint64(header, uint64(es.signer.GetTimestamp()))
+	}
+	newest := len(es.encKeys) - 1
+	if es.Cipher == CipherAES256CBCHMAC {
+		return sealCBCHMAC(es.encKeys[newest], es.macKeys[newest], header, []byte(dumped))
+	}
+	return sealGCM(es.encKeys[newest], header, []byte(dumped))
+}
+
+// open authenticates and decrypts token, trying the newest key first, and
+// checks its timestamp if timed. The plaintext is nil if the token could not
+// be decrypted, and is also returned with the error of an outdated token.
+func (es EncryptedSerializer) open(token []byte, timed bool, MaxAge int64) ([]byte, error) {
+	if err := (&es).init(); err != nil {
+		return nil, err
+	}
+	headerSize := 2
+	if timed {
+		headerSize += 8
+	}
+	if len(token) < headerSize {
+		return nil, newBadSignature(nil, nil, "Token is too short")
+	}
+	if token[0] != cipherIDs[es.Cipher] {
+		return nil, newBadSignature(nil, nil, "Token was not encrypted with "+es.Cipher)
+	}
+	if timed != (token[1] == 1) || token[1] > 1 {
+		if timed {
+			return nil, newBadTimeSignature(nil, time.Time{}, nil, "timestamp missing")
+		}
+		return nil, newBadSignature(nil, nil, "Token is timed")
+	}
+	header, body := token[:headerSize], token[headerSize:]
+	var plaintext []byte
+	var ok bool
+	for p := len(es.encKeys) - 1; p >= 0 && !ok; p-- {
+		if es.Cipher == CipherAES256CBCHMAC {
+			plaintext, ok = openCBCHMAC(es.encKeys[p], es.macKeys[p], header, body)
+		} else {
+			plaintext, ok = openGCM(es.encKeys[p], header, body)
+		}
+	}
+	if !ok {
+		return nil, newBadSignature(nil, nil, "Token could not be decrypted")
+	}
+	if plaintext == nil {
+		plaintext = []byte{}
+	}
+	if timed {
+		timestamp := int64(binary.BigEndian.Uint64(header[2:]))
+		return plaintext, es.signer.checkTimestamp(nil, timestamp, MaxAge)
+	}
+	return plaintext, nil
+}
+
+func sealGCM(key, header, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return BlankBytes, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return BlankBytes, err
+	}
+	token := append(append([]byte(nil), header...), nonce...)
+	return aead.Seal(token, nonce, plaintext, header), nil
+}
+
+func openGCM(key, header, body []byte) ([]byte, bool) {
+	aead, err := newGCM(key)
+	if err != nil || len(body) < aead.NonceSize()+aead.Overhead() {
+		return nil, false
+	}
+	nonce, sealed := body[:aead.NonceSize()], body[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, header)
+	return plaintext, err == nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealCBCHMAC(encKey, macKey, header, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return BlankBytes, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte(nil), plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	token := make([]byte, len(header)+aes.BlockSize+len(padded))
+	copy(token, header)
+	iv := token[len(header) : len(header)+aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return BlankBytes, err
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(token[len(header)+aes.BlockSize:], padded)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(token)
+	return mac.Sum(token), nil
+}
+
+func openCBCHMAC(encKey, macKey, header, body []byte) ([]byte, bool) {
+	if len(body) < aes.BlockSize*2+sha256.Size || (len(body)-sha256.Size)%aes.BlockSize != 0 {
+		return nil, false
+	}
+	body, tag := body[:len(body)-sha256.Size], body[len(body)-sha256.Size:]
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(header)
+	mac.Write(body)
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		return nil, false
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, false
+	}
+	iv, ciphertext := body[:aes.BlockSize], body[aes.BlockSize:]
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, false
+	}
+	return plaintext[:len(plaintext)-padding], true
+}
+
+func (es EncryptedSerializer) load(token []byte, timed bool, MaxAge int64) (interface{}, error) {
+	plaintext, err := es.open(token, timed, MaxAge)
+	if plaintext == nil {
+		return nil, err
+	}
+	(&es).SetDefault()
+	payload, errload := LoadPayload(plaintext, es.SerializerOP)
+	if err == nil {
+		err = errload
+	}
+	return payload, err
+}
+
+func (es EncryptedSerializer) loadInto(token []byte, timed bool, MaxAge int64, dst interface{}) error {
+	plaintext, err := es.open(token, timed, MaxAge)
+	if plaintext == nil {
+		return err
+	}
+	(&es).SetDefault()
+	if errload := LoadPayloadInto(plaintext, es.SerializerOP, dst); err == nil {
+		err = errload
+	}
+	return err
+}
+
+// urlSafeToken decodes the base64 of a URL-safe token.
+func urlSafeToken(s string) ([]byte, error) {
+	token, err := B64decode(WantBytes(s))
+	if err != nil {
+		return BlankBytes, newBadSignature(nil, err, "Could not base64 decode the token")
+	}
+	return token, nil
+}
+
+func (es EncryptedSerializer) Dumps(obj interface{}) ([]byte, error) {
+	return es.seal(obj, false)
+}
+
+func (es EncryptedSerializer) Loads(s string) (interface{}, error) {
+	return es.load(WantBytes(s), false, -1)
+}
+
+func (es EncryptedSerializer) LoadsInto(s string, dst interface{}) error {
+	return es.loadInto(WantBytes(s), false, -1, dst)
+}
+
+func (es EncryptedSerializer) TimedDumps(obj interface{}) ([]byte, error) {
+	return es.seal(obj, true)
+}
+
+// TimedLoads is Loads for a token from TimedDumps, refused if it is older
+// than MaxAge seconds, unless MaxAge is negative. Like Serializer.TimedLoads,
+// the payload is also returned with a SignatureExpired.
+func (es EncryptedSerializer) TimedLoads(s string, MaxAge int64) (interface{}, error) {
+	return es.load(WantBytes(s), true, MaxAge)
+}
+
+func (es EncryptedSerializer) TimedLoadsInto(s string, MaxAge int64, dst interface{}) error {
+	return es.loadInto(WantBytes(s), true, MaxAge, dst)
+}
+
+func (es EncryptedSerializer) URLSafeDumps(obj interface{}) ([]byte, error) {
+	token, err := es.seal(obj, false)
+	if err != nil {
+		return token, err
+	}
+	return WantBytes(B64encode(token)), nil
+}
+
+func (es EncryptedSerializer) URLSafeLoads(s string) (interface{}, error) {
+	token, err := urlSafeToken(s)
+	if err != nil {
+		return nil, err
+	}
+	return es.load(token, false, -1)
+}
+
+func (es EncryptedSerializer) URLSafeLoadsInto(s string, dst interface{}) error {
+	token, err := urlSafeToken(s)
+	if err != nil {
+		return err
+	}
+	return es.loadInto(token, false, -1, dst)
+}
+
+func (es EncryptedSerializer) URLSafeTimedDumps(obj interface{}) ([]byte, error) {
+	token, err := es.seal(obj, true)
+	if err != nil {
+		return token, err
+	}
+	return WantBytes(B64encode(token)), nil
+}
+
+func (es EncryptedSerializer) URLSafeTimedLoads(s string, MaxAge int64) (interface{}, error) {
+	token, err := urlSafeToken(s)
+	if err != nil {
+		return nil, err
+	}
+	return es.load(token, true, MaxAge)
+}
+
+func (es EncryptedSerializer) URLSafeTimedLoadsInto(s string, MaxAge int64, dst interface{}) error {
+	token, err := urlSafeToken(s)
+	if err != nil {
+		return err
+	}
+	return es.loadInto(token, true, MaxAge, dst)
+}
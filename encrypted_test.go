@@ -0,0 +1,179 @@
+package dangerous
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+var encryptedCiphers = []string{CipherAES256GCM, CipherAES256CBCHMAC}
+
+func TestEncryptedSerializer(t *testing.T) {
+	obj := map[string]interface{}{"email": "user@example.com", "id": "5"}
+	for _, name := range encryptedCiphers {
+		es, err := NewEncryptedSerializer("secret-key", WithCipher(name))
+		if err != nil {
+			t.Fatalf("%s: unexpected error:%s", name, err)
+		}
+		for _, v := range []struct {
+			dumps func(interface{}) ([]byte, error)
+			loads func(string) (interface{}, error)
+		}{
+			{es.Dumps, es.Loads},
+			{es.URLSafeDumps, es.URLSafeLoads},
+			{es.TimedDumps, func(s string) (interface{}, error) { return es.TimedLoads(s, 10) }},
+			{es.URLSafeTimedDumps, func(s string) (interface{}, error) { return es.URLSafeTimedLoads(s, 10) }},
+		} {
+			token, err := v.dumps(obj)
+			if err != nil {
+				t.Fatalf("%s: unexpected error:%s", name, err)
+			}
+			if bytes.Contains(token, []byte("user")) || bytes.Contains(token, []byte("dXNlc")) {
+				t.Fatalf("%s: the payload can be read from the token %q", name, token)
+			}
+			loaded, err := v.loads(string(token))
+			if err != nil || loaded.(map[string]interface{})["email"] != "user@example.com" {
+				t.Fatalf("%s: loading failed. Error:%v", name, err)
+			}
+			again, _ := v.dumps(obj)
+			if bytes.Equal(token, again) {
+				t.Fatalf("%s: tokens should not repeat.", name)
+			}
+		}
+
+		token, _ := es.Dumps(obj)
+		for i := range token {
+			tampered := append([]byte(nil), token...)
+			tampered[i] ^= 1
+			if _, err := es.Loads(string(tampered)); !errors.Is(err, ErrBadSignature) {
+				t.Fatalf("%s: tampered byte %d should not decrypt.", name, i)
+			}
+		}
+		if _, err := es.Loads(string(token[:len(token)-1])); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: truncated token should not decrypt.", name)
+		}
+		if _, err := es.TimedLoads(string(token), 10); !errors.Is(err, ErrBadTimeSignature) {
+			t.Fatalf("%s: untimed token should not load as timed. Error:%v", name, err)
+		}
+		other, _ := NewEncryptedSerializer("other-key", WithCipher(name))
+		if _, err := other.Loads(string(token)); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: another key should not decrypt.", name)
+		}
+	}
+
+	gcm, _ := NewEncryptedSerializer("secret-key")
+	cbc, _ := NewEncryptedSerializer("secret-key", WithCipher(CipherAES256CBCHMAC))
+	token, _ := gcm.Dumps(obj)
+	if _, err := cbc.Loads(string(token)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("A token should only load with its cipher.")
+	}
+}
+
+func TestEncryptedTimed(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	for _, name := range encryptedCiphers {
+		es, _ := NewEncryptedSerializer("secret-key", WithCipher(name), WithClock(clock), WithLeeway(1))
+		token, _ := es.URLSafeTimedDumps("value")
+		clock.Set(time.Unix(1580000011, 0))
+		if loaded, err := es.URLSafeTimedLoads(string(token), 10); err != nil || loaded != "value" {
+			t.Fatalf("%s: leeway should accept the token. Error:%v", name, err)
+		}
+		clock.Advance(time.Second)
+		loaded, err := es.URLSafeTimedLoads(string(token), 10)
+		var expired *SignatureExpired
+		if !errors.As(err, &expired) || loaded != "value" || expired.Payload != nil {
+			t.Fatalf("%s: expected SignatureExpired without the payload, got %v", name, err)
+		}
+		if !expired.DateSigned.Equal(time.Unix(1580000000, 0)) {
+			t.Fatalf("%s: unexpected signing date %s", name, expired.DateSigned)
+		}
+		clock.Set(time.Unix(1580000000-2, 0))
+		if _, err := es.URLSafeTimedLoads(string(token), 10); !errors.Is(err, ErrSignatureFromFuture) {
+			t.Fatalf("%s: expected SignatureFromFuture, got %v", name, err)
+		}
+		clock.Set(time.Unix(1580000000, 0))
+		if _, err := es.URLSafeLoads(string(token)); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: timed token should not load as untimed.", name)
+		}
+	}
+}
+
+func TestEncryptedRotation(t *testing.T) {
+	old, _ := NewEncryptedSerializer("old-key")
+	token, _ := old.URLSafeDumps("value")
+	rotated, _ := NewEncryptedSerializer("", WithSecretKeys("old-key", "new-key"))
+	if loaded, err := rotated.URLSafeLoads(string(token)); err != nil || loaded != "value" {
+		t.Fatalf("Rotated serializer should decrypt the old key. Error:%v", err)
+	}
+	token, _ = rotated.URLSafeDumps("value")
+	if _, err := old.URLSafeLoads(string(token)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Rotated serializer should encrypt with the new key.")
+	}
+	current, _ := NewEncryptedSerializer("new-key")
+	if loaded, err := current.URLSafeLoads(string(token)); err != nil || loaded != "value" {
+		t.Fatalf("Loading with the new key failed. Error:%v", err)
+	}
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	es := EncryptedSerializer{Secret: "secret-key", Salt: "auth", DigestMethod: sha512.New, KeyDerivation: "hmac"}
+	token, err := es.Dumps("value")
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if loaded, err := es.Loads(string(token)); err != nil || loaded != "value" {
+		t.Fatalf("Loading failed. Error:%v", err)
+	}
+	salted := es
+	salted.Salt = "other"
+	if _, err := salted.Loads(string(token)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Another salt should not decrypt.")
+	}
+	if _, err := NewEncryptedSerializer("secret-key", WithDigestMethod(sha1.New)); err == nil {
+		t.Fatalf("A 20 byte key should be refused.")
+	}
+	if _, err := NewEncryptedSerializer(strings.Repeat("k", 32), WithKeyDerivation("none")); err == nil {
+		t.Fatalf("A secret without key derivation should be refused.")
+	}
+	literal := EncryptedSerializer{Secret: strings.Repeat("k", 32), KeyDerivation: "none", Cipher: CipherAES256CBCHMAC}
+	if _, err := literal.Dumps("value"); err == nil {
+		t.Fatalf("A secret without key derivation should be refused.")
+	}
+	if _, err := NewEncryptedSerializer(""); err == nil {
+		t.Fatalf("An empty secret should be refused.")
+	}
+	if _, err := NewEncryptedSerializer("secret-key", WithCipher("rot13")); err == nil {
+		t.Fatalf("An unknown cipher should be refused.")
+	}
+
+	signer := Signer{Secret: "secret-key", Salt: "itsdangerous"}
+	signingKey, _ := signer.DeriveKey()
+	es, _ = NewEncryptedSerializer("secret-key")
+	if bytes.Equal(es.encKeys[0], signingKey) {
+		t.Fatalf("Encryption key should differ from the signing key.")
+	}
+}
+
+func TestEncryptedLoadsInto(t *testing.T) {
+	es, _ := NewEncryptedSerializer("secret-key", WithCodec(CBORCodec{}))
+	token, _ := es.URLSafeTimedDumps(bigUser)
+	var user testUser
+	if err := es.URLSafeTimedLoadsInto(string(token), 10, &user); err != nil || user.ID != bigUser.ID {
+		t.Fatalf("Loading failed. Got %v, error:%v", user, err)
+	}
+	token, _ = es.Dumps(bigUser)
+	user = testUser{}
+	if err := es.LoadsInto(string(token), &user); err != nil || user.Name != bigUser.Name {
+		t.Fatalf("Loading failed. Got %v, error:%v", user, err)
+	}
+	var wrong []string
+	if err := es.LoadsInto(string(token), &wrong); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+}
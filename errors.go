@@ -0,0 +1,186 @@
+package dangerous
+
+import (
+	"errors"
+	"time"
+)
+
+// Sentinels for errors.Is. Every error type below reports itself as its own
+// sentinel and as the sentinels of its parents, so
+// errors.Is(err, ErrBadSignature) is true for a SignatureExpired as well.
+var (
+	ErrBadData             = errors.New("BadData")
+	ErrBadSignature        = errors.New("BadSignature")
+	ErrBadTimeSignature    = errors.New("BadTimeSignature")
+	ErrSignatureExpired    = errors.New("SignatureExpired")
+	ErrSignatureFromFuture = errors.New("SignatureFromFuture")
+	ErrBadHeader           = errors.New("BadHeader")
+	ErrBadPayload          = errors.New("BadPayload")
+)
+
+// BadData is the base of all the errors raised when bad data of any sort
+// was encountered.
+type BadData struct {
+	Message string
+}
+
+func (e *BadData) Error() string {
+	return e.Message
+}
+
+func (e *BadData) Is(target error) bool {
+	return target == ErrBadData
+}
+
+// BadSignature is returned if a signature does not match. Payload holds the
+// unverified payload, if one could be split from the token; it must not be
+// trusted. Cause is the error that made the token unreadable, if any.
+type BadSignature struct {
+	BadData
+	Payload []byte
+	Cause   error
+}
+
+func (e *BadSignature) Is(target error) bool {
+	return target == ErrBadSignature || e.BadData.Is(target)
+}
+
+func (e *BadSignature) As(target interface{}) bool {
+	if t, ok := target.(**BadData); ok {
+		*t = &e.BadData
+		return true
+	}
+	return false
+}
+
+func (e *BadSignature) Unwrap() error {
+	return e.Cause
+}
+
+// BadTimeSignature is returned if a time-based signature is invalid.
+// DateSigned is the zero time if the timestamp could not be recovered.
+type BadTimeSignature struct {
+	BadSignature
+	DateSigned time.Time
+}
+
+func (e *BadTimeSignature) Is(target error) bool {
+	return target == ErrBadTimeSignature || e.BadSignature.Is(target)
+}
+
+func (e *BadTimeSignature) As(target interface{}) bool {
+	if t, ok := target.(**BadSignature); ok {
+		*t = &e.BadSignature
+		return true
+	}
+	return e.BadSignature.As(target)
+}
+
+// SignatureExpired is returned if a signature timestamp is older than the
+// allowed max age. The payload itself was verified and is usually returned
+// alongside this error.
+type SignatureExpired struct {
+	BadTimeSignature
+}
+
+func (e *SignatureExpired) Is(target error) bool {
+	return target == ErrSignatureExpired || e.BadTimeSignature.Is(target)
+}
+
+func (e *SignatureExpired) As(target interface{}) bool {
+	if t, ok := target.(**BadTimeSignature); ok {
+		*t = &e.BadTimeSignature
+		return true
+	}
+	return e.BadTimeSignature.As(target)
+}
+
+// SignatureFromFuture is returned if a signature timestamp is later than
+// now plus the allowed leeway, usually because of clock drift between the
+// signing and the verifying hosts.
+type SignatureFromFuture struct {
+	BadTimeSignature
+}
+
+func (e *SignatureFromFuture) Is(target error) bool {
+	return target == ErrSignatureFromFuture || e.BadTimeSignature.Is(target)
+}
+
+func (e *SignatureFromFuture) As(target interface{}) bool {
+	if t, ok := target.(**BadTimeSignature); ok {
+		*t = &e.BadTimeSignature
+		return true
+	}
+	return e.BadTimeSignature.As(target)
+}
+
+// BadHeader is returned by the JWS serializer if the header is malformed or
+// does not match the serializer configuration.
+type BadHeader struct {
+	BadSignature
+	Header        map[string]interface{}
+	OriginalError error
+}
+
+func (e *BadHeader) Is(target error) bool {
+	return target == ErrBadHeader || e.BadSignature.Is(target)
+}
+
+func (e *BadHeader) As(target interface{}) bool {
+	if t, ok := target.(**BadSignature); ok {
+		*t = &e.BadSignature
+		return true
+	}
+	return e.BadSignature.As(target)
+}
+
+func (e *BadHeader) Unwrap() error {
+	return e.OriginalError
+}
+
+// BadPayload is returned if the payload is invalid, e.g. it could not be
+// decompressed or unserialized after the signature was verified.
+type BadPayload struct {
+	BadData
+	OriginalError error
+}
+
+func (e *BadPayload) Is(target error) bool {
+	return target == ErrBadPayload || e.BadData.Is(target)
+}
+
+func (e *BadPayload) As(target interface{}) bool {
+	if t, ok := target.(**BadData); ok {
+		*t = &e.BadData
+		return true
+	}
+	return false
+}
+
+func (e *BadPayload) Unwrap() error {
+	return e.OriginalError
+}
+
+func newBadSignature(payload []byte, cause error, message string) *BadSignature {
+	return &BadSignature{BadData: BadData{Message: message}, Payload: payload, Cause: cause}
+}
+
+func newBadTimeSignature(payload []byte, dateSigned time.Time, cause error, message string) *BadTimeSignature {
+	return &BadTimeSignature{BadSignature: *newBadSignature(payload, cause, message), DateSigned: dateSigned}
+}
+
+func newSignatureExpired(payload []byte, dateSigned time.Time, message string) *SignatureExpired {
+	return &SignatureExpired{BadTimeSignature: *newBadTimeSignature(payload, dateSigned, nil, message)}
+}
+
+func newSignatureFromFuture(payload []byte, dateSigned time.Time, message string) *SignatureFromFuture {
+	return &SignatureFromFuture{BadTimeSignature: *newBadTimeSignature(payload, dateSigned, nil, message)}
+}
+
+func newBadHeader(payload []byte, header map[string]interface{}, original error, message string) *BadHeader {
+	return &BadHeader{BadSignature: *newBadSignature(payload, nil, message), Header: header, OriginalError: original}
+}
+
+func newBadPayload(original error, message string) *BadPayload {
+	return &BadPayload{BadData: BadData{Message: message}, OriginalError: original}
+}
@@ -0,0 +1,87 @@
+package dangerous
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestErrorHierarchy(t *testing.T) {
+	expired := newSignatureExpired([]byte("value"), time.Unix(10, 0), "expired")
+	for _, sentinel := range []error{ErrBadData, ErrBadSignature, ErrBadTimeSignature, ErrSignatureExpired} {
+		if !errors.Is(expired, sentinel) {
+			t.Fatalf("SignatureExpired should be %s.", sentinel)
+		}
+	}
+	if errors.Is(expired, ErrBadHeader) || errors.Is(expired, ErrBadPayload) {
+		t.Fatalf("SignatureExpired should not be BadHeader or BadPayload.")
+	}
+
+	wrapped := fmt.Errorf("handler: %w", expired)
+	var bts *BadTimeSignature
+	if !errors.As(wrapped, &bts) || !bts.DateSigned.Equal(time.Unix(10, 0)) {
+		t.Fatalf("Could not get BadTimeSignature from SignatureExpired.")
+	}
+	var bs *BadSignature
+	if !errors.As(wrapped, &bs) || string(bs.Payload) != "value" {
+		t.Fatalf("Could not get BadSignature from SignatureExpired.")
+	}
+	var bd *BadData
+	if !errors.As(wrapped, &bd) || bd.Error() != "expired" {
+		t.Fatalf("Could not get BadData from SignatureExpired.")
+	}
+	var bh *BadHeader
+	if errors.As(wrapped, &bh) {
+		t.Fatalf("SignatureExpired should not be BadHeader.")
+	}
+}
+
+func TestErrorCause(t *testing.T) {
+	cause := errors.New("cause")
+	if err := newBadPayload(cause, "payload"); !errors.Is(err, cause) || errors.Is(err, ErrBadSignature) {
+		t.Fatalf("BadPayload should unwrap to its original error only.")
+	}
+	header := map[string]interface{}{"alg": "HS256"}
+	err := newBadHeader(nil, header, cause, "header")
+	if !errors.Is(err, cause) || !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("BadHeader should unwrap to its original error and be a BadSignature.")
+	}
+	var bh *BadHeader
+	if !errors.As(err, &bh) || bh.Header["alg"] != "HS256" {
+		t.Fatalf("Could not get the header from BadHeader.")
+	}
+
+	signed := signer.Sign(value + ".!!")
+	_, _, malformed := signer.UnSignTimestamp(string(signed), -1)
+	var bs *BadSignature
+	if !errors.Is(malformed, ErrBadTimeSignature) || !errors.As(malformed, &bs) || bs.Cause == nil || errors.Unwrap(malformed) != bs.Cause {
+		t.Fatalf("A malformed timestamp should unwrap to its decoding error, got %v", malformed)
+	}
+}
+
+func TestExpiredOrTampered(t *testing.T) {
+	signed := signer.SignTimestamp(value)
+	_, _, err := signer.UnSignTimestamp(string(signed), -1)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+
+	tampered := append([]byte("x"), signed...)
+	_, _, err = signer.UnSignTimestamp(string(tampered), 10)
+	if !errors.Is(err, ErrBadSignature) || errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("Tampered value should be BadSignature only. Error:%s", err)
+	}
+
+	old := Signer{Secret: "secret-key"}
+	old.SetDefault()
+	ts := WantBytes(B64encode(Int2Bytes(old.GetTimestamp() - 100)))
+	msg, _ := Concentrate(WantBytes(value), WantBytes(DefaultSep), ts)
+	sig := old.GetSignature(msg)
+	msg, _ = Concentrate(msg, WantBytes(DefaultSep), sig)
+	payload, _, err := signer.UnSignTimestamp(string(msg), 10)
+	var expired *SignatureExpired
+	if !errors.As(err, &expired) || string(payload) != value || string(expired.Payload) != value {
+		t.Fatalf("Old value should be SignatureExpired with payload. Error:%s", err)
+	}
+}
@@ -0,0 +1,235 @@
+package dangerous
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	fernetVersion = 0x80
+	// FernetMaxClockSkew is how far, in seconds, the timestamp of a Fernet
+	// token may be in the future when a ttl is given. Like the Python
+	// implementation, no clock skew is checked without one.
+	FernetMaxClockSkew = 60
+)
+
+// Fernet encrypts and authenticates messages as the Fernet specification
+// (https://github.com/fernet/spec) and Python's cryptography.fernet do.
+// Key is the URL-safe base64 of 32 bytes: a 16 byte HMAC-SHA256 signing key
+// followed by a 16 byte AES-128-CBC encryption key.
+//
+// A token is the URL-safe base64 of the version byte 0x80, a 64-bit
+// timestamp, a 16 byte IV, the ciphertext and an HMAC-SHA256 of everything
+// before it. Leeway is added to the ttl, and to FernetMaxClockSkew for
+// tokens from the future, as Signer.UnSignTimestamp does.
+type Fernet struct {
+	Key    string
+	Clock  Clock // DefaultClock if nil
+	Leeway int64 // seconds
+
+	signingKey    []byte
+	encryptionKey []byte
+	initialized   bool
+}
+
+// GenerateFernetKey returns a new random Fernet key.
+func GenerateFernetKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(key), nil
+}
+
+// NewFernet returns a Fernet with its key decoded once. It accepts the
+// WithClock and WithLeeway options.
+func NewFernet(key string, opts ...Option) (Fernet, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return Fernet{}, err
+	}
+	f := Fernet{Key: key, Clock: o.clock, Leeway: o.leeway}
+	if err := f.init(); err != nil {
+		return Fernet{}, err
+	}
+	return f, nil
+}
+
+func (f *Fernet) init() error {
+	if f.initialized {
+		return nil
+	}
+	key, err := base64.URLEncoding.DecodeString(f.Key)
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("Fernet key must be 32 url-safe base64-encoded bytes")
+	}
+	f.signingKey, f.encryptionKey = key[:16], key[16:]
+	f.initialized = true
+	return nil
+}
+
+func (f Fernet) now() int64 {
+	return clockOrDefault(f.Clock).Now().UTC().Unix()
+}
+
+// Encrypt returns the token of data, timestamped now.
+func (f Fernet) Encrypt(data []byte) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return BlankBytes, err
+	}
+	return f.encrypt(data, f.now(), iv)
+}
+
+func (f Fernet) encrypt(data []byte, timestamp int64, iv []byte) ([]byte, error) {
+	if err := f.init(); err != nil {
+		return BlankBytes, err
+	}
+	block, err := aes.NewCipher(f.encryptionKey)
+	if err != nil {
+		return BlankBytes, err
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	token := []byte{fernetVersion}
+	token = appendUint64(token, uint64(timestamp))
+	token = append(token, iv...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	token = append(token, ciphertext...)
+	mac := hmac.New(sha256.New, f.signingKey)
+	mac.Write(token)
+	token = mac.Sum(token)
+
+	encoded := make([]byte, base64.URLEncoding.EncodedLen(len(token)))
+	base64.URLEncoding.Encode(encoded, token)
+	return encoded, nil
+}
+
+// Decrypt returns the data of token. The token is refused with a
+// SignatureExpired once it is older than ttl seconds, or with a
+// SignatureFromFuture when it is more than FernetMaxClockSkew seconds in the
+// future, and the data is returned alongside the error. A negative ttl, like
+// a ttl of None in Python, skips both checks.
+func (f Fernet) Decrypt(token []byte, ttl int64) ([]byte, error) {
+	data, timestamp, err := f.decrypt(token)
+	if err != nil {
+		return nil, err
+	}
+	if ttl < 0 {
+		return data, nil
+	}
+	return data, checkTimestamp(nil, timestamp, f.now(), ttl, FernetMaxClockSkew+f.Leeway, f.Leeway)
+}
+
+// ExtractTimestamp returns the timestamp of a valid token.
+func (f Fernet) ExtractTimestamp(token []byte) (int64, error) {
+	_, timestamp, err := f.decrypt(token)
+	return timestamp, err
+}
+
+// decrypt authenticates and decrypts token without checking its timestamp.
+func (f Fernet) decrypt(token []byte) ([]byte, int64, error) {
+	if err := f.init(); err != nil {
+		return nil, 0, err
+	}
+	raw := make([]byte, base64.URLEncoding.DecodedLen(len(token)))
+	n, err := base64.URLEncoding.Decode(raw, token)
+	if err != nil {
+		return nil, 0, newBadSignature(nil, err, "Could not base64 decode the token")
+	}
+	raw = raw[:n]
+	if len(raw) < 1+8+aes.BlockSize+sha256.Size || raw[0] != fernetVersion {
+		return nil, 0, newBadSignature(nil, nil, "Token is not a Fernet token")
+	}
+	body, tag := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	mac := hmac.New(sha256.New, f.signingKey)
+	mac.Write(body)
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		return nil, 0, newBadSignature(nil, nil, "Signature does not match")
+	}
+	timestamp := int64(binary.BigEndian.Uint64(body[1:9]))
+	iv, ciphertext := body[9:9+aes.BlockSize], body[9+aes.BlockSize:]
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, timestamp, newBadSignature(nil, nil, "Ciphertext is not a multiple of the block size")
+	}
+	block, err := aes.NewCipher(f.encryptionKey)
+	if err != nil {
+		return nil, timestamp, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, timestamp, newBadSignature(nil, nil, "Invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], timestamp, nil
+}
+
+// MultiFernet encrypts with its first Fernet and decrypts with any of them,
+// in order. Unlike SecretKeys, and like Python's MultiFernet, the newest key
+// comes first.
+type MultiFernet struct {
+	Fernets []Fernet
+}
+
+// NewMultiFernet returns a MultiFernet of at least one Fernet.
+func NewMultiFernet(fernets ...Fernet) (MultiFernet, error) {
+	if len(fernets) == 0 {
+		return MultiFernet{}, fmt.Errorf("MultiFernet requires at least one Fernet")
+	}
+	for p := range fernets {
+		if err := fernets[p].init(); err != nil {
+			return MultiFernet{}, err
+		}
+	}
+	return MultiFernet{Fernets: fernets}, nil
+}
+
+func (mf MultiFernet) Encrypt(data []byte) ([]byte, error) {
+	if len(mf.Fernets) == 0 {
+		return BlankBytes, fmt.Errorf("MultiFernet requires at least one Fernet")
+	}
+	return mf.Fernets[0].Encrypt(data)
+}
+
+// Decrypt is Fernet.Decrypt with the first Fernet that authenticates token.
+func (mf MultiFernet) Decrypt(token []byte, ttl int64) ([]byte, error) {
+	for _, f := range mf.Fernets {
+		data, timestamp, err := f.decrypt(token)
+		if err == nil && ttl < 0 {
+			return data, nil
+		}
+		if err == nil {
+			return data, checkTimestamp(nil, timestamp, f.now(), ttl, FernetMaxClockSkew+f.Leeway, f.Leeway)
+		}
+	}
+	return nil, newBadSignature(nil, nil, "Signature does not match")
+}
+
+// Rotate re-encrypts token with the first Fernet, keeping its timestamp. The
+// age of the token is not checked.
+func (mf MultiFernet) Rotate(token []byte) ([]byte, error) {
+	for _, f := range mf.Fernets {
+		data, timestamp, err := f.decrypt(token)
+		if err != nil {
+			continue
+		}
+		iv := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+			return BlankBytes, err
+		}
+		return mf.Fernets[0].encrypt(data, timestamp, iv)
+	}
+	return BlankBytes, newBadSignature(nil, nil, "Signature does not match")
+}
@@ -0,0 +1,170 @@
+package dangerous
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+// fernetVector is an entry of the test vectors of the Fernet specification.
+type fernetVector struct {
+	Desc   string    `json:"desc"`
+	Token  string    `json:"token"`
+	Now    time.Time `json:"now"`
+	IV     []byte    `json:"-"`
+	IVInts []int     `json:"iv"`
+	Src    string    `json:"src"`
+	TTL    int64     `json:"ttl_sec"`
+	Secret string    `json:"secret"`
+}
+
+func loadFernetVectors(t *testing.T, name string) []fernetVector {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "fernet", name))
+	if err != nil {
+		t.Fatalf("Could not read %s: %s", name, err)
+	}
+	var vectors []fernetVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Could not parse %s: %s", name, err)
+	}
+	for p, v := range vectors {
+		for _, b := range v.IVInts {
+			vectors[p].IV = append(vectors[p].IV, byte(b))
+		}
+	}
+	return vectors
+}
+
+func TestFernetGenerate(t *testing.T) {
+	for _, v := range loadFernetVectors(t, "generate.json") {
+		f, err := NewFernet(v.Secret)
+		if err != nil {
+			t.Fatalf("Unexpected error:%s", err)
+		}
+		token, err := f.encrypt([]byte(v.Src), v.Now.Unix(), v.IV)
+		if err != nil || string(token) != v.Token {
+			t.Fatalf("Got %s, expected %s. Error:%v", token, v.Token, err)
+		}
+	}
+}
+
+func TestFernetVerify(t *testing.T) {
+	for _, v := range loadFernetVectors(t, "verify.json") {
+		f, _ := NewFernet(v.Secret, WithClock(dangeroustest.NewFakeClock(v.Now)))
+		data, err := f.Decrypt([]byte(v.Token), v.TTL)
+		if err != nil || string(data) != v.Src {
+			t.Fatalf("Got %q, expected %q. Error:%v", data, v.Src, err)
+		}
+		if ts, err := f.ExtractTimestamp([]byte(v.Token)); err != nil || ts != v.Now.Unix()-1 {
+			t.Fatalf("Unexpected timestamp %d. Error:%v", ts, err)
+		}
+	}
+}
+
+func TestFernetInvalid(t *testing.T) {
+	kinds := map[string]error{
+		"far-future TS (unacceptable clock skew)": ErrSignatureFromFuture,
+		"expired TTL": ErrSignatureExpired,
+	}
+	for _, v := range loadFernetVectors(t, "invalid.json") {
+		f, _ := NewFernet(v.Secret, WithClock(dangeroustest.NewFakeClock(v.Now)))
+		_, err := f.Decrypt([]byte(v.Token), v.TTL)
+		kind, ok := kinds[v.Desc]
+		if !ok {
+			kind = ErrBadSignature
+		}
+		if !errors.Is(err, kind) {
+			t.Fatalf("%s: expected %s, got %v", v.Desc, kind, err)
+		}
+	}
+}
+
+func TestFernet(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	key, _ := GenerateFernetKey()
+	f, err := NewFernet(key, WithClock(clock), WithLeeway(5))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	token, _ := f.Encrypt([]byte("secret message"))
+	clock.Advance(15 * time.Second)
+	if data, err := f.Decrypt(token, 10); err != nil || string(data) != "secret message" {
+		t.Fatalf("Leeway should accept the token. Error:%v", err)
+	}
+	clock.Advance(time.Second)
+	if data, err := f.Decrypt(token, 10); !errors.Is(err, ErrSignatureExpired) || string(data) != "secret message" {
+		t.Fatalf("Expected SignatureExpired with the data, got %v", err)
+	}
+	if _, err := f.Decrypt(token, -1); err != nil {
+		t.Fatalf("A negative ttl should not expire. Error:%s", err)
+	}
+	future, _ := f.Encrypt([]byte("secret message"))
+	clock.Set(time.Unix(1580000000-200, 0))
+	if _, err := f.Decrypt(future, -1); err != nil {
+		t.Fatalf("A negative ttl should skip the clock skew check. Error:%s", err)
+	}
+	if _, err := f.Decrypt(future, 10); !errors.Is(err, ErrSignatureFromFuture) {
+		t.Fatalf("Expected SignatureFromFuture, got %v", err)
+	}
+	for _, key := range []string{"", "short", "cw_0x689RpI-jtRR7oE8h_eQsKImvJapLeSbXpwF4e4"} {
+		if _, err := NewFernet(key); err == nil {
+			t.Fatalf("Key %q should be refused.", key)
+		}
+	}
+}
+
+func TestMultiFernet(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	oldKey, _ := GenerateFernetKey()
+	newKey, _ := GenerateFernetKey()
+	old, _ := NewFernet(oldKey, WithClock(clock))
+	current, _ := NewFernet(newKey, WithClock(clock))
+	mf, err := NewMultiFernet(current, old)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	token, _ := old.Encrypt([]byte("value"))
+	if data, err := mf.Decrypt(token, 10); err != nil || string(data) != "value" {
+		t.Fatalf("MultiFernet should decrypt with the old key. Error:%v", err)
+	}
+	clock.Advance(5 * time.Second)
+	rotated, err := mf.Rotate(token)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if _, err := old.Decrypt(rotated, -1); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Rotated token should not decrypt with the old key.")
+	}
+	if ts, err := current.ExtractTimestamp(rotated); err != nil || ts != 1580000000 {
+		t.Fatalf("Rotation should keep the timestamp, got %d. Error:%v", ts, err)
+	}
+	newToken, _ := mf.Encrypt([]byte("value"))
+	if _, err := current.Decrypt(newToken, -1); err != nil {
+		t.Fatalf("MultiFernet should encrypt with its first key. Error:%s", err)
+	}
+	clock.Set(time.Unix(1580000005-120, 0))
+	if _, err := mf.Decrypt(newToken, -1); err != nil {
+		t.Fatalf("A negative ttl should skip the clock skew check. Error:%s", err)
+	}
+	if _, err := mf.Decrypt(newToken, 60); !errors.Is(err, ErrSignatureFromFuture) {
+		t.Fatalf("Expected SignatureFromFuture, got %v", err)
+	}
+	clock.Set(time.Unix(1580000005, 0))
+	other, _ := GenerateFernetKey()
+	stranger, _ := NewFernet(other)
+	foreign, _ := stranger.Encrypt([]byte("value"))
+	if _, err := mf.Decrypt(foreign, -1); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+	if _, err := mf.Rotate(foreign); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+	if _, err := NewMultiFernet(); err == nil {
+		t.Fatalf("An empty MultiFernet should be refused.")
+	}
+}
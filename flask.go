@@ -0,0 +1,447 @@
+package dangerous
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlaskSessionSalt is the salt of Flask's SecureCookieSessionInterface.
+const FlaskSessionSalt = "cookie-session"
+
+// httpDateFormat is the date format of Werkzeug's http_date.
+const httpDateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// FlaskSession reads and writes the session cookies of Flask's
+// SecureCookieSessionInterface: a URL-safe timed token whose payload is
+// dumped by FlaskTaggedJSON and whose key is derived with hmac and sha1.
+//
+// SecretKeys is ordered from oldest to newest, so SECRET_KEY goes last and
+// SECRET_KEY_FALLBACKS before it. Flask refuses cookies older than
+// PERMANENT_SESSION_LIFETIME, 31 days by default, so pass it as MaxAge.
+type FlaskSession struct {
+	Secret     string
+	SecretKeys []string // oldest to newest
+	Salt       string   // FlaskSessionSalt if empty
+	Clock      Clock    // DefaultClock if nil
+	Leeway     int64    // seconds
+
+	serializer  Serializer
+	initialized bool
+}
+
+// NewFlaskSession returns a validated FlaskSession. It accepts the
+// WithSecretKeys, WithSalt, WithClock and WithLeeway options.
+func NewFlaskSession(secret string, opts ...Option) (FlaskSession, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return FlaskSession{}, err
+	}
+	fs := FlaskSession{
+		Secret:     secret,
+		SecretKeys: o.secretKeys,
+		Salt:       o.salt,
+		Clock:      o.clock,
+		Leeway:     o.leeway,
+	}
+	if err := fs.init(); err != nil {
+		return FlaskSession{}, err
+	}
+	return fs, nil
+}
+
+func (fs *FlaskSession) init() error {
+	if fs.initialized {
+		return nil
+	}
+	if fs.Secret == "" && len(fs.SecretKeys) == 0 {
+		return fmt.Errorf("FlaskSession secret is empty")
+	}
+	fs.SetDefault()
+	if err := fs.serializer.init(); err != nil {
+		return err
+	}
+	fs.initialized = true
+	return nil
+}
+
+// SetDefault fills in the defaults of a FlaskSession built as a struct
+// literal.
+func (fs *FlaskSession) SetDefault() {
+	if fs.initialized {
+		return
+	}
+	if fs.Salt == "" {
+		fs.Salt = FlaskSessionSalt
+	}
+	fs.serializer = Serializer{
+		Secret:     fs.Secret,
+		SecretKeys: fs.SecretKeys,
+		Salt:       fs.Salt,
+		Codec:      FlaskTaggedJSON{},
+		Signer: Signer{
+			Secret:        fs.Secret,
+			SecretKeys:    fs.SecretKeys,
+			Salt:          fs.Salt,
+			KeyDerivation: "hmac",
+			DigestMethod:  sha1.New,
+			Clock:         fs.Clock,
+			Leeway:        fs.Leeway,
+		},
+	}
+}
+
+// Dumps returns the cookie of session, which should encode to a JSON object.
+func (fs FlaskSession) Dumps(session interface{}) ([]byte, error) {
+	(&fs).SetDefault()
+	return fs.serializer.URLSafeTimedDumps(session)
+}
+
+// Loads returns the session of a cookie. Like Serializer.TimedLoads, the
+// session is also returned with a SignatureExpired.
+func (fs FlaskSession) Loads(cookie string, MaxAge int64) (map[string]interface{}, error) {
+	(&fs).SetDefault()
+	loaded, err := fs.serializer.URLSafeTimedLoads(cookie, MaxAge)
+	if loaded == nil {
+		return nil, err
+	}
+	session, ok := loaded.(map[string]interface{})
+	if !ok {
+		return nil, newBadPayload(nil, fmt.Sprintf("Session is a %T, not an object", loaded))
+	}
+	return session, err
+}
+
+// LoadsInto is Loads that decodes the session into dst.
+func (fs FlaskSession) LoadsInto(cookie string, MaxAge int64, dst interface{}) error {
+	(&fs).SetDefault()
+	return fs.serializer.URLSafeTimedLoadsInto(cookie, MaxAge, dst)
+}
+
+// FlaskTuple is a Python tuple, tagged " t" by FlaskTaggedJSON.
+type FlaskTuple []interface{}
+
+func (t FlaskTuple) plainGeneric() interface{} {
+	return []interface{}(t)
+}
+
+// FlaskMarkup is a markupsafe.Markup string, tagged " m" by FlaskTaggedJSON.
+type FlaskMarkup string
+
+func (m FlaskMarkup) plainGeneric() interface{} {
+	return string(m)
+}
+
+// FlaskUUID is a Python uuid.UUID, tagged " u" by FlaskTaggedJSON.
+type FlaskUUID [16]byte
+
+func (u FlaskUUID) plainGeneric() interface{} {
+	return u[:]
+}
+
+// String returns the UUID as Python's str does.
+func (u FlaskUUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// ParseFlaskUUID parses a UUID in the forms Python's uuid.UUID accepts.
+func ParseFlaskUUID(s string) (FlaskUUID, error) {
+	var u FlaskUUID
+	h := strings.TrimPrefix(s, "urn:uuid:")
+	h = strings.Trim(h, "{}")
+	h = strings.Replace(h, "-", "", -1)
+	if len(h) != 32 {
+		return u, fmt.Errorf("badly formed UUID %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(h)); err != nil {
+		return u, fmt.Errorf("badly formed UUID %q", s)
+	}
+	return u, nil
+}
+
+var flaskTags = map[string]bool{" di": true, " t": true, " b": true, " m": true, " u": true, " d": true}
+
+// FlaskTaggedJSON is the Codec of Flask's TaggedJSONSerializer. Values JSON
+// can not represent are written as an object with a single tag key:
+// []byte as " b", FlaskTuple as " t", FlaskMarkup as " m", FlaskUUID as
+// " u" and time.Time as " d", and objects whose only key is a tag are
+// wrapped in " di". Like Flask, it writes compact JSON with sorted keys and
+// non-ASCII characters escaped.
+//
+// Unmarshal untags values back to those types, integers to int64 and other
+// numbers to float64. Times are whole seconds in UTC.
+type FlaskTaggedJSON struct{}
+
+func (FlaskTaggedJSON) Marshal(v interface{}) ([]byte, error) {
+	tagged, err := flaskTag(reflect.ValueOf(v), 0)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writePythonJSON(&buf, tagged)
+	return buf.Bytes(), nil
+}
+
+func (FlaskTaggedJSON) Unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tagged interface{}
+	if err := decoder.Decode(&tagged); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after top-level value")
+	}
+	g, err := flaskUntag(tagged)
+	if err != nil {
+		return err
+	}
+	return unmarshalGeneric(g, v)
+}
+
+// flaskTag returns v as nil, bool, int64, uint64, float64, string,
+// []interface{} or map[string]interface{}, tagging what JSON can not hold.
+func flaskTag(v reflect.Value, depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, fmt.Errorf("value is nested too deeply")
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if !v.CanInterface() {
+		return nil, fmt.Errorf("unexported value of type %s", v.Type())
+	}
+	switch value := v.Interface().(type) {
+	case time.Time:
+		return map[string]interface{}{" d": value.UTC().Format(httpDateFormat)}, nil
+	case FlaskMarkup:
+		return map[string]interface{}{" m": string(value)}, nil
+	case FlaskUUID:
+		return map[string]interface{}{" u": hex.EncodeToString(value[:])}, nil
+	case FlaskTuple:
+		items, err := flaskTagItems(reflect.ValueOf(value), depth)
+		return map[string]interface{}{" t": items}, err
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return flaskTag(v.Elem(), depth+1)
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return map[string]interface{}{" b": base64.StdEncoding.EncodeToString(b)}, nil
+		}
+		return flaskTagItems(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := flaskKey(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = flaskTag(iter.Value(), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return flaskTagDict(m), nil
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		for _, f := range structFields(v.Type()) {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			var err error
+			if m[f.name], err = flaskTag(fv, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return flaskTagDict(m), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func flaskTagItems(v reflect.Value, depth int) ([]interface{}, error) {
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		var err error
+		if items[i], err = flaskTag(v.Index(i), depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// flaskTagDict wraps an object whose only key is a tag, so that it is not
+// mistaken for a tagged value.
+func flaskTagDict(m map[string]interface{}) interface{} {
+	if len(m) != 1 {
+		return m
+	}
+	for key, value := range m {
+		if flaskTags[key] {
+			return map[string]interface{}{" di": map[string]interface{}{key + "__": value}}
+		}
+	}
+	return m
+}
+
+// flaskKey returns a map key as the string encoding/json would use.
+func flaskKey(k reflect.Value) (string, error) {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported map key type %s", k.Type())
+}
+
+// flaskUntag turns a value decoded with UseNumber into a generic value,
+// untagging objects from the inside out as Flask's object_hook does.
+func flaskUntag(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			return u, nil
+		}
+		return value.Float64()
+	case []interface{}:
+		for i, item := range value {
+			var err error
+			if value[i], err = flaskUntag(item); err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	case map[string]interface{}:
+		for key, item := range value {
+			var err error
+			if value[key], err = flaskUntag(item); err != nil {
+				return nil, err
+			}
+		}
+		if len(value) != 1 {
+			return value, nil
+		}
+		for key, item := range value {
+			if flaskTags[key] {
+				return flaskUntagValue(key, item)
+			}
+		}
+		return value, nil
+	}
+	return v, nil
+}
+
+func flaskUntagValue(tag string, v interface{}) (interface{}, error) {
+	mismatch := fmt.Errorf("tag %q can not hold %T", tag, v)
+	if tag == " t" {
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, mismatch
+		}
+		return FlaskTuple(items), nil
+	}
+	if tag == " di" {
+		m, ok := v.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return nil, mismatch
+		}
+		for key, value := range m {
+			return map[string]interface{}{strings.TrimSuffix(key, "__"): value}, nil
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, mismatch
+	}
+	switch tag {
+	case " b":
+		return base64.StdEncoding.DecodeString(s)
+	case " m":
+		return FlaskMarkup(s), nil
+	case " u":
+		return ParseFlaskUUID(s)
+	}
+	return time.Parse(httpDateFormat, s)
+}
+
+// writePythonJSON writes a generic value as Python's json.dumps does with
+// compact separators, sorted keys and ensure_ascii.
+func writePythonJSON(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(value))
+	case int64:
+		buf.WriteString(strconv.FormatInt(value, 10))
+	case uint64:
+		buf.WriteString(strconv.FormatUint(value, 10))
+	case float64:
+		buf.WriteString(pythonFloat(value))
+	case string:
+		writePythonString(buf, value)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writePythonJSON(buf, item)
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writePythonString(buf, key)
+			buf.WriteByte(':')
+			writePythonJSON(buf, value[key])
+		}
+		buf.WriteByte('}')
+	}
+}
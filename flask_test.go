@@ -0,0 +1,130 @@
+package dangerous
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+// Dumped by Flask's TaggedJSONSerializer with sort_keys.
+const flaskTagged = `{"_fresh":true,"blob":{" b":"AAH/"},"html":{" m":"<b>hi</b>"},"items":[1,2.0],` +
+	`"name":"caf\u00e9","odd":{" di":{" t__":"not a tuple"}},"pair":{" t":[1,"a",null]},"ratio":0.5,` +
+	`"uid":{" u":"12345678123456781234567812345678"},"user_id":42,"when":{" d":"Sun, 13 Sep 2020 12:26:40 GMT"}}`
+
+var flaskSession = map[string]interface{}{
+	"_fresh":  true,
+	"user_id": int64(42),
+	"name":    "café",
+	"blob":    []byte{0, 1, 0xff},
+	"pair":    FlaskTuple{int64(1), "a", nil},
+	"when":    time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC),
+	"uid":     FlaskUUID{0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78, 0x12, 0x34, 0x56, 0x78},
+	"html":    FlaskMarkup("<b>hi</b>"),
+	"odd":     map[string]interface{}{" t": "not a tuple"},
+	"ratio":   0.5,
+	"items":   []interface{}{int64(1), 2.0},
+}
+
+func TestFlaskTaggedJSON(t *testing.T) {
+	data, err := FlaskTaggedJSON{}.Marshal(flaskSession)
+	if err != nil || string(data) != flaskTagged {
+		t.Fatalf("Got %s, expected %s. Error:%v", data, flaskTagged, err)
+	}
+	var loaded interface{}
+	if err := (FlaskTaggedJSON{}).Unmarshal([]byte(flaskTagged), &loaded); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if !reflect.DeepEqual(loaded, flaskSession) {
+		t.Fatalf("Got %#v", loaded)
+	}
+
+	var typed struct {
+		Blob []byte        `json:"blob"`
+		Pair []interface{} `json:"pair"`
+		UID  FlaskUUID     `json:"uid"`
+		HTML string        `json:"html"`
+		When time.Time     `json:"when"`
+		Odd  interface{}   `json:"odd"`
+	}
+	if err := (FlaskTaggedJSON{}).Unmarshal([]byte(flaskTagged), &typed); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if typed.HTML != "<b>hi</b>" || typed.UID.String() != "12345678-1234-5678-1234-567812345678" ||
+		len(typed.Blob) != 3 || len(typed.Pair) != 3 || typed.When.Unix() != 1600000000 {
+		t.Fatalf("Got %+v", typed)
+	}
+
+	for _, v := range []struct {
+		value   interface{}
+		encoded string
+	}{
+		{1.0, "1.0"},
+		{1e16, "1e+16"},
+		{0.0001, "0.0001"},
+		{1.5e-05, "1.5e-05"},
+		{"tab\t\"quote\" \U0001f600 \x7f", `"tab\t\"quote\" \ud83d\ude00 \u007f"`},
+		{map[int]string{2: "b", 10: "a"}, `{"10":"a","2":"b"}`},
+		{[]FlaskTuple{nil}, `[{" t":[]}]`},
+	} {
+		data, err := FlaskTaggedJSON{}.Marshal(v.value)
+		if err != nil || string(data) != v.encoded {
+			t.Fatalf("%#v: got %s, expected %s. Error:%v", v.value, data, v.encoded, err)
+		}
+	}
+	for _, data := range []string{`{" b":"!"}`, `{" t":1}`, `{" d":"yesterday"}`, `{" u":"123"}`, `{" di":[]}`,
+		`{"a":1}]`, `{"a":1}}`, `{"a":1} x`, `{"a":1}{}`} {
+		var loaded interface{}
+		if err := (FlaskTaggedJSON{}).Unmarshal([]byte(data), &loaded); err == nil {
+			t.Fatalf("%s should not decode.", data)
+		}
+	}
+}
+
+func TestFlaskSession(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1600000000, 0))
+	fs, err := NewFlaskSession("dev", WithClock(clock))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+
+	// Cookies set by Flask.
+	cookie := "eyJ1c2VyX2lkIjo3fQ.X14QAA.W7iyXRNKFDBw2VFeJyIIFtZL89U"
+	if got, _ := fs.Dumps(map[string]int{"user_id": 7}); string(got) != cookie {
+		t.Fatalf("Got %s, expected %s", got, cookie)
+	}
+	compressed := ".eJx1js1qwzAQhF9FzFkksuL8VIRATumlp-SWBiPXGyyQZSNL9BDy7l3b5152YGfmY16onpHGFibFTBK172uYFwRfnM-fa7wl2tT5-dnx81ifWndc16fJcYm6EeZeSL1SD4lgO-LMj31-Z6XoAxJ908zdxs2SqooDoU_CipQHT3gzZ7AuLvYMg4UM2fsHW9Em18Oo1VYiu4WVGVHoTbnd7Q__6TQvjxSrqVNqid-WwrKE29ccpCg24kqD0EorUWijd6ZU4vJ140l_NitNiQ.X14QAA.7bTt9ofN4P2bP-h2W6laMLKcyHc"
+	session, err := fs.Loads(compressed, 31*24*3600)
+	if err != nil || !reflect.DeepEqual(session, flaskSession) {
+		t.Fatalf("Got %#v. Error:%v", session, err)
+	}
+
+	cookie2, _ := fs.Dumps(flaskSession)
+	clock.Advance(11 * time.Second)
+	var user struct {
+		ID    int64      `json:"user_id"`
+		Pair  FlaskTuple `json:"pair"`
+		Fresh bool       `json:"_fresh"`
+	}
+	if err := fs.LoadsInto(string(cookie2), 10, &user); !errors.Is(err, ErrSignatureExpired) || user.ID != 42 || len(user.Pair) != 3 {
+		t.Fatalf("Expected SignatureExpired with the session, got %+v, %v", user, err)
+	}
+
+	rotated, _ := NewFlaskSession("", WithSecretKeys("dev", "prod"), WithClock(clock))
+	if _, err := rotated.Loads(cookie, -1); err != nil {
+		t.Fatalf("Fallback keys should verify. Error:%s", err)
+	}
+	other, _ := NewFlaskSession("other")
+	if _, err := other.Loads(cookie, -1); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+	list, _ := fs.Dumps([]int{1})
+	if _, err := fs.Loads(string(list), -1); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+	if _, err := NewFlaskSession(""); err == nil {
+		t.Fatalf("An empty secret should be refused.")
+	}
+}
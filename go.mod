@@ -1,5 +1,7 @@
 module github.com/xiaoxfan/dangerous
 
-go 1.13
+go 1.18
 
 require golang.org/x/net v0.0.0-20200202094626-16171245cfb2
+
+require golang.org/x/text v0.3.0 // indirect
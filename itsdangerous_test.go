@@ -0,0 +1,57 @@
+package dangerous
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestItsdangerousDocs checks tokens of the itsdangerous documentation.
+func TestItsdangerousDocs(t *testing.T) {
+	signer, _ := NewSigner("secret-key")
+	if token := signer.Sign("my string"); string(token) != "my string.wh6tMHxLgJqB6oY1uT73iMlyrOA" {
+		t.Fatalf("Got %s", token)
+	}
+
+	// itsdangerous 0.24, whose Serializer dumps with json.dumps.
+	ser, _ := NewSerializer("secret-key", WithSerializer(PythonJSON{}))
+	if token, _ := ser.Dumps([]int{1, 2, 3, 4}); string(token) != "[1, 2, 3, 4].r7R9RhGgDPvvWl3iNzLuIIfELmo" {
+		t.Fatalf("Got %s", token)
+	}
+	if obj, err := ser.Loads("[1, 2, 3, 4].r7R9RhGgDPvvWl3iNzLuIIfELmo"); err != nil || !reflect.DeepEqual(obj, []interface{}{1.0, 2.0, 3.0, 4.0}) {
+		t.Fatalf("Got %v. Error:%v", obj, err)
+	}
+
+	auth, _ := NewSerializer("secret key", WithSalt("auth"))
+	obj := map[string]interface{}{"id": 5.0, "name": "itsdangerous"}
+	token := "eyJpZCI6NSwibmFtZSI6Iml0c2Rhbmdlcm91cyJ9.6YP6T0BaO67XP--9UzTrmurXSmg"
+	if dumped, _ := auth.URLSafeDumps(obj); string(dumped) != token {
+		t.Fatalf("Got %s, expected %s", dumped, token)
+	}
+	if loaded, err := auth.URLSafeLoads(token); err != nil || !reflect.DeepEqual(loaded, obj) {
+		t.Fatalf("Got %v. Error:%v", loaded, err)
+	}
+
+	// itsdangerous 0.24, whose JSONWebSignatureSerializer defaults to HS256.
+	jwss, _ := NewJWS("secret-key", WithAlgorithmName("HS256"))
+	token = "eyJhbGciOiJIUzI1NiJ9.eyJ4Ijo0Mn0.ZdTn1YyGz9Yx5B5wNpWRL221G1WpVE5fPCPKNuc6UAo"
+	if dumped, _ := jwss.Dumps(map[string]interface{}{"x": 42}); string(dumped) != token {
+		t.Fatalf("Got %s, expected %s", dumped, token)
+	}
+	if _, loaded, err := jwss.Loads(token); err != nil || !reflect.DeepEqual(loaded, map[string]interface{}{"x": 42.0}) {
+		t.Fatalf("Got %v. Error:%v", loaded, err)
+	}
+}
+
+func TestPythonJSON(t *testing.T) {
+	dumped, err := PythonJSON{}.Dump(map[string]interface{}{
+		"b": []interface{}{1, 2.5, nil, true, map[string]string{}},
+		"a": "<é> \U0001f600 \"\n",
+	})
+	expected := `{"a": "<\u00e9> \ud83d\ude00 \"\n", "b": [1, 2.5, null, true, {}]}`
+	if err != nil || dumped != expected {
+		t.Fatalf("Got %s, expected %s. Error:%v", dumped, expected, err)
+	}
+	if dumped, _ := (PythonJSON{}).Dump([]int{}); dumped != "[]" {
+		t.Fatalf("Got %s", dumped)
+	}
+}
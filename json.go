@@ -1,7 +1,15 @@
 package dangerous
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // JSONAPI used to solve the problem that applying new struct to `serializer` or `jws`
@@ -10,6 +18,12 @@ type JSONAPI interface {
 	Dump(v interface{}) (string, error)
 }
 
+// JSONIntoAPI is implemented by a JSONAPI that can decode into a value
+// supplied by the caller, as the LoadsInto methods need.
+type JSONIntoAPI interface {
+	LoadInto(data []byte, v interface{}) error
+}
+
 // JSON is a empty struct, just for applying
 type JSON struct {
 }
@@ -26,3 +40,149 @@ func (js JSON) Dump(v interface{}) (string, error) {
 	str, err := json.Marshal(v)
 	return string(str), err
 }
+
+// LoadInto is json.Unmarshal, except that numbers decoded into an
+// interface{} become json.Number so that large integers survive.
+func (js JSON) LoadInto(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after top-level value")
+	}
+	return nil
+}
+
+// loadInto decodes data into v with api, which must be a JSONIntoAPI.
+func loadInto(api JSONAPI, data []byte, v interface{}) error {
+	into, ok := api.(JSONIntoAPI)
+	if !ok {
+		return fmt.Errorf("%T can not load into a value", api)
+	}
+	return into.LoadInto(data, v)
+}
+
+// PythonJSON is the JSONAPI of Python's json module with its default
+// arguments, which the itsdangerous Serializer and TimedSerializer use: ", "
+// and ": " separators and non-ASCII characters escaped. Keys are written in
+// the order encoding/json gives them.
+type PythonJSON struct {
+}
+
+func (pj PythonJSON) Load(data []byte) (interface{}, error) {
+	return JSON{}.Load(data)
+}
+
+func (pj PythonJSON) Dump(v interface{}) (string, error) {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(&data)
+	decoder.UseNumber()
+	var buf bytes.Buffer
+	// open holds the delimiter of each enclosing array or object and count
+	// the number of tokens written in it.
+	var open []json.Delim
+	var count []int
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if token == json.Delim(']') || token == json.Delim('}') {
+			buf.WriteString(token.(json.Delim).String())
+			open, count = open[:len(open)-1], count[:len(count)-1]
+			continue
+		}
+		if n := len(open); n > 0 {
+			if open[n-1] == '{' && count[n-1]%2 == 1 {
+				buf.WriteString(": ")
+			} else if count[n-1] > 0 {
+				buf.WriteString(", ")
+			}
+			count[n-1]++
+		}
+		switch value := token.(type) {
+		case json.Delim:
+			buf.WriteString(value.String())
+			open, count = append(open, value), append(count, 0)
+		case string:
+			writePythonString(&buf, value)
+		case json.Number:
+			buf.WriteString(value.String())
+		case bool:
+			buf.WriteString(strconv.FormatBool(value))
+		case nil:
+			buf.WriteString("null")
+		}
+	}
+	return buf.String(), nil
+}
+
+func (pj PythonJSON) LoadInto(data []byte, v interface{}) error {
+	return JSON{}.LoadInto(data, v)
+}
+
+// pythonFloat formats f as Python's repr does.
+func pythonFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	exact := strconv.FormatFloat(f, 'e', -1, 64)
+	exp, _ := strconv.Atoi(exact[strings.IndexByte(exact, 'e')+1:])
+	if f != 0 && (exp < -4 || exp >= 16) {
+		return exact
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// writePythonString writes s as Python's json.dumps does with ensure_ascii.
+func writePythonString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if r >= 0x20 && r < 0x7f {
+				buf.WriteRune(r)
+				continue
+			}
+			for _, unit := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(buf, `\u%04x`, unit)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,378 @@
+package dangerous
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key of RFC 7517, or of RFC 8037 for Ed25519. Key is one
+// of:
+//
+//	[]byte                                oct
+//	*rsa.PublicKey, *rsa.PrivateKey       RSA
+//	*ecdsa.PublicKey, *ecdsa.PrivateKey   EC on P-256, P-384 or P-521
+//	ed25519.PublicKey, ed25519.PrivateKey OKP on Ed25519
+//
+// Members other than the ones below are dropped when a JWK is parsed.
+type JWK struct {
+	Key       interface{}
+	KeyID     string   // kid
+	Algorithm string   // alg
+	Use       string   // use
+	KeyOps    []string // key_ops
+}
+
+// jwkJSON holds the members of a JWK, base64url encoded.
+type jwkJSON struct {
+	Kty    string          `json:"kty"`
+	Use    string          `json:"use,omitempty"`
+	KeyOps []string        `json:"key_ops,omitempty"`
+	Alg    string          `json:"alg,omitempty"`
+	Kid    string          `json:"kid,omitempty"`
+	Crv    string          `json:"crv,omitempty"`
+	K      string          `json:"k,omitempty"`
+	N      string          `json:"n,omitempty"`
+	E      string          `json:"e,omitempty"`
+	X      string          `json:"x,omitempty"`
+	Y      string          `json:"y,omitempty"`
+	D      string          `json:"d,omitempty"`
+	P      string          `json:"p,omitempty"`
+	Q      string          `json:"q,omitempty"`
+	DP     string          `json:"dp,omitempty"`
+	DQ     string          `json:"dq,omitempty"`
+	QI     string          `json:"qi,omitempty"`
+	Oth    json.RawMessage `json:"oth,omitempty"`
+}
+
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// errUnsupportedJWK is returned by UnmarshalJSON for a key type or curve it
+// does not know, which ParseJWKSet skips as RFC 7517 asks.
+type errUnsupportedJWK struct {
+	member, value string
+}
+
+func (e errUnsupportedJWK) Error() string {
+	return fmt.Sprintf("unsupported JWK %s %q", e.member, e.value)
+}
+
+// ParseJWK parses a JSON Web Key.
+func ParseJWK(data []byte) (JWK, error) {
+	var key JWK
+	err := json.Unmarshal(data, &key)
+	return key, err
+}
+
+func (k JWK) MarshalJSON() ([]byte, error) {
+	raw := jwkJSON{Use: k.Use, KeyOps: k.KeyOps, Alg: k.Algorithm, Kid: k.KeyID}
+	switch key := k.Key.(type) {
+	case []byte:
+		raw.Kty, raw.K = "oct", B64encode(key)
+	case *rsa.PublicKey:
+		raw.Kty, raw.N, raw.E = "RSA", B64encode(key.N.Bytes()), B64encode(big.NewInt(int64(key.E)).Bytes())
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, fmt.Errorf("JWK of an RSA key with %d primes is not supported", len(key.Primes))
+		}
+		// Computed here rather than with Precompute, which would write to a
+		// key other goroutines may be using.
+		p, q, one := key.Primes[0], key.Primes[1], big.NewInt(1)
+		dp := new(big.Int).Mod(key.D, new(big.Int).Sub(p, one))
+		dq := new(big.Int).Mod(key.D, new(big.Int).Sub(q, one))
+		qi := new(big.Int).ModInverse(q, p)
+		if qi == nil {
+			return nil, fmt.Errorf("invalid RSA private key")
+		}
+		raw.Kty, raw.N, raw.E = "RSA", B64encode(key.N.Bytes()), B64encode(big.NewInt(int64(key.E)).Bytes())
+		raw.D, raw.P, raw.Q = B64encode(key.D.Bytes()), B64encode(p.Bytes()), B64encode(q.Bytes())
+		raw.DP, raw.DQ, raw.QI = B64encode(dp.Bytes()), B64encode(dq.Bytes()), B64encode(qi.Bytes())
+	case *ecdsa.PublicKey:
+		if err := raw.setEC(key); err != nil {
+			return nil, err
+		}
+	case *ecdsa.PrivateKey:
+		if err := raw.setEC(&key.PublicKey); err != nil {
+			return nil, err
+		}
+		raw.D = B64encode(key.D.FillBytes(make([]byte, ecdsaKeySize(&key.PublicKey))))
+	case ed25519.PublicKey:
+		raw.Kty, raw.Crv, raw.X = "OKP", "Ed25519", B64encode(key)
+	case ed25519.PrivateKey:
+		raw.Kty, raw.Crv, raw.X, raw.D = "OKP", "Ed25519", B64encode(key.Public().(ed25519.PublicKey)), B64encode(key.Seed())
+	default:
+		return nil, fmt.Errorf("unsupported JWK key %T", k.Key)
+	}
+	return json.Marshal(raw)
+}
+
+func (raw *jwkJSON) setEC(key *ecdsa.PublicKey) error {
+	name := key.Curve.Params().Name
+	if _, ok := jwkCurves[name]; !ok {
+		return fmt.Errorf("unsupported JWK curve %s", name)
+	}
+	size := ecdsaKeySize(key)
+	raw.Kty, raw.Crv = "EC", name
+	raw.X, raw.Y = B64encode(key.X.FillBytes(make([]byte, size))), B64encode(key.Y.FillBytes(make([]byte, size)))
+	return nil
+}
+
+func (k *JWK) UnmarshalJSON(data []byte) error {
+	var raw jwkJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var key interface{}
+	var err error
+	switch raw.Kty {
+	case "oct":
+		key, err = jwkBytes("k", raw.K, -1)
+	case "RSA":
+		key, err = raw.rsaKey()
+	case "EC":
+		key, err = raw.ecKey()
+	case "OKP":
+		key, err = raw.okpKey()
+	case "":
+		err = fmt.Errorf(`JWK has no "kty"`)
+	default:
+		err = errUnsupportedJWK{"key type", raw.Kty}
+	}
+	if err != nil {
+		return err
+	}
+	*k = JWK{Key: key, KeyID: raw.Kid, Algorithm: raw.Alg, Use: raw.Use, KeyOps: raw.KeyOps}
+	return nil
+}
+
+// jwkBytes decodes the member name, which must be size bytes long unless
+// size is negative.
+func jwkBytes(name, value string, size int) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("JWK has no %q", name)
+	}
+	b, err := B64decode([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("JWK %q is not base64url: %s", name, err)
+	}
+	if size >= 0 && len(b) != size {
+		return nil, fmt.Errorf("JWK %q is %d bytes long, expected %d", name, len(b), size)
+	}
+	return b, nil
+}
+
+func jwkInt(name, value string) (*big.Int, error) {
+	b, err := jwkBytes(name, value, -1)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (raw jwkJSON) rsaKey() (interface{}, error) {
+	n, err := jwkInt("n", raw.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := jwkInt("e", raw.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("JWK has an invalid RSA exponent")
+	}
+	public := rsa.PublicKey{N: n, E: int(e.Int64())}
+	if raw.D == "" {
+		return &public, nil
+	}
+	if raw.Oth != nil {
+		return nil, fmt.Errorf("JWK of an RSA key with more than 2 primes is not supported")
+	}
+	d, err := jwkInt("d", raw.D)
+	if err != nil {
+		return nil, err
+	}
+	p, err := jwkInt("p", raw.P)
+	if err != nil {
+		return nil, err
+	}
+	q, err := jwkInt("q", raw.Q)
+	if err != nil {
+		return nil, err
+	}
+	private := &rsa.PrivateKey{PublicKey: public, D: d, Primes: []*big.Int{p, q}}
+	if err := private.Validate(); err != nil {
+		return nil, fmt.Errorf("JWK has an invalid RSA private key: %s", err)
+	}
+	private.Precompute()
+	return private, nil
+}
+
+func (raw jwkJSON) ecKey() (interface{}, error) {
+	curve, ok := jwkCurves[raw.Crv]
+	if !ok {
+		return nil, errUnsupportedJWK{"curve", raw.Crv}
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	x, err := jwkBytes("x", raw.X, size)
+	if err != nil {
+		return nil, err
+	}
+	y, err := jwkBytes("y", raw.Y, size)
+	if err != nil {
+		return nil, err
+	}
+	public := ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(public.X, public.Y) {
+		return nil, fmt.Errorf("JWK point is not on %s", raw.Crv)
+	}
+	if raw.D == "" {
+		return &public, nil
+	}
+	d, err := jwkBytes("d", raw.D, size)
+	if err != nil {
+		return nil, err
+	}
+	private := &ecdsa.PrivateKey{PublicKey: public, D: new(big.Int).SetBytes(d)}
+	if private.D.Sign() == 0 || private.D.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("JWK has an invalid EC private key")
+	}
+	if px, py := curve.ScalarBaseMult(d); px.Cmp(public.X) != 0 || py.Cmp(public.Y) != 0 {
+		return nil, fmt.Errorf("JWK private key does not match its public key")
+	}
+	return private, nil
+}
+
+func (raw jwkJSON) okpKey() (interface{}, error) {
+	if raw.Crv != "Ed25519" {
+		return nil, errUnsupportedJWK{"curve", raw.Crv}
+	}
+	x, err := jwkBytes("x", raw.X, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if raw.D == "" {
+		return ed25519.PublicKey(x), nil
+	}
+	d, err := jwkBytes("d", raw.D, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	private := ed25519.NewKeyFromSeed(d)
+	if !private.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		return nil, fmt.Errorf("JWK private key does not match its public key")
+	}
+	return private, nil
+}
+
+// Public returns k without its private members. An oct key has no public
+// part, so its Key is nil.
+func (k JWK) Public() JWK {
+	switch key := k.Key.(type) {
+	case []byte:
+		k.Key = nil
+	case crypto.Signer:
+		k.Key = key.Public()
+	}
+	return k
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of k, the hash of its required
+// public members.
+func (k JWK) Thumbprint(hash crypto.Hash) ([]byte, error) {
+	data, err := k.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var raw jwkJSON
+	json.Unmarshal(data, &raw)
+	var members map[string]string
+	switch raw.Kty {
+	case "oct":
+		members = map[string]string{"kty": raw.Kty, "k": raw.K}
+	case "RSA":
+		members = map[string]string{"kty": raw.Kty, "n": raw.N, "e": raw.E}
+	case "EC":
+		members = map[string]string{"kty": raw.Kty, "crv": raw.Crv, "x": raw.X, "y": raw.Y}
+	case "OKP":
+		members = map[string]string{"kty": raw.Kty, "crv": raw.Crv, "x": raw.X}
+	}
+	// encoding/json sorts the keys and the values need no escaping, which is
+	// the form RFC 7638 hashes.
+	required, _ := json.Marshal(members)
+	return digest(hash, required)
+}
+
+// JWKSet is a JSON Web Key Set of RFC 7517.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseJWKSet parses a JSON Web Key Set. Keys of a type or on a curve it
+// does not support are skipped, other invalid keys are an error.
+func ParseJWKSet(data []byte) (JWKSet, error) {
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return JWKSet{}, err
+	}
+	if raw.Keys == nil {
+		return JWKSet{}, fmt.Errorf(`JWK Set has no "keys"`)
+	}
+	set := JWKSet{Keys: []JWK{}}
+	for p, data := range raw.Keys {
+		key, err := ParseJWK(data)
+		var unsupported errUnsupportedJWK
+		if errors.As(err, &unsupported) {
+			continue
+		}
+		if err != nil {
+			return JWKSet{}, fmt.Errorf("key %d: %s", p, err)
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
+
+// UnmarshalJSON parses a JSON Web Key Set as ParseJWKSet does.
+func (s *JWKSet) UnmarshalJSON(data []byte) error {
+	set, err := ParseJWKSet(data)
+	if err != nil {
+		return err
+	}
+	*s = set
+	return nil
+}
+
+// Key returns the first key of s with the key ID kid.
+func (s JWKSet) Key(kid string) (JWK, bool) {
+	for _, key := range s.Keys {
+		if key.KeyID == kid {
+			return key, true
+		}
+	}
+	return JWK{}, false
+}
+
+// Public returns the public keys of s, as it can be published. oct keys
+// are left out.
+func (s JWKSet) Public() JWKSet {
+	public := JWKSet{Keys: []JWK{}}
+	for _, key := range s.Keys {
+		if key = key.Public(); key.Key != nil {
+			public.Keys = append(public.Keys, key)
+		}
+	}
+	return public
+}
@@ -0,0 +1,217 @@
+package dangerous
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// From RFC 7517 appendices A.1 and A.3, RFC 7515 appendix A.1 and RFC 8037
+// appendix A.
+const rfcJWKSet = `{"keys":[
+	{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+	 "y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE",
+	 "use":"enc","kid":"1"},
+	{"kty":"RSA","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	 "e":"AQAB","alg":"RS256","kid":"2011-04-29"},
+	{"kty":"oct","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow",
+	 "kid":"HMAC key used in JWS spec Appendix A.1 example"},
+	{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A",
+	 "x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+	{"kty":"OKP","crv":"X25519","x":"hSDwCYkwp1R0i33ctD73Wg2_Og0mOBr066SpjqqbTmo"},
+	{"kty":"unknown","kid":"skipped"}
+]}`
+
+func TestJWKSetRFC(t *testing.T) {
+	set, err := ParseJWKSet([]byte(rfcJWKSet))
+	if err != nil || len(set.Keys) != 4 {
+		t.Fatalf("Unsupported keys should be skipped, got %d keys. Error:%v", len(set.Keys), err)
+	}
+
+	if key, ok := set.Key("1"); !ok || key.Use != "enc" || key.Key.(*ecdsa.PrivateKey).D == nil {
+		t.Fatalf("Got %+v", key)
+	}
+	for _, v := range []struct {
+		key        JWK
+		thumbprint string
+	}{
+		{set.Keys[1], "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{set.Keys[3], "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+		{set.Keys[3].Public(), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+	} {
+		if thumbprint, err := v.key.Thumbprint(crypto.SHA256); err != nil || B64encode(thumbprint) != v.thumbprint {
+			t.Fatalf("Got %s, expected %s. Error:%v", B64encode(thumbprint), v.thumbprint, err)
+		}
+	}
+
+	emitted, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	var raw struct{ Keys []interface{} }
+	json.Unmarshal([]byte(rfcJWKSet), &raw)
+	var got interface{}
+	json.Unmarshal(emitted, &got)
+	if expected := map[string]interface{}{"keys": raw.Keys[:4]}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Got %s", emitted)
+	}
+
+	public := set.Public()
+	if len(public.Keys) != 3 {
+		t.Fatalf("Got %d public keys", len(public.Keys))
+	}
+	for _, key := range public.Keys {
+		if _, ok := key.Key.(crypto.Signer); ok {
+			t.Fatalf("%s is not public", key.KeyID)
+		}
+	}
+
+	// The JWS of RFC 7515 appendix A.1.
+	jwss, err := NewJWS("", WithJWKSet(set), WithAlgorithmName("HS256"))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	header, payload, err := jwss.Loads("eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9." +
+		"eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ." +
+		"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if header.(map[string]interface{})["typ"] != "JWT" || payload.(map[string]interface{})["iss"] != "joe" {
+		t.Fatalf("Got %v, %v", header, payload)
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	for _, key := range []interface{}{
+		testRSAKey, &testRSAKey.PublicKey, testP384Key, &testP521Key.PublicKey,
+		testEdKey, testEdKey.Public(), []byte("secret"),
+	} {
+		jwk := JWK{Key: key, KeyID: "kid", Algorithm: "alg", Use: "sig", KeyOps: []string{"sign"}}
+		data, err := json.Marshal(jwk)
+		if err != nil {
+			t.Fatalf("%T: unexpected error:%s", key, err)
+		}
+		parsed, err := ParseJWK(data)
+		if err != nil {
+			t.Fatalf("%T: unexpected error:%s", key, err)
+		}
+		type equaler interface{ Equal(crypto.PrivateKey) bool }
+		if e, ok := key.(equaler); ok && !e.Equal(parsed.Key) || !ok && !reflect.DeepEqual(parsed.Key, key) {
+			t.Fatalf("%T: got %#v", key, parsed.Key)
+		}
+		if parsed.KeyID != "kid" || parsed.Algorithm != "alg" || parsed.Use != "sig" || len(parsed.KeyOps) != 1 {
+			t.Fatalf("%T: got %+v", key, parsed)
+		}
+		first, _ := jwk.Thumbprint(crypto.SHA256)
+		second, _ := parsed.Public().Thumbprint(crypto.SHA256)
+		if _, oct := key.([]byte); !oct && string(first) != string(second) {
+			t.Fatalf("%T: the public key should have the same thumbprint", key)
+		}
+	}
+	if _, err := json.Marshal(JWK{Key: "secret"}); err == nil {
+		t.Fatalf("A string should not be a JWK.")
+	}
+
+	// The CRT values are emitted without being stored in the key.
+	key := &rsa.PrivateKey{PublicKey: testRSAKey.PublicKey, D: testRSAKey.D, Primes: testRSAKey.Primes}
+	data, _ := json.Marshal(JWK{Key: key})
+	var raw jwkJSON
+	json.Unmarshal(data, &raw)
+	if key.Precomputed.Dp != nil || raw.DP != B64encode(testRSAKey.Precomputed.Dp.Bytes()) ||
+		raw.DQ != B64encode(testRSAKey.Precomputed.Dq.Bytes()) || raw.QI != B64encode(testRSAKey.Precomputed.Qinv.Bytes()) {
+		t.Fatalf("Got %s", data)
+	}
+}
+
+func TestJWKSetUnmarshal(t *testing.T) {
+	var config struct {
+		Issuer string `json:"issuer"`
+		JWKS   JWKSet `json:"jwks"`
+	}
+	if err := json.Unmarshal([]byte(`{"issuer":"joe","jwks":`+rfcJWKSet+`}`), &config); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if config.Issuer != "joe" || len(config.JWKS.Keys) != 4 {
+		t.Fatalf("Unsupported keys should be skipped, got %d keys", len(config.JWKS.Keys))
+	}
+	if _, ok := config.JWKS.Key("2011-04-29"); !ok {
+		t.Fatalf("Got %+v", config.JWKS)
+	}
+	var set JWKSet
+	if err := json.Unmarshal([]byte(`{"keys":[{"kty":"oct"}]}`), &set); err == nil {
+		t.Fatalf("An invalid key should fail the set.")
+	}
+}
+
+func TestJWKInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{}`,
+		`{"kty":"oct"}`,
+		`{"kty":"oct","k":"a+b"}`,
+		`{"kty":"RSA","n":"AQAB"}`,
+		`{"kty":"RSA","n":"0vx7","e":"AQ"}`,
+		`{"kty":"RSA","n":"0vx7","e":"AQAB","d":"AQAB"}`,
+		`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","d":"AQAB"}`,
+		`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyQ"}`,
+		`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAA"}`,
+		`{"kty":"EC","crv":"P-192","x":"AA","y":"AA"}`,
+		`{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2E"}`,
+		`{"kty":"OKP","crv":"Ed25519","x":"11qY"}`,
+	} {
+		if _, err := ParseJWK([]byte(data)); err == nil {
+			t.Fatalf("%s should not parse.", data)
+		}
+	}
+	if _, err := ParseJWKSet([]byte(`{"keys":[{"kty":"oct"}]}`)); err == nil {
+		t.Fatalf("An invalid key should fail the set.")
+	}
+	if _, err := ParseJWKSet([]byte(`{}`)); err == nil {
+		t.Fatalf("A set needs keys.")
+	}
+	if _, err := NewJWS("", WithJWKSet(JWKSet{})); err == nil {
+		t.Fatalf("An empty set should be refused.")
+	}
+	if _, err := NewJWS("", WithJWKSet(JWKSet{Keys: []JWK{{Key: []byte("k"), Algorithm: "A128KW"}}})); err == nil {
+		t.Fatalf("An unknown algorithm should be refused.")
+	}
+}
+
+func TestJWKSetJWS(t *testing.T) {
+	signing := JWKSet{Keys: []JWK{
+		{Key: &testRSAKey.PublicKey, KeyID: "old", Algorithm: "RS256"},
+		{Key: testP256Key, KeyID: "new", Algorithm: "ES256"},
+	}}
+	data, _ := json.Marshal(signing.Public())
+	published, err := ParseJWKSet(data)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	signer, err := NewJWS("", WithJWKSet(signing))
+	if err != nil || signer.AlgorithmName != "ES256" {
+		t.Fatalf("The algorithm of the signing key should sign, got %s. Error:%v", signer.AlgorithmName, err)
+	}
+	verifier, err := NewJWS("", WithJWKSet(published))
+	if err != nil || !reflect.DeepEqual(verifier.AllowedAlgorithms, []string{"RS256", "ES256"}) {
+		t.Fatalf("Got %v. Error:%v", verifier.AllowedAlgorithms, err)
+	}
+	token, _ := signer.Dumps("value")
+	if _, payload, err := verifier.Loads(string(token)); err != nil || payload != "value" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+	old, _ := NewJWS("", WithSigningKey(testRSAKey), WithAlgorithmName("RS256"))
+	token, _ = old.Dumps("value")
+	if _, _, err := verifier.Loads(string(token)); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	other := JWKSet{Keys: []JWK{{Key: &testP384Key.PublicKey, Algorithm: "ES256"}}}
+	stranger, _ := NewJWS("", WithJWKSet(other))
+	token, _ = signer.Dumps("value")
+	if _, _, err := stranger.Loads(string(token)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package dangerous
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultJWKSMaxAge is the time, in seconds, a JWKSHandler lets clients
+	// cache its set, and RemoteJWKS caches a set served without a max-age.
+	DefaultJWKSMaxAge int64 = 3600
+	// DefaultJWKSRefreshInterval is the time, in seconds, RemoteJWKS waits at
+	// least between two fetches.
+	DefaultJWKSRefreshInterval int64 = 60
+	// MaxJWKSSize is the size, in bytes, of the largest set RemoteJWKS reads.
+	MaxJWKSSize int64 = 1 << 20
+)
+
+// JWKSHandler is an http.Handler that serves the public keys of Set as a JWK
+// Set, for clients such as RemoteJWKS. Responses may be cached for MaxAge
+// seconds, DefaultJWKSMaxAge if 0, and carry an ETag for revalidation.
+type JWKSHandler struct {
+	Set    JWKSet
+	MaxAge int64 // seconds
+}
+
+func (h JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := json.Marshal(h.Set.Public())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultJWKSMaxAge
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + B64encode(sum[:16]) + `"`
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteJWKS is a KeyResolver of the JWK Set served at URL. It caches the
+// set for the max-age of the Cache-Control response header, or MaxAge
+// seconds without one, and fetches it again, revalidating with its ETag,
+// when it has expired or when asked for a kid it does not have, so that new
+// keys are picked up. Fetches are at least MinRefreshInterval seconds apart;
+// in between, and when a fetch fails, the cached set keeps being used.
+//
+// Use NewRemoteJWKS to build one. It is safe for concurrent use. The lock
+// is not held during a fetch: callers that need one while it is in progress
+// wait for it instead of fetching again.
+type RemoteJWKS struct {
+	URL                string
+	Client             *http.Client // http.DefaultClient if nil
+	Clock              Clock        // DefaultClock if nil
+	MaxAge             int64        // seconds, DefaultJWKSMaxAge if 0
+	MinRefreshInterval int64        // seconds, DefaultJWKSRefreshInterval if 0
+
+	mu        sync.Mutex
+	set       JWKSet
+	etag      string
+	fetched   bool
+	expires   time.Time
+	attempted time.Time
+	err       error
+	fetching  chan struct{} // closed when the fetch in progress ends
+}
+
+// jwksResponse is what a fetch of RemoteJWKS got.
+type jwksResponse struct {
+	set         JWKSet
+	etag        string
+	maxAge      int64
+	notModified bool
+}
+
+// NewRemoteJWKS returns a RemoteJWKS of rawURL, which must be http or
+// https, with a client that times out after 10 seconds. It fetches nothing
+// until a key is resolved. WithClock, WithHTTPClient, WithJWKSMaxAge and
+// WithJWKSRefreshInterval apply to it.
+func NewRemoteJWKS(rawURL string, opts ...Option) (*RemoteJWKS, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("JWKS URL %q is not an http or https URL", rawURL)
+	}
+	r := &RemoteJWKS{
+		URL:                rawURL,
+		Client:             o.httpClient,
+		Clock:              o.clock,
+		MaxAge:             o.jwksMaxAge,
+		MinRefreshInterval: o.jwksRefreshInterval,
+	}
+	if r.Client == nil {
+		r.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return r, nil
+}
+
+func (r *RemoteJWKS) ResolveKey(kid string, header map[string]interface{}) (JWK, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := clockOrDefault(r.Clock).Now()
+	if !r.fetched || !now.Before(r.expires) {
+		r.refresh(now)
+	}
+	if !r.fetched {
+		return JWK{}, r.err
+	}
+	key, err := r.set.ResolveKey(kid, header)
+	if errors.Is(err, ErrUnknownKeyID) && r.refresh(now) {
+		key, err = r.set.ResolveKey(kid, header)
+	}
+	return key, err
+}
+
+// Set returns the cached set, fetching it if it has expired.
+func (r *RemoteJWKS) Set() (JWKSet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := clockOrDefault(r.Clock).Now()
+	if !r.fetched || !now.Before(r.expires) {
+		r.refresh(now)
+	}
+	if !r.fetched {
+		return JWKSet{}, r.err
+	}
+	return r.set, nil
+}
+
+// refresh fetches the set unless the last fetch was less than
+// MinRefreshInterval ago, and reports whether it did so successfully. It is
+// called with r.mu held, and releases it during the fetch; if another fetch
+// is in progress, it waits for that one and reports its result.
+func (r *RemoteJWKS) refresh(now time.Time) bool {
+	if done := r.fetching; done != nil {
+		r.mu.Unlock()
+		<-done
+		r.mu.Lock()
+		return r.err == nil
+	}
+	interval := r.MinRefreshInterval
+	if interval == 0 {
+		interval = DefaultJWKSRefreshInterval
+	}
+	if !r.attempted.IsZero() && now.Sub(r.attempted) < time.Duration(interval)*time.Second {
+		return false
+	}
+	r.attempted = now
+	done := make(chan struct{})
+	r.fetching = done
+	etag := ""
+	if r.fetched {
+		etag = r.etag
+	}
+	r.mu.Unlock()
+	resp, err := r.fetch(etag)
+	r.mu.Lock()
+	r.fetching = nil
+	close(done)
+	r.err = err
+	if err != nil {
+		return false
+	}
+	if !resp.notModified {
+		r.set, r.etag, r.fetched = resp.set, resp.etag, true
+	}
+	r.expires = now.Add(time.Duration(resp.maxAge) * time.Second)
+	return true
+}
+
+// fetch gets the set, revalidating with etag if it is not empty. It does
+// not use the state of r, and is called without r.mu held.
+func (r *RemoteJWKS) fetch(etag string) (jwksResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, r.URL, nil)
+	if err != nil {
+		return jwksResponse{}, err
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return jwksResponse{}, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	maxAge := r.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultJWKSMaxAge
+	}
+	maxAge = cacheMaxAge(resp.Header.Get("Cache-Control"), maxAge)
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return jwksResponse{maxAge: maxAge, notModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return jwksResponse{}, fmt.Errorf("fetching JWKS %s: %s", r.URL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxJWKSSize+1))
+	if err != nil {
+		return jwksResponse{}, fmt.Errorf("fetching JWKS %s: %w", r.URL, err)
+	}
+	if int64(len(body)) > MaxJWKSSize {
+		return jwksResponse{}, fmt.Errorf("fetching JWKS %s: more than %d bytes", r.URL, MaxJWKSSize)
+	}
+	set, err := ParseJWKSet(body)
+	if err != nil {
+		return jwksResponse{}, fmt.Errorf("fetching JWKS %s: %w", r.URL, err)
+	}
+	return jwksResponse{set: set, etag: resp.Header.Get("ETag"), maxAge: maxAge}, nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header, 0 for no-store
+// and no-cache, or def without one.
+func cacheMaxAge(cacheControl string, def int64) int64 {
+	maxAge := def
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 32); err == nil && seconds >= 0 {
+				maxAge = seconds
+			}
+		}
+	}
+	return maxAge
+}
@@ -0,0 +1,232 @@
+package dangerous
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	set := JWKSet{Keys: []JWK{
+		{Key: []byte("secret"), KeyID: "hs"},
+		{Key: testP256Key, KeyID: "es", Algorithm: "ES256"},
+	}}
+	server := httptest.NewServer(JWKSHandler{Set: set, MaxAge: 300})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/jwk-set+json" ||
+		resp.Header.Get("Cache-Control") != "public, max-age=300" || resp.Header.Get("ETag") == "" {
+		t.Fatalf("Got %s %v", resp.Status, resp.Header)
+	}
+	published, err := ParseJWKSet(body)
+	if err != nil || len(published.Keys) != 1 || published.Keys[0].KeyID != "es" {
+		t.Fatalf("Got %s. Error:%v", body, err)
+	}
+	if strings.Contains(string(body), `"d"`) || strings.Contains(string(body), `"k"`) {
+		t.Fatalf("Private members were published: %s", body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %v. Error:%v", resp, err)
+	}
+	if resp, err := http.Post(server.URL, "text/plain", nil); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405, got %v. Error:%v", resp, err)
+	}
+}
+
+// jwksServer serves the set it holds and counts the requests.
+type jwksServer struct {
+	mu       sync.Mutex
+	handler  http.Handler
+	requests int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *jwksServer) set(h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = h
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	first := JWK{Key: testP256Key, KeyID: "a", Algorithm: "ES256"}
+	second := JWK{Key: testEdKey, KeyID: "b", Algorithm: "EdDSA"}
+	jwks := &jwksServer{handler: JWKSHandler{Set: JWKSet{Keys: []JWK{first}}, MaxAge: 120}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	clock := dangeroustest.NewFakeClock(time.Unix(1700000000, 0))
+	remote, err := NewRemoteJWKS(server.URL, WithClock(clock), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	resolve := func(kid string, requests int) error {
+		t.Helper()
+		algs := map[string]string{"a": "ES256", "b": "EdDSA"}
+		_, err := remote.ResolveKey(kid, map[string]interface{}{"alg": algs[kid]})
+		if jwks.count() != requests {
+			t.Fatalf("%s: expected %d requests, got %d", kid, requests, jwks.count())
+		}
+		return err
+	}
+
+	if err := resolve("a", 1); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if err := resolve("a", 1); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+
+	// A new key is picked up on its first use, at most once a minute.
+	jwks.set(JWKSHandler{Set: JWKSet{Keys: []JWK{first, second}}, MaxAge: 120})
+	if err := resolve("b", 1); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("Expected ErrUnknownKeyID, got %v", err)
+	}
+	clock.Advance(61 * time.Second)
+	if err := resolve("b", 2); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := resolve("unknown", 2); !errors.Is(err, ErrUnknownKeyID) {
+			t.Fatalf("Expected ErrUnknownKeyID, got %v", err)
+		}
+	}
+
+	// The expired set is revalidated, and kept while the server fails.
+	clock.Advance(121 * time.Second)
+	if err := resolve("b", 3); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	jwks.set(http.NotFoundHandler())
+	clock.Advance(121 * time.Second)
+	if err := resolve("b", 4); err != nil {
+		t.Fatalf("The cached set should be used, got %v", err)
+	}
+	if err := resolve("b", 4); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+
+	// A token signed with a key of the set verifies.
+	issuer, _ := NewJWS("", WithJWKSet(JWKSet{Keys: []JWK{second}}))
+	verifier, _ := NewJWS("", WithAllowedAlgorithms("ES256", "EdDSA"), WithKeyResolver(remote))
+	token, _ := issuer.Dumps("value")
+	if _, payload, err := verifier.Loads(string(token)); err != nil || payload != "value" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+}
+
+func TestRemoteJWKSConcurrent(t *testing.T) {
+	set := JWKSHandler{Set: JWKSet{Keys: []JWK{{Key: testP256Key, KeyID: "a", Algorithm: "ES256"}}}}
+	started, release := make(chan struct{}), make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			close(started)
+		}
+		<-release
+		set.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	remote, _ := NewRemoteJWKS(server.URL, WithHTTPClient(server.Client()))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	resolve := func() {
+		defer wg.Done()
+		_, err := remote.ResolveKey("a", map[string]interface{}{"alg": "ES256"})
+		errs <- err
+	}
+	wg.Add(1)
+	go resolve()
+	<-started
+	// The lock is released while the set is fetched.
+	if !remote.mu.TryLock() {
+		t.Fatalf("The lock should not be held during a fetch.")
+	}
+	remote.mu.Unlock()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go resolve()
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error:%s", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected a single fetch, got %d", n)
+	}
+}
+
+func TestRemoteJWKSErrors(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/jwks", "/jwks", "http://"} {
+		if _, err := NewRemoteJWKS(u); err == nil {
+			t.Fatalf("%s should be refused.", u)
+		}
+	}
+	for _, body := range []string{"not json", `{"keys":[{"kty":"oct"}]}`, `{"keys":"` + strings.Repeat("a", int(MaxJWKSSize)) + `"}`} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		remote, _ := NewRemoteJWKS(server.URL)
+		if _, err := remote.Set(); err == nil {
+			t.Fatalf("%.20s should not load.", body)
+		}
+		server.Close()
+	}
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	remote, _ := NewRemoteJWKS(server.URL)
+	verifier, _ := NewJWS("", WithAlgorithmName("HS256"), WithKeyResolver(remote))
+	token, _ := JSONWebSignatureSerializer{Secret: "secret", AlgorithmName: "HS256"}.Dumps("value")
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("Expected BadHeader, got %v", err)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	for header, expected := range map[string]int64{
+		"":                          7,
+		"public, max-age=300":       300,
+		"Max-Age=\"60\", public":    60,
+		"max-age=-1":                7,
+		"max-age=99999999999":       7,
+		"no-cache, max-age=300":     0,
+		"private, no-store":         0,
+		"s-maxage=10, must-revalid": 7,
+	} {
+		if got := cacheMaxAge(header, 7); got != expected {
+			t.Fatalf("%q: got %d, expected %d", header, got, expected)
+		}
+	}
+}
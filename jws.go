@@ -2,20 +2,13 @@ package dangerous
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"crypto/sha512"
+	"crypto"
 	"fmt"
+	"hash"
 	"time"
 )
 
 var (
-	JwsAlgorithms = map[string]interface{}{
-		"HS256": HMACAlgorithm{DigestMethod: sha256.New},
-		"HS384": HMACAlgorithm{DigestMethod: sha512.New384},
-		"HS512": HMACAlgorithm{DigestMethod: sha512.New},
-		"none":  SigningAlgorithm{},
-	}
-
 	DefaultAlgorithm = "HS512"
 
 	DefaultSerializer = JSON{}
@@ -23,37 +16,170 @@ var (
 	DefaultExpiresIn int64 = 3600
 )
 
+// JSONWebSignatureSerializer signs with Secret, or the newest of SecretKeys,
+// for the HS* algorithms. The RS*, ES*, PS* and EdDSA algorithms use
+// SigningKey and VerificationKeys instead, see Signer. WithJWKSet sets all
+// of them from a JSON Web Key Set.
+//
+// Dumps signs with AlgorithmName. Loads accepts tokens signed with any of
+// AllowedAlgorithms, which defaults to AlgorithmName alone. AlgorithmName
+// defaults to the first allowed algorithm, or DefaultAlgorithm. All names
+// must be registered, see RegisterAlgorithm. The unsigned "none" algorithm
+// is refused unless AllowNone is set.
+//
+// With a Salt the signing key is derived from Secret with DigestMethod, as
+// Signer does, and itsdangerous does with sha1. Without one Secret is the key.
+//
+// By default TimedDumps puts iat and exp in the header, as itsdangerous
+// does. With StandardClaims they go in the payload as RFC 7519 claims, along
+// with iss and aud from Issuer and Audience, and TimedLoads checks them with
+// ClaimsValidator. TimedDumpsClaims sets the other registered claims.
+//
+// Dumps writes KeyID as the kid header. With a KeyResolver, Loads verifies
+// with the key it resolves from the kid and alg headers instead of the keys
+// above, which then only sign; the unsigned "none" algorithm still uses
+// Secret.
+//
+// With Unencoded, the payload is signed and sent as is instead of base64url
+// encoded, with the "b64": false and "crit": ["b64"] headers of RFC 7797.
+// Loads follows the b64 header of each token. A token whose crit header
+// lists a name other than b64 and CriticalHeaders is refused.
 type JSONWebSignatureSerializer struct {
-	Secret        string
-	Salt          string
-	Serializer    JSONAPI
-	Signer        Signer
-	AlgorithmName string
-	Algorithm     Signature
-	ExpiresIn     int64
+	Secret            string
+	SecretKeys        []string // oldest to newest, see Signer
+	Salt              string
+	DigestMethod      func() hash.Hash // key derivation, DefaultDigestMethod if nil
+	Serializer        JSONAPI
+	Signer            Signer
+	AlgorithmName     string
+	AllowedAlgorithms []string
+	AllowNone         bool
+	Algorithm         Signature
+	ExpiresIn         int64
+	Clock             Clock // DefaultClock if nil
+	StandardClaims    bool
+	Issuer            string
+	Audience          []string
+	Leeway            int64 // seconds
+	KeyID             string
+	KeyResolver       KeyResolver
+	Unencoded         bool
+	CriticalHeaders   []string // understood by the application
+
+	SigningKey       crypto.Signer
+	VerificationKeys []crypto.PublicKey
+	signers          map[string]Signer
+	initialized      bool
+}
+
+// NewJWS returns a validated JSONWebSignatureSerializer with its signer built
+// once. Like NewSigner, the result can be shared between goroutines as long as
+// its fields are not changed.
+func NewJWS(secret string, opts ...Option) (JSONWebSignatureSerializer, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return JSONWebSignatureSerializer{}, err
+	}
+	jwss := JSONWebSignatureSerializer{
+		Secret:            secret,
+		SecretKeys:        o.secretKeys,
+		Salt:              o.salt,
+		DigestMethod:      o.digestMethod,
+		Serializer:        o.serializer,
+		AlgorithmName:     o.algorithmName,
+		AllowedAlgorithms: o.allowedAlgorithms,
+		AllowNone:         o.allowNone,
+		ExpiresIn:         o.expiresIn,
+		Clock:             o.clock,
+		StandardClaims:    o.standardClaims,
+		Issuer:            o.issuer,
+		Audience:          o.audience,
+		Leeway:            o.leeway,
+		KeyID:             o.keyID,
+		KeyResolver:       o.keyResolver,
+		Unencoded:         o.unencoded,
+		CriticalHeaders:   o.criticalHeaders,
+		SigningKey:        o.signingKey,
+		VerificationKeys:  o.verificationKeys,
+	}
+	if err := jwss.init(); err != nil {
+		return JSONWebSignatureSerializer{}, err
+	}
+	return jwss, nil
+}
+
+// init validates the serializer and builds its signers. Dumps and Loads run
+// it on their copy of a serializer that was not built by NewJWS. With a
+// KeyResolver, signers without valid keys are left out instead, since a
+// serializer may only verify.
+func (jwss *JSONWebSignatureSerializer) init() error {
+	if jwss.initialized {
+		return nil
+	}
+	jwss.SetDefault()
+	if jwss.Algorithm == nil {
+		return fmt.Errorf("Invalid algorithm %q", jwss.AlgorithmName)
+	}
+	if !containsString(jwss.AllowedAlgorithms, jwss.AlgorithmName) {
+		return fmt.Errorf("Algorithm %q is not one of AllowedAlgorithms %v", jwss.AlgorithmName, jwss.AllowedAlgorithms)
+	}
+	signers := map[string]Signer{}
+	for _, name := range append([]string{jwss.AlgorithmName}, jwss.AllowedAlgorithms...) {
+		if name == "none" && !jwss.AllowNone {
+			return fmt.Errorf(`The "none" algorithm is refused unless AllowNone is set`)
+		}
+	}
+	for _, name := range jwss.AllowedAlgorithms {
+		alg, ok := LookupAlgorithm(name)
+		if !ok {
+			return fmt.Errorf("Invalid algorithm %q", name)
+		}
+		signer := jwss.newSigner(alg)
+		if err := signer.init(); err != nil {
+			if jwss.KeyResolver != nil {
+				continue
+			}
+			return err
+		}
+		signers[name] = signer
+	}
+	jwss.Signer = jwss.newSigner(jwss.Algorithm)
+	if err := jwss.Signer.init(); err != nil && jwss.KeyResolver == nil {
+		return err
+	}
+	jwss.signers = signers
+	jwss.initialized = true
+	return nil
 }
 
+// SetDefault fills in the defaults of a serializer built as a struct literal.
+// Algorithm is left nil if AlgorithmName is not registered.
 func (jwss *JSONWebSignatureSerializer) SetDefault() {
+	if jwss.initialized {
+		return
+	}
+	if jwss.AlgorithmName == "" && len(jwss.AllowedAlgorithms) > 0 {
+		jwss.AlgorithmName = jwss.AllowedAlgorithms[0]
+	}
 	if jwss.AlgorithmName == "" {
 		jwss.AlgorithmName = DefaultAlgorithm
 	}
+	if len(jwss.AllowedAlgorithms) == 0 {
+		jwss.AllowedAlgorithms = []string{jwss.AlgorithmName}
+	}
 	if jwss.ExpiresIn == 0 {
 		jwss.ExpiresIn = DefaultExpiresIn
 	}
-	alg := JwsAlgorithms[jwss.AlgorithmName]
-	if alg == nil {
-		jwss.AlgorithmName = DefaultAlgorithm
-		alg = JwsAlgorithms[jwss.AlgorithmName]
-		fmt.Println("Invalid_algorithm! Now we will use default algorithm. HS256.")
+	jwss.Algorithm, _ = LookupAlgorithm(jwss.AlgorithmName)
+	if jwss.Serializer == nil {
+		jwss.Serializer = JSON{}
 	}
-	jwss.Algorithm = alg.(Signature)
-	jwss.Serializer = JSON{}
 }
 
 func (jwss JSONWebSignatureSerializer) LoadPayload(payload []byte) (interface{}, interface{}, error) {
 	sep := []byte(".")
 	if !bytes.Contains(payload, sep) {
-		return BlankBytes, BlankBytes, fmt.Errorf("BadPayload: No '.' found in value, %s", payload)
+		return BlankBytes, BlankBytes, newBadPayload(nil, "No '.' found in value")
 	}
 	v := bytes.SplitN(payload, []byte("."), 2)
 
@@ -61,22 +187,25 @@ func (jwss JSONWebSignatureSerializer) LoadPayload(payload []byte) (interface{},
 
 	JSONheader, err := B64decode(base64dheader)
 	if err != nil {
-		return JSONheader, BlankBytes, fmt.Errorf("Could not base64 decode the header because of an exception")
+		return JSONheader, BlankBytes, newBadHeader(payload, nil, err, "Could not base64 decode the header because of an exception")
 	}
-	JSONpayload, err := B64decode(base64dpayload)
+	header, errheader := jwss.Serializer.Load(JSONheader)
+	headers, ok := header.(map[string]interface{})
+	JSONpayload, err := decodePayload(headers, base64dpayload)
 	if err != nil {
-		return BlankBytes, JSONpayload, fmt.Errorf("Could not base64 decode the payload because of an exception")
+		return BlankBytes, JSONpayload, err
 	}
-	header, err := jwss.Serializer.Load(JSONheader)
-	if err != nil {
-		return header, BlankBytes, fmt.Errorf("Could not unserialize header because it was malformed")
+	if errheader != nil {
+		return header, BlankBytes, newBadHeader(payload, nil, errheader, "Could not unserialize header because it was malformed")
 	}
-	_, ok := header.(map[string]interface{})
 	if !ok {
-		return header, BlankBytes, fmt.Errorf("Header payload is not a JSON object")
+		return header, BlankBytes, newBadHeader(payload, nil, nil, "Header payload is not a JSON object")
 	}
 	payloadr, err := jwss.Serializer.Load(JSONpayload)
-	return header, payloadr, err
+	if err != nil {
+		return header, payloadr, newBadPayload(err, "Could not unserialize the payload because an exception occurred")
+	}
+	return header, payloadr, nil
 }
 
 func (jwss JSONWebSignatureSerializer) DumpPayload(header, obj interface{}) ([]byte, error) {
@@ -89,66 +218,413 @@ func (jwss JSONWebSignatureSerializer) DumpPayload(header, obj interface{}) ([]b
 	if err != nil {
 		return BlankBytes, err
 	}
-	base64dpayload := B64encode([]byte(p))
+	base64dpayload := jwss.encodePayload([]byte(p))
+	if jwss.Unencoded && bytes.IndexByte(base64dpayload, '.') != -1 {
+		return BlankBytes, fmt.Errorf(`An unencoded payload containing "." cannot be compact serialized, see DumpsDetached`)
+	}
 	sep := WantBytes(".")
-	result, err := Concentrate(WantBytes(base64dheader), sep, WantBytes(base64dpayload))
+	result, err := Concentrate(WantBytes(base64dheader), sep, base64dpayload)
 	return result, err
 }
 
+// encodePayload returns the payload as it is signed, base64url encoded
+// unless Unencoded is set.
+func (jwss JSONWebSignatureSerializer) encodePayload(payload []byte) []byte {
+	if jwss.Unencoded {
+		return payload
+	}
+	return WantBytes(B64encode(payload))
+}
+
+// isUnencoded reports whether header has "b64": false.
+func isUnencoded(header map[string]interface{}) bool {
+	b64, ok := header["b64"].(bool)
+	return ok && !b64
+}
+
+// decodePayload is the inverse of encodePayload for the verified header.
+func decodePayload(header map[string]interface{}, payload []byte) ([]byte, error) {
+	if isUnencoded(header) {
+		return payload, nil
+	}
+	decoded, err := B64decode(payload)
+	if err != nil {
+		return decoded, newBadPayload(err, "Could not base64 decode the payload because of an exception")
+	}
+	return decoded, nil
+}
+
 func (jwss JSONWebSignatureSerializer) MakeSigner() Signer {
+	if jwss.initialized {
+		return jwss.Signer
+	}
+	return jwss.makeSigner(jwss.Algorithm)
+}
+
+func (jwss JSONWebSignatureSerializer) makeSigner(alg Signature) Signer {
+	signer := jwss.newSigner(alg)
+	signer.SetDefault()
+	return signer
+}
+
+// newSigner returns the Signer of alg without its defaults, so that init can
+// validate it.
+func (jwss JSONWebSignatureSerializer) newSigner(alg Signature) Signer {
 	keyderivation := ""
 	if jwss.Salt == "" {
 		keyderivation = "none"
 	}
-	SIGNER := &Signer{
-		Secret:        jwss.Secret,
-		Salt:          jwss.Salt,
-		Sep:           ".",
-		KeyDerivation: keyderivation,
-		Algorithm:     jwss.Algorithm,
+	return Signer{
+		Secret:           jwss.Secret,
+		SecretKeys:       jwss.SecretKeys,
+		Salt:             jwss.Salt,
+		Sep:              ".",
+		KeyDerivation:    keyderivation,
+		DigestMethod:     jwss.DigestMethod,
+		Algorithm:        alg,
+		Clock:            jwss.Clock,
+		SigningKey:       jwss.SigningKey,
+		VerificationKeys: jwss.VerificationKeys,
 	}
-	SIGNER.SetDefault()
-	return (*SIGNER)
-
 }
 
+// MakeHeader returns a copy of headerfields with the alg header, and the kid
+// header set to KeyID unless headerfields has one. With Unencoded it sets b64
+// and adds it to crit; a crit that is not a list of names is left as is, and
+// refused by Dumps.
 func (jwss JSONWebSignatureSerializer) MakeHeader(headerfields map[string]interface{}) map[string]interface{} {
-	headerfields["alg"] = jwss.AlgorithmName
-	return headerfields
+	header, _ := jwss.makeHeader(headerfields)
+	return header
+}
+
+func (jwss JSONWebSignatureSerializer) makeHeader(headerfields map[string]interface{}) (map[string]interface{}, error) {
+	header := make(map[string]interface{}, len(headerfields)+4)
+	for k, v := range headerfields {
+		header[k] = v
+	}
+	header["alg"] = jwss.AlgorithmName
+	if _, ok := header["kid"]; !ok && jwss.KeyID != "" {
+		header["kid"] = jwss.KeyID
+	}
+	if !jwss.Unencoded {
+		return header, nil
+	}
+	header["b64"] = false
+	var crit []string
+	switch names := header["crit"].(type) {
+	case nil:
+	case []string:
+		crit = append(crit, names...)
+	case []interface{}:
+		for _, name := range names {
+			n, ok := name.(string)
+			if !ok {
+				return header, fmt.Errorf(`Invalid "crit" header %v`, names)
+			}
+			crit = append(crit, n)
+		}
+	default:
+		return header, fmt.Errorf(`Invalid "crit" header of type %T`, names)
+	}
+	if !containsString(crit, "b64") {
+		crit = append(crit, "b64")
+	}
+	header["crit"] = crit
+	return header, nil
+}
 
+// headerArgs returns the header map args of Dumps may hold.
+func headerArgs(args []interface{}) (map[string]interface{}, error) {
+	if len(args) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	if len(args) > 1 {
+		return nil, fmt.Errorf("Expected at most one header, got %d arguments", len(args))
+	}
+	headerfields, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expected a header map[string]interface{}, got %T", args[0])
+	}
+	return headerfields, nil
 }
 
 func (jwss JSONWebSignatureSerializer) Dumps(obj interface{}, args ...interface{}) ([]byte, error) {
-	(&jwss).SetDefault()
-	headerfields := map[string]interface{}{}
-	if len(args) == 1 {
-		headerfields, _ = args[0].(map[string]interface{})
+	if err := (&jwss).init(); err != nil {
+		return BlankBytes, err
+	}
+	headerfields, err := headerArgs(args)
+	if err != nil {
+		return BlankBytes, err
+	}
+	header, err := jwss.makeHeader(headerfields)
+	if err != nil {
+		return BlankBytes, err
 	}
-	header := jwss.MakeHeader(headerfields)
 	signer := jwss.MakeSigner()
+	if !signer.initialized {
+		return BlankBytes, fmt.Errorf("JSONWebSignatureSerializer has no key to sign with %s", jwss.AlgorithmName)
+	}
 	payload, err := jwss.DumpPayload(header, obj)
 	if err != nil {
 		return payload, err
 	}
-	return signer.Sign(string(payload)), nil
+	return signer.SignE(string(payload))
 }
 
+// Loads reads the algorithm from the unverified header and verifies the
+// token only with the signer of that algorithm, if it is allowed.
 func (jwss JSONWebSignatureSerializer) Loads(s string) (interface{}, interface{}, error) {
-	(&jwss).SetDefault()
-	signer := jwss.MakeSigner()
+	header, payload, _, err := jwss.loads(s)
+	return header, payload, err
+}
+
+// LoadsInto is Loads that decodes the payload into dst, which must be a
+// pointer. The Serializer must be a JSONIntoAPI.
+func (jwss JSONWebSignatureSerializer) LoadsInto(s string, dst interface{}) (map[string]interface{}, error) {
+	if err := (&jwss).init(); err != nil {
+		return nil, err
+	}
+	header, _, raw, err := jwss.loads(s)
+	if err != nil {
+		return nil, err
+	}
+	headers := header.(map[string]interface{})
+	return headers, jwss.loadPayloadInto(headers, raw, dst)
+}
+
+// loadPayloadInto decodes the payload of the verified header.payload bytes
+// into dst.
+func (jwss JSONWebSignatureSerializer) loadPayloadInto(header map[string]interface{}, b []byte, dst interface{}) error {
+	JSONpayload, err := decodePayload(header, b[bytes.IndexByte(b, '.')+1:])
+	if err != nil {
+		return err
+	}
+	if err := loadInto(jwss.Serializer, JSONpayload, dst); err != nil {
+		return newBadPayload(err, "Could not unserialize the payload because an exception occurred")
+	}
+	return nil
+}
+
+// loads is Loads that also returns the verified header.payload bytes.
+func (jwss JSONWebSignatureSerializer) loads(s string) (interface{}, interface{}, []byte, error) {
+	if err := (&jwss).init(); err != nil {
+		return nil, nil, nil, err
+	}
+	header, err := jwss.UnverifiedHeader(s)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signer, err := jwss.headerSigner(header)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	b, err := signer.UnSign(s)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	h, payload, err := jwss.LoadPayload(b)
+	return h, payload, b, err
+}
+
+// DumpsDetached signs payload as Dumps does but leaves it out of the token,
+// which is header..signature as in RFC 7515 appendix F. The payload is
+// signed as given, not dumped by the Serializer. args may hold a header map
+// as for Dumps.
+func (jwss JSONWebSignatureSerializer) DumpsDetached(payload []byte, args ...interface{}) ([]byte, error) {
+	if err := (&jwss).init(); err != nil {
+		return BlankBytes, err
+	}
+	headerfields, err := headerArgs(args)
 	if err != nil {
-		return h, payload, err
+		return BlankBytes, err
 	}
-	header, _ := h.(map[string]interface{})
-	if header["alg"].(string) != jwss.AlgorithmName {
-		err = fmt.Errorf(`BadHeader: Algorithm mismatch, header:%v, payload=%v`, header, payload)
+	header, err := jwss.makeHeader(headerfields)
+	if err != nil {
+		return BlankBytes, err
 	}
-	return header, payload, err
+	signer := jwss.MakeSigner()
+	if !signer.initialized {
+		return BlankBytes, fmt.Errorf("JSONWebSignatureSerializer has no key to sign with %s", jwss.AlgorithmName)
+	}
+	h, err := jwss.Serializer.Dump(header)
+	if err != nil {
+		return BlankBytes, err
+	}
+	base64dheader := WantBytes(B64encode([]byte(h)))
+	value, _ := Concentrate(base64dheader, []byte("."), jwss.encodePayload(payload))
+	sig, err := signer.GetSignatureE(value)
+	if err != nil {
+		return BlankBytes, err
+	}
+	return Concentrate(base64dheader, []byte(".."), sig)
+}
+
+// LoadsDetached verifies a token made by DumpsDetached against payload and
+// returns its header.
+func (jwss JSONWebSignatureSerializer) LoadsDetached(s string, payload []byte) (map[string]interface{}, error) {
+	if err := (&jwss).init(); err != nil {
+		return nil, err
+	}
+	parts := bytes.Split(WantBytes(s), []byte("."))
+	if len(parts) != 3 || len(parts[1]) != 0 {
+		return nil, newBadSignature(nil, nil, "Token is not header..signature")
+	}
+	header, err := jwss.UnverifiedHeader(s)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jwss.headerSigner(header)
+	if err != nil {
+		return nil, err
+	}
+	encoded := payload
+	if !isUnencoded(header) {
+		encoded = WantBytes(B64encode(payload))
+	}
+	value, _ := Concentrate(parts[0], []byte("."), encoded)
+	if signer.MatchSignature(value, parts[2]) == -1 {
+		return nil, newBadSignature(payload, nil, fmt.Sprintf("Signature %q does not match", parts[2]))
+	}
+	return header, nil
+}
+
+// headerSigner returns the Signer that verifies a token with the unverified
+// header, if its algorithm is allowed.
+func (jwss JSONWebSignatureSerializer) headerSigner(header map[string]interface{}) (Signer, error) {
+	alg, ok := header["alg"].(string)
+	if !ok {
+		return Signer{}, newBadHeader(nil, header, nil, `Missing or invalid "alg" header`)
+	}
+	resolve := jwss.KeyResolver != nil && alg != "none"
+	signer, ok := jwss.signers[alg]
+	if !ok && resolve {
+		ok = containsString(jwss.AllowedAlgorithms, alg)
+	}
+	if !ok {
+		return Signer{}, newBadHeader(nil, header, nil, fmt.Sprintf("Algorithm %q is not allowed", alg))
+	}
+	if err := jwss.checkCritical(header); err != nil {
+		return Signer{}, err
+	}
+	if resolve {
+		return jwss.resolveSigner(header, alg)
+	}
+	return signer, nil
+}
+
+// registeredHeaders are the header names of RFC 7515 and RFC 7518, which
+// crit must not list.
+var registeredHeaders = map[string]bool{
+	"alg": true, "jku": true, "jwk": true, "kid": true, "x5u": true, "x5c": true,
+	"x5t": true, "x5t#S256": true, "typ": true, "cty": true, "crit": true,
+	"enc": true, "zip": true, "epk": true, "apu": true, "apv": true, "iv": true,
+	"tag": true, "p2s": true, "p2c": true,
+}
+
+// checkCritical applies the crit header of RFC 7515, which lists the header
+// names a verifier must understand, and the b64 header of RFC 7797, which
+// must be one of them.
+func (jwss JSONWebSignatureSerializer) checkCritical(header map[string]interface{}) error {
+	b64, hasB64 := header["b64"]
+	if _, ok := b64.(bool); hasB64 && !ok {
+		return newBadHeader(nil, header, nil, `Invalid "b64" header`)
+	}
+	crit, ok := header["crit"]
+	if !ok {
+		if hasB64 {
+			return newBadHeader(nil, header, nil, `The "b64" header must be listed in "crit"`)
+		}
+		return nil
+	}
+	names, ok := crit.([]interface{})
+	if !ok || len(names) == 0 {
+		return newBadHeader(nil, header, nil, `Invalid "crit" header`)
+	}
+	listed := map[string]bool{}
+	for _, n := range names {
+		name, ok := n.(string)
+		if !ok || name == "" || listed[name] {
+			return newBadHeader(nil, header, nil, `Invalid "crit" header`)
+		}
+		if registeredHeaders[name] {
+			return newBadHeader(nil, header, nil, fmt.Sprintf("Header %q must not be critical", name))
+		}
+		if name != "b64" && !containsString(jwss.CriticalHeaders, name) {
+			return newBadHeader(nil, header, nil, fmt.Sprintf("Critical header %q is not understood", name))
+		}
+		if _, ok := header[name]; !ok {
+			return newBadHeader(nil, header, nil, fmt.Sprintf("Critical header %q is missing", name))
+		}
+		listed[name] = true
+	}
+	if hasB64 && !listed["b64"] {
+		return newBadHeader(nil, header, nil, `The "b64" header must be listed in "crit"`)
+	}
+	return nil
+}
+
+// resolveSigner returns the Signer of alg with the key KeyResolver resolves
+// for header.
+func (jwss JSONWebSignatureSerializer) resolveSigner(header map[string]interface{}, alg string) (Signer, error) {
+	kid, ok := header["kid"].(string)
+	if _, present := header["kid"]; present && !ok {
+		return Signer{}, newBadHeader(nil, header, nil, `Invalid "kid" header`)
+	}
+	key, err := jwss.KeyResolver.ResolveKey(kid, header)
+	if err != nil {
+		return Signer{}, newBadHeader(nil, header, err, fmt.Sprintf("Could not resolve key %q", kid))
+	}
+	if key.Algorithm != "" && key.Algorithm != alg {
+		return Signer{}, newBadHeader(nil, header, nil, fmt.Sprintf("Key %q is for algorithm %q", kid, key.Algorithm))
+	}
+	if key.Use == "enc" || len(key.KeyOps) > 0 && !containsString(key.KeyOps, "verify") {
+		return Signer{}, newBadHeader(nil, header, nil, fmt.Sprintf("Key %q is not for verification", kid))
+	}
+	algorithm, _ := LookupAlgorithm(alg)
+	_, public := algorithm.(PublicKeySignature)
+	k := key.Key
+	if private, ok := k.(crypto.Signer); ok {
+		k = private.Public()
+	}
+	secret, isSecret := k.([]byte)
+	if k == nil || isSecret == public {
+		return Signer{}, newBadHeader(nil, header, nil, fmt.Sprintf("Key %q cannot verify %s", kid, alg))
+	}
+	verifier := jwss
+	verifier.Secret, verifier.SecretKeys = string(secret), nil
+	verifier.SigningKey, verifier.VerificationKeys = nil, nil
+	if public {
+		verifier.VerificationKeys = []crypto.PublicKey{k}
+	}
+	signer := verifier.newSigner(algorithm)
+	if err := signer.init(); err != nil {
+		return Signer{}, newBadHeader(nil, header, err, fmt.Sprintf("Key %q cannot verify %s", kid, alg))
+	}
+	return signer, nil
+}
+
+// UnverifiedHeader returns the header of s without checking its signature.
+// It must not be trusted.
+func (jwss JSONWebSignatureSerializer) UnverifiedHeader(s string) (map[string]interface{}, error) {
+	(&jwss).SetDefault()
+	b := WantBytes(s)
+	index := bytes.IndexByte(b, '.')
+	if index == -1 {
+		return nil, newBadSignature(nil, nil, `No "." found in value`)
+	}
+	JSONheader, err := B64decode(b[:index])
+	if err != nil {
+		return nil, newBadHeader(nil, nil, err, "Could not base64 decode the header because of an exception")
+	}
+	h, err := jwss.Serializer.Load(JSONheader)
+	if err != nil {
+		return nil, newBadHeader(nil, nil, err, "Could not unserialize header because it was malformed")
+	}
+	header, ok := h.(map[string]interface{})
+	if !ok {
+		return nil, newBadHeader(nil, nil, nil, "Header payload is not a JSON object")
+	}
+	return header, nil
 }
 
 func (jwss JSONWebSignatureSerializer) TimedMakeHeader(headerfields map[string]interface{}) map[string]interface{} {
@@ -162,49 +638,135 @@ func (jwss JSONWebSignatureSerializer) TimedMakeHeader(headerfields map[string]i
 
 func (jwss JSONWebSignatureSerializer) TimedDumps(obj interface{}, args ...interface{}) ([]byte, error) {
 	(&jwss).SetDefault()
-	headerfields := map[string]interface{}{}
-	if len(args) == 1 {
-		headerfields, _ = args[0].(map[string]interface{})
-		fmt.Println("get")
+	headerfields, err := headerArgs(args)
+	if err != nil {
+		return BlankBytes, err
+	}
+	if jwss.StandardClaims {
+		return jwss.dumpsClaims(obj, Claims{}, headerfields)
 	}
 	header := jwss.TimedMakeHeader(headerfields)
 	return jwss.Dumps(obj, header)
 }
 
+// TimedDumpsClaims is TimedDumps in standard claims mode with the non-zero
+// registered claims of claims, such as sub, jti or nbf, added to the
+// payload. They replace the iat, exp, iss and aud the serializer would set,
+// but not the claims obj already has.
+func (jwss JSONWebSignatureSerializer) TimedDumpsClaims(obj interface{}, claims Claims, args ...interface{}) ([]byte, error) {
+	(&jwss).SetDefault()
+	if !jwss.StandardClaims {
+		return BlankBytes, fmt.Errorf("TimedDumpsClaims needs StandardClaims")
+	}
+	headerfields, err := headerArgs(args)
+	if err != nil {
+		return BlankBytes, err
+	}
+	return jwss.dumpsClaims(obj, claims, headerfields)
+}
+
+func (jwss JSONWebSignatureSerializer) dumpsClaims(obj interface{}, registered Claims, headerfields map[string]interface{}) ([]byte, error) {
+	header := jwss.MakeHeader(headerfields)
+	header["typ"] = "JWT"
+	claims, err := jwss.makeClaims(obj, registered)
+	if err != nil {
+		return BlankBytes, err
+	}
+	return jwss.Dumps(claims, header)
+}
+
 func (jwss JSONWebSignatureSerializer) TimedLoads(s string) (map[string]interface{}, interface{}, error) {
+	header, payload, _, err := jwss.timedLoads(s)
+	return header, payload, err
+}
+
+// TimedLoadsInto is TimedLoads that decodes the payload into dst, which must
+// be a pointer. dst is filled in whenever the signature is valid, even if the
+// token expired.
+func (jwss JSONWebSignatureSerializer) TimedLoadsInto(s string, dst interface{}) (map[string]interface{}, error) {
+	if err := (&jwss).init(); err != nil {
+		return nil, err
+	}
+	header, _, raw, err := jwss.timedLoads(s)
+	if raw == nil {
+		return header, err
+	}
+	if errinto := jwss.loadPayloadInto(header, raw, dst); errinto != nil {
+		return header, errinto
+	}
+	return header, err
+}
+
+// timedLoads is TimedLoads that also returns the verified header.payload
+// bytes, or nil if the signature could not be verified.
+func (jwss JSONWebSignatureSerializer) timedLoads(s string) (map[string]interface{}, interface{}, []byte, error) {
 	(&jwss).SetDefault()
-	header, payload, err := jwss.Loads(s)
+	header, payload, raw, err := jwss.loads(s)
 	if err != nil {
-		return nil, payload, err
+		return nil, payload, nil, err
 	}
 	headers := header.(map[string]interface{})
+	if jwss.StandardClaims {
+		claims, ok := payload.(map[string]interface{})
+		if !ok {
+			return headers, payload, raw, newBadPayload(nil, "JWT payload is not a JSON object")
+		}
+		return headers, payload, raw, jwss.ClaimsValidator().Validate(claims, raw)
+	}
 	if ok := headers["exp"]; ok == nil {
-		return headers, payload, fmt.Errorf("BadSignature-Missing expiry date")
+		return headers, payload, raw, newBadSignature(raw, nil, "Missing expiry date")
 	}
+	exp, err := jwss.headerDate(headers, raw, "exp", "Expiry date is not an IntDate")
+	if err != nil {
+		return headers, payload, raw, err
+	}
+	nbf, err := jwss.headerDate(headers, raw, "nbf", "Not before date is not an IntDate")
+	if err != nil {
+		return headers, payload, raw, err
+	}
+	now := jwss.now()
+	issued := jwss.GetIssueDate(headers)
+	if !issued.IsZero() && issued.Unix()-jwss.Leeway > now {
+		return headers, payload, raw, newSignatureFromFuture(raw, issued,
+			fmt.Sprintf("Signature issued in the future, at %s", issued))
+	}
+	if exp+jwss.Leeway < now {
+		err := newSignatureExpired(raw, issued,
+			fmt.Sprintf("Signature expired, expired at %s", time.Unix(exp, 0).UTC()))
+		return headers, payload, raw, err
+	}
+	if nbf-jwss.Leeway > now {
+		return headers, payload, raw, newBadTimeSignature(raw, issued, nil,
+			fmt.Sprintf("Token is not valid before %s", time.Unix(nbf, 0).UTC()))
+	}
+	return headers, payload, raw, nil
 
-	IntDateError := fmt.Errorf(`BadHeader-Expiry date is not an IntDate, payload:%v`, payload)
+}
 
-	expfloat, ok := headers["exp"].(float64)
+// headerDate returns the IntDate header name, 0 if it is missing, or a
+// BadHeader if it is not an IntDate.
+func (jwss JSONWebSignatureSerializer) headerDate(headers map[string]interface{}, raw []byte, name, message string) (int64, error) {
+	v, ok := headers[name]
 	if !ok {
-		return headers, payload, IntDateError
-	}
-	exp := int64(expfloat)
-	if exp < 0 {
-		return headers, payload, IntDateError
+		return 0, nil
 	}
-
-	if exp < jwss.now() {
-		err := fmt.Errorf(`Signature expired, expired at %s`, jwss.GetIssueDate(exp))
-		return headers, payload, err
+	date, ok := v.(float64)
+	if !ok || date < 0 {
+		return 0, newBadHeader(raw, headers, nil, message)
 	}
-	return headers, payload, nil
-
+	return int64(date), nil
 }
 
 func (jwss JSONWebSignatureSerializer) now() int64 {
-	return time.Now().UTC().Unix()
+	return clockOrDefault(jwss.Clock).Now().UTC().Unix()
 }
 
-func (jwss JSONWebSignatureSerializer) GetIssueDate(t int64) string {
-	return fmt.Sprintf("%s", time.Unix(t, 0).UTC())
+// GetIssueDate returns the `iat` header as a time, or the zero time if it is
+// missing or not an IntDate.
+func (jwss JSONWebSignatureSerializer) GetIssueDate(header map[string]interface{}) time.Time {
+	iat, ok := header["iat"].(float64)
+	if !ok || iat < 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(iat), 0).UTC()
 }
@@ -0,0 +1,130 @@
+package dangerous
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Regression tests for the known JWT algorithm confusion attacks. Every
+// forged token here must be refused with a typed error and without a panic.
+
+func forgeToken(header, payload string, sig []byte) string {
+	return B64encode([]byte(header)) + "." + B64encode([]byte(payload)) + "." + B64encode(sig)
+}
+
+func TestNoneAlgorithmRefused(t *testing.T) {
+	verifier, _ := NewJWS("secret-key", WithAlgorithmName("HS256"))
+	for _, alg := range []string{"none", "None", "NONE", "nOnE"} {
+		token := forgeToken(`{"alg":"`+alg+`"}`, `"value"`, nil)
+		if _, _, err := verifier.Loads(token); !errors.Is(err, ErrBadHeader) {
+			t.Fatalf("alg %s: expected BadHeader, got %v", alg, err)
+		}
+	}
+	if _, err := NewJWS("secret-key", WithAlgorithmName("none")); err == nil {
+		t.Fatalf("none should need WithAllowNone.")
+	}
+	if _, err := NewJWS("secret-key", WithAllowedAlgorithms("HS256", "none")); err == nil {
+		t.Fatalf("none should need WithAllowNone.")
+	}
+	literal := JSONWebSignatureSerializer{Secret: "secret-key", AlgorithmName: "none"}
+	if _, err := literal.Dumps("value"); err == nil {
+		t.Fatalf("none should need AllowNone.")
+	}
+
+	unsigned, _ := NewJWS("secret-key", WithAlgorithmName("none"), WithAllowNone())
+	token, _ := unsigned.Dumps("value")
+	if !strings.HasSuffix(string(token), ".") {
+		t.Fatalf("none token should have an empty signature: %s", token)
+	}
+	if _, payload, err := unsigned.Loads(string(token)); err != nil || payload.(string) != "value" {
+		t.Fatalf("none token should load when allowed. Error:%s", err)
+	}
+	if _, _, err := unsigned.Loads(string(token) + "c2ln"); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("none token with a signature should not load. Error:%v", err)
+	}
+}
+
+// The classic RS256 to HS256 attack: the public key, which the attacker
+// knows, is used as the HMAC secret.
+func TestPublicKeyAsHMACSecret(t *testing.T) {
+	der, _ := x509.MarshalPKIXPublicKey(testRSAKey.Public())
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	for _, secret := range [][]byte{publicPEM, der} {
+		header := `{"alg":"HS256"}`
+		input := B64encode([]byte(header)) + "." + B64encode([]byte(`"admin"`))
+		sig := HMACAlgorithm{DigestMethod: crypto.SHA256.New}.GetSignature(secret, []byte(input))
+		token := input + "." + B64encode(sig)
+
+		verifier, _ := NewJWS("", WithAlgorithmName("RS256"), WithVerificationKeys(testRSAKey.Public()))
+		if _, _, err := verifier.Loads(token); !errors.Is(err, ErrBadHeader) {
+			t.Fatalf("HS256 token should not be allowed, got %v", err)
+		}
+		mixed, _ := NewJWS("hmac-secret", WithAllowedAlgorithms("RS256", "HS256"),
+			WithVerificationKeys(testRSAKey.Public()))
+		if _, _, err := mixed.Loads(token); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("HS256 token signed with the public key should not verify, got %v", err)
+		}
+	}
+}
+
+func TestAlgorithmRelabeled(t *testing.T) {
+	issuer, _ := NewJWS("secret-key", WithAlgorithmName("HS512"))
+	verifier, _ := NewJWS("secret-key", WithAllowedAlgorithms("HS256", "HS512"))
+	signed, _ := issuer.Dumps("value")
+	parts := strings.Split(string(signed), ".")
+	relabeled := B64encode([]byte(`{"alg":"HS256"}`)) + "." + parts[1] + "." + parts[2]
+	if _, _, err := verifier.Loads(relabeled); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Relabeled token should not verify, got %v", err)
+	}
+
+	es256, _ := NewJWS("", WithAlgorithmName("ES256"), WithSigningKey(testP256Key))
+	signed, _ = es256.Dumps("value")
+	parts = strings.Split(string(signed), ".")
+	relabeled = B64encode([]byte(`{"alg":"ES384"}`)) + "." + parts[1] + "." + parts[2]
+	esVerifier, _ := NewJWS("", WithAllowedAlgorithms("ES256", "ES384"),
+		WithVerificationKeys(testP256Key.Public()))
+	if _, _, err := esVerifier.Loads(relabeled); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Relabeled ES256 token should not verify, got %v", err)
+	}
+	if _, _, err := esVerifier.Loads(string(signed)); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+}
+
+func TestMalformedAlgorithmHeader(t *testing.T) {
+	verifier, _ := NewJWS("secret-key")
+	for _, header := range []string{`{}`, `{"alg":null}`, `{"alg":512}`, `{"alg":["HS512"]}`, `{"alg":{"name":"HS512"}}`, `{"alg":""}`} {
+		token := forgeToken(header, `"value"`, []byte("sig"))
+		_, _, err := verifier.Loads(token)
+		var bh *BadHeader
+		if !errors.As(err, &bh) || bh.Header == nil {
+			t.Fatalf("header %s: expected BadHeader with the header, got %v", header, err)
+		}
+	}
+	for _, token := range []string{"", ".", "..", "e30", "!!.e30.sig", "W10.e30.sig", "bnVsbA.e30.sig"} {
+		if _, _, err := verifier.Loads(token); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("token %q: expected BadSignature, got %v", token, err)
+		}
+	}
+}
+
+func TestStrippedSignature(t *testing.T) {
+	for _, alg := range []string{"HS256", "RS256", "ES256", "EdDSA"} {
+		keys := map[string]crypto.Signer{"RS256": testRSAKey, "ES256": testP256Key, "EdDSA": testEdKey}
+		var verifier JSONWebSignatureSerializer
+		if key, ok := keys[alg]; ok {
+			verifier, _ = NewJWS("", WithAlgorithmName(alg), WithSigningKey(key))
+		} else {
+			verifier, _ = NewJWS("secret-key", WithAlgorithmName(alg))
+		}
+		signed, _ := verifier.Dumps("value")
+		stripped := string(signed[:strings.LastIndex(string(signed), ".")+1])
+		if _, _, err := verifier.Loads(stripped); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: stripped signature should not verify, got %v", alg, err)
+		}
+	}
+}
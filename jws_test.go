@@ -1,10 +1,19 @@
 package dangerous
 
 import (
-	"crypto/sha512"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
 )
 
 var (
@@ -15,6 +24,7 @@ func TestAlgorithm(t *testing.T) {
 	for _, alg := range []string{"HS256", "HS384", "HS512", "none"} {
 		_jws := jws
 		_jws.AlgorithmName = alg
+		_jws.AllowNone = alg == "none"
 		data, _ := _jws.Dumps("value")
 		if _, payload, err := _jws.Loads(string(data)); payload.(string) != "value" || err != nil {
 			t.Fatalf("Algorithm is not available when inputed valid algorithm name. Algorithm:%s.", alg)
@@ -25,7 +35,44 @@ func TestAlgorithm(t *testing.T) {
 func TestInvalidAlgorithm(t *testing.T) {
 	_jws := jws
 	_jws.AlgorithmName = "not exist"
-	_jws.Dumps("value")
+	if _, err := _jws.Dumps("value"); err == nil {
+		t.Fatalf("Dumps should reject an unregistered algorithm.")
+	}
+	if _, _, err := _jws.Loads("e30.e30.sig"); err == nil {
+		t.Fatalf("Loads should reject an unregistered algorithm.")
+	}
+	_jws = jws
+	_jws.AllowedAlgorithms = []string{"HS512", "not exist"}
+	if _, _, err := _jws.Loads("e30.e30.sig"); err == nil || errors.Is(err, ErrBadData) {
+		t.Fatalf("Loads should reject an unregistered allowed algorithm.")
+	}
+	if _, err := NewJWS("secret-key", WithAllowedAlgorithms("HS256", "not exist")); err == nil {
+		t.Fatalf("NewJWS should reject an unregistered allowed algorithm.")
+	}
+}
+
+func TestAllowedAlgorithms(t *testing.T) {
+	verifier, err := NewJWS("secret-key", WithAllowedAlgorithms("HS256", "HS384"))
+	if err != nil || verifier.AlgorithmName != "HS256" {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	for _, alg := range []string{"HS256", "HS384", "HS512"} {
+		issuer, _ := NewJWS("secret-key", WithAlgorithmName(alg))
+		signed, _ := issuer.Dumps("value")
+		_, payload, err := verifier.Loads(string(signed))
+		if allowed := alg != "HS512"; allowed != (err == nil) {
+			t.Fatalf("%s: unexpected error:%v", alg, err)
+		} else if allowed && payload.(string) != "value" {
+			t.Fatalf("%s: unexpected payload:%v", alg, payload)
+		}
+	}
+	if _, err := NewJWS("secret-key", WithAlgorithmName("HS512"), WithAllowedAlgorithms("HS256")); err == nil {
+		t.Fatalf("An algorithm that is not allowed should be refused.")
+	}
+	literal := JSONWebSignatureSerializer{Secret: "secret-key", AlgorithmName: "HS512", AllowedAlgorithms: []string{"HS256"}}
+	if _, err := literal.Dumps("value"); err == nil {
+		t.Fatalf("An algorithm that is not allowed should be refused.")
+	}
 }
 
 func TestAlgorithmMismatch(t *testing.T) {
@@ -39,34 +86,39 @@ func TestAlgorithmMismatch(t *testing.T) {
 
 // library can not report more error.
 func TestLoadPayloadExceptions(t *testing.T) {
-	input := [][]string{
-		{"ab", `BadPayload: No '.' found`},
-		{"a.b", `Could not base64 decode`},
-		{"ew.b", `Could not base64 decode`},
-		{"ew.ab", `Could not unserialize header because it was malformed`},
-		{"W10.ab", `Header payload is not a JSON object`},
-	}
-	signer := jws.MakeSigner()
-	signer.Algorithm = HMACAlgorithm{DigestMethod: sha512.New} // not python, we have to init manually
+	input := []struct {
+		in, msg string
+		kind    error
+	}{
+		{"ab", `No '.' found`, ErrBadPayload},
+		{"a.b", `Could not base64 decode`, ErrBadHeader},
+		{"ew.b", `Could not base64 decode`, ErrBadPayload},
+		{"ew.ab", `Could not unserialize header because it was malformed`, ErrBadHeader},
+		{"W10.ab", `Header payload is not a JSON object`, ErrBadHeader},
+	}
+	_jws, _ := NewJWS("secret-key")
 	for _, v := range input {
-		signed := signer.Sign(v[0])
-		_, _, err := jws.Loads(string(signed))
-		if !strings.Contains(err.Error(), v[1]) {
-			t.Fatalf("unexpected err:%s, expected:%s", err.Error(), v[1])
+		_, _, err := _jws.LoadPayload([]byte(v.in))
+		if !strings.Contains(err.Error(), v.msg) || !errors.Is(err, v.kind) {
+			t.Fatalf("unexpected err:%s, expected:%s", err.Error(), v.msg)
 		}
 	}
 }
 
 func TestExp(t *testing.T) {
-	jws.ExpiresIn = 2
-	signed, _ := jws.TimedDumps("value")
-	_, _, err := jws.TimedLoads(string(signed))
+	clock := dangeroustest.NewFakeClock(time.Now())
+	_jws := jws
+	_jws.ExpiresIn = 2
+	_jws.Clock = clock
+	signed, _ := _jws.TimedDumps("value")
+	clock.Advance(2 * time.Second)
+	_, _, err := _jws.TimedLoads(string(signed))
 	if err != nil {
 		t.Fatalf("Unexpected error occurred when loads data. Error:%s", err.Error())
 	}
-	time.Sleep(3 * time.Second)
-	_, value, err2 := jws.TimedLoads(string(signed))
-	if err2 == nil {
+	clock.Advance(time.Second)
+	_, value, err2 := _jws.TimedLoads(string(signed))
+	if !errors.Is(err2, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Did not receive expected error.")
 	}
 	if value.(string) != "value" {
@@ -74,6 +126,43 @@ func TestExp(t *testing.T) {
 	}
 }
 
+func TestTimedLeeway(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_jws, _ := NewJWS("secret-key", WithExpiresIn(10), WithClock(clock), WithLeeway(5))
+	signed, _ := _jws.TimedDumps("value")
+	clock.Set(time.Unix(1580000000+10+5, 0))
+	if _, _, err := _jws.TimedLoads(string(signed)); err != nil {
+		t.Fatalf("Leeway should accept the token. Error:%s", err)
+	}
+	clock.Advance(time.Second)
+	if _, _, err := _jws.TimedLoads(string(signed)); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("Expected SignatureExpired, got %v", err)
+	}
+	clock.Set(time.Unix(1580000000-6, 0))
+	if _, _, err := _jws.TimedLoads(string(signed)); !errors.Is(err, ErrSignatureFromFuture) {
+		t.Fatalf("Expected SignatureFromFuture, got %v", err)
+	}
+}
+
+func TestNotBefore(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_jws, _ := NewJWS("secret-key", WithClock(clock))
+	header := _jws.TimedMakeHeader(map[string]interface{}{"nbf": 1580000010})
+	signed, _ := _jws.Dumps("value", header)
+	if _, _, err := _jws.TimedLoads(string(signed)); !errors.Is(err, ErrBadTimeSignature) {
+		t.Fatalf("Expected BadTimeSignature, got %v", err)
+	}
+	clock.Advance(10 * time.Second)
+	if _, _, err := _jws.TimedLoads(string(signed)); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	header["nbf"] = "soon"
+	signed, _ = _jws.Dumps("value", header)
+	if _, _, err := _jws.TimedLoads(string(signed)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("Expected BadHeader, got %v", err)
+	}
+}
+
 func TestReturnHeader(t *testing.T) {
 
 }
@@ -84,7 +173,7 @@ func TestMissingExp(t *testing.T) {
 	signed, _ := jws.Dumps("value", header)
 
 	_, _, err := jws.TimedLoads(string(signed))
-	if !strings.Contains(err.Error(), `BadSignature`) {
+	if !errors.Is(err, ErrBadSignature) {
 		t.Fatalf("Load failed. Incorrect error: err%s", err.Error())
 	}
 
@@ -96,8 +185,244 @@ func TestInvalidExp(t *testing.T) {
 	signed, _ := jws.Dumps("value", header)
 
 	_, _, err := jws.TimedLoads(string(signed))
-	if !strings.Contains(err.Error(), `BadHeader`) {
+	if !errors.Is(err, ErrBadHeader) {
 		t.Fatalf("Load failed. Incorrect error: err%s", err.Error())
 	}
 
 }
+
+func TestNewJWS(t *testing.T) {
+	_jws, err := NewJWS("secret-key", WithAlgorithmName("HS256"), WithExpiresIn(10))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	signed, _ := _jws.TimedDumps("value")
+	if _, payload, err := _jws.TimedLoads(string(signed)); err != nil || payload.(string) != "value" {
+		t.Fatalf("Load failed. Error:%s", err)
+	}
+	if _, err := NewJWS("secret-key", WithAlgorithmName("not exist")); err == nil {
+		t.Fatalf("NewJWS should reject an unknown algorithm.")
+	}
+	if _, err := NewJWS("secret-key", WithExpiresIn(-1)); err == nil {
+		t.Fatalf("NewJWS should reject a negative expiry.")
+	}
+}
+
+func TestPublicKeyAlgorithms(t *testing.T) {
+	keys := map[string]crypto.Signer{
+		"RS256": testRSAKey, "RS384": testRSAKey, "RS512": testRSAKey,
+		"PS256": testRSAKey, "PS384": testRSAKey, "PS512": testRSAKey,
+		"ES256": testP256Key, "ES384": testP384Key, "ES512": testP521Key,
+		"EdDSA": testEdKey,
+	}
+	for alg, key := range keys {
+		issuer, err := NewJWS("", WithAlgorithmName(alg), WithSigningKey(key))
+		if err != nil {
+			t.Fatalf("%s: unexpected error:%s", alg, err)
+		}
+		signed, _ := issuer.Dumps("value")
+		verifier, err := NewJWS("", WithAlgorithmName(alg), WithVerificationKeys(key.Public()))
+		if err != nil {
+			t.Fatalf("%s: unexpected error:%s", alg, err)
+		}
+		header, payload, err := verifier.Loads(string(signed))
+		if err != nil || payload.(string) != "value" || header.(map[string]interface{})["alg"] != alg {
+			t.Fatalf("%s: load failed. Error:%s", alg, err)
+		}
+	}
+	if _, err := NewJWS("", WithAlgorithmName("ES256"), WithSigningKey(testP384Key)); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	wrongCurve := JSONWebSignatureSerializer{AlgorithmName: "ES256", SigningKey: testP384Key}
+	if _, err := wrongCurve.Dumps("value"); err == nil {
+		t.Fatalf("ES256 should not sign with a P-384 key.")
+	}
+}
+
+func TestES256RawSignature(t *testing.T) {
+	issuer := JSONWebSignatureSerializer{AlgorithmName: "ES256", SigningKey: testP256Key}
+	signed, _ := issuer.Dumps(map[string]interface{}{"sub": "1"})
+	parts := strings.Split(string(signed), ".")
+	sig, _ := B64decode([]byte(parts[2]))
+	if len(sig) != 64 {
+		t.Fatalf("Unexpected ES256 signature size %d.", len(sig))
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&testP256Key.PublicKey, hashed[:], r, s) {
+		t.Fatalf("ES256 signature is not R || S.")
+	}
+}
+
+// RFC 7515 appendix A.3 and RFC 8037 appendix A.4.
+func TestPublicKeyVectors(t *testing.T) {
+	b64int := func(s string) *big.Int {
+		b, _ := B64decode([]byte(s))
+		return new(big.Int).SetBytes(b)
+	}
+	p256 := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     b64int("f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU"),
+		Y:     b64int("x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"),
+	}
+	es256 := JSONWebSignatureSerializer{AlgorithmName: "ES256", VerificationKeys: []crypto.PublicKey{p256}}
+	_, payload, err := es256.Loads("eyJhbGciOiJFUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".DtEhU3ljbEg8L38VWAfUAqOyKAM6-Xx-F4GawxaepmXFCgfTjDxw5djxLa8ISlSApmWQxfKTUJqPP3-Kg6NU1Q")
+	if err != nil || payload.(map[string]interface{})["iss"] != "joe" {
+		t.Fatalf("RFC 7515 ES256 vector failed. Error:%s", err)
+	}
+
+	seed, _ := B64decode([]byte("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"))
+	edKey := ed25519.NewKeyFromSeed(seed)
+	eddsa, _ := NewJWS("", WithAlgorithmName("EdDSA"), WithSigningKey(edKey))
+	signer := eddsa.MakeSigner()
+	signed := signer.Sign("eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc")
+	expected := "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc" +
+		".hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+	if string(signed) != expected {
+		t.Fatalf("RFC 8037 EdDSA vector failed. Got:%s", signed)
+	}
+	if _, _, err := eddsa.Loads(expected); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("RFC 8037 EdDSA vector should verify and fail on its non JSON payload. Error:%s", err)
+	}
+}
+
+// From RFC 7797 section 4, with the key of RFC 7515 appendix A.1.
+func TestUnencodedPayloadRFC(t *testing.T) {
+	key, _ := B64decode([]byte("AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow"))
+	encoded, _ := NewJWS(string(key), WithAlgorithmName("HS256"))
+	unencoded, _ := NewJWS(string(key), WithAlgorithmName("HS256"), WithUnencodedPayload())
+	for _, v := range []struct {
+		jwss     JSONWebSignatureSerializer
+		expected string
+	}{
+		{encoded, "eyJhbGciOiJIUzI1NiJ9..5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"},
+		{unencoded, "eyJhbGciOiJIUzI1NiIsImI2NCI6ZmFsc2UsImNyaXQiOlsiYjY0Il19..A5dxf2s96_n5FLueVuW1Z_vh161FwXZC4YLPff6dmDY"},
+	} {
+		token, err := v.jwss.DumpsDetached([]byte("$.02"))
+		if err != nil || string(token) != v.expected {
+			t.Fatalf("Got %s, expected %s. Error:%v", token, v.expected, err)
+		}
+		// Either serializer follows the b64 header of the token.
+		if _, err := encoded.LoadsDetached(v.expected, []byte("$.02")); err != nil {
+			t.Fatalf("Unexpected error:%s", err)
+		}
+		if _, err := unencoded.LoadsDetached(v.expected, []byte("$.03")); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("Expected BadSignature, got %v", err)
+		}
+	}
+	if _, _, err := encoded.Loads("eyJhbGciOiJIUzI1NiJ9.JC4wMg.5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("The vector should verify and fail on its non JSON payload. Error:%v", err)
+	}
+	for _, token := range []string{"a.b.c", "a.b", "a..b..c", "eyJhbGciOiJIUzI1NiJ9.JC4wMg.5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"} {
+		if _, err := encoded.LoadsDetached(token, []byte("$.02")); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: expected BadSignature, got %v", token, err)
+		}
+	}
+}
+
+func TestUnencodedPayload(t *testing.T) {
+	jwss, _ := NewJWS("secret-key", WithAlgorithmName("HS256"), WithUnencodedPayload())
+	token, err := jwss.Dumps(map[string]interface{}{"a": 1}, map[string]interface{}{"crit": []string{"b64"}})
+	if err != nil || !strings.Contains(string(token), `.{"a":1}.`) {
+		t.Fatalf("Got %s. Error:%v", token, err)
+	}
+	header, payload, err := jwss.Loads(string(token))
+	if err != nil || payload.(map[string]interface{})["a"] != 1.0 || len(header.(map[string]interface{})["crit"].([]interface{})) != 1 {
+		t.Fatalf("Got %v, %v. Error:%v", header, payload, err)
+	}
+	var dst struct{ A int }
+	if _, err := jwss.LoadsInto(string(token), &dst); err != nil || dst.A != 1 {
+		t.Fatalf("Got %v. Error:%v", dst, err)
+	}
+	if _, err := jwss.Dumps("a.b"); err == nil {
+		t.Fatalf("A compact unencoded payload should not contain a dot.")
+	}
+
+	// The header given is not changed, and a crit decoded from JSON is kept.
+	fields := map[string]interface{}{"crit": []interface{}{"exp-policy"}, "exp-policy": "strict"}
+	made := jwss.MakeHeader(fields)
+	if crit, ok := made["crit"].([]string); !ok || !reflect.DeepEqual(crit, []string{"exp-policy", "b64"}) {
+		t.Fatalf("Got %v", made)
+	}
+	if len(fields) != 2 || !reflect.DeepEqual(fields["crit"], []interface{}{"exp-policy"}) {
+		t.Fatalf("MakeHeader changed its argument: %v", fields)
+	}
+	verifier, _ := NewJWS("secret-key", WithAlgorithmName("HS256"), WithCriticalHeaders("exp-policy"))
+	token, _ = jwss.Dumps("value", fields)
+	if _, payload, err := verifier.Loads(string(token)); err != nil || payload != "value" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+	for _, crit := range []interface{}{"b64", []interface{}{1}} {
+		if _, err := jwss.Dumps("value", map[string]interface{}{"crit": crit}); err == nil {
+			t.Fatalf("crit %v should be refused.", crit)
+		}
+	}
+	for _, args := range [][]interface{}{{"header"}, {map[string]string{}}, {nil}, {map[string]interface{}{}, map[string]interface{}{}}} {
+		if _, err := jwss.Dumps("value", args...); err == nil {
+			t.Fatalf("Dumps should refuse %v.", args)
+		}
+		if _, err := jwss.DumpsDetached([]byte("value"), args...); err == nil {
+			t.Fatalf("DumpsDetached should refuse %v.", args)
+		}
+		if _, err := jwss.TimedDumps("value", args...); err == nil {
+			t.Fatalf("TimedDumps should refuse %v.", args)
+		}
+	}
+	timed, _ := NewJWS("secret-key", WithAlgorithmName("HS256"), WithUnencodedPayload(), WithStandardClaims())
+	token, _ = timed.TimedDumps(map[string]interface{}{"sub": "joe"})
+	if _, payload, err := timed.TimedLoads(string(token)); err != nil || payload.(map[string]interface{})["sub"] != "joe" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+
+	signed, _ := jwss.DumpsJSON("a.b", nil, nil)
+	if !strings.Contains(string(signed), `"payload":"\"a.b\""`) {
+		t.Fatalf("Got %s", signed)
+	}
+	if _, payload, err := jwss.LoadsJSON(string(signed), VerifyAll); err != nil || payload != "a.b" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+	encoded, _ := NewJWS("secret-key", WithAlgorithmName("HS256"))
+	if _, err := DumpsGeneralJSON("value", JWSSigner{Serializer: jwss}, JWSSigner{Serializer: encoded}); err == nil {
+		t.Fatalf("Signers should agree on the payload encoding.")
+	}
+}
+
+func TestCriticalHeaders(t *testing.T) {
+	issuer, _ := NewJWS("secret-key", WithAlgorithmName("HS256"))
+	verifier, _ := NewJWS("secret-key", WithAlgorithmName("HS256"), WithCriticalHeaders("exp-policy"))
+	for _, v := range []struct {
+		header map[string]interface{}
+		valid  bool
+	}{
+		{map[string]interface{}{"crit": []string{"exp-policy"}, "exp-policy": "strict"}, true},
+		{map[string]interface{}{"crit": []string{"b64"}, "b64": true}, true},
+		{map[string]interface{}{"crit": []string{"unknown"}, "unknown": 1}, false},
+		{map[string]interface{}{"crit": []string{"exp-policy"}}, false},
+		{map[string]interface{}{"crit": []string{"kid"}, "kid": "k"}, false},
+		{map[string]interface{}{"crit": []string{}}, false},
+		{map[string]interface{}{"crit": "exp-policy", "exp-policy": "strict"}, false},
+		{map[string]interface{}{"crit": []string{"exp-policy", "exp-policy"}, "exp-policy": "strict"}, false},
+		{map[string]interface{}{"b64": false}, false},
+		{map[string]interface{}{"crit": []string{"b64"}, "b64": "false"}, false},
+	} {
+		token, _ := issuer.Dumps("value", v.header)
+		_, _, err := verifier.Loads(string(token))
+		if v.valid && err != nil || !v.valid && !errors.Is(err, ErrBadHeader) {
+			t.Fatalf("%v: got %v", v.header, err)
+		}
+		signed, _ := issuer.DumpsJSON("value", v.header, nil)
+		_, _, err = verifier.LoadsJSON(string(signed), VerifyAny)
+		if v.valid && err != nil || !v.valid && !errors.Is(err, ErrBadHeader) {
+			t.Fatalf("JSON %v: got %v", v.header, err)
+		}
+	}
+	signed, _ := issuer.DumpsJSON("value", nil, map[string]interface{}{"crit": []string{"exp-policy"}, "exp-policy": 1})
+	if _, _, err := verifier.LoadsJSON(string(signed), VerifyAny); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("An unprotected crit should be refused, got %v", err)
+	}
+	if _, err := NewJWS("secret-key", WithCriticalHeaders("alg")); err == nil {
+		t.Fatalf("A registered header cannot be critical.")
+	}
+}
@@ -0,0 +1,238 @@
+package dangerous
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JWSPolicy tells LoadsJSON how many signatures of a JWS must be valid.
+type JWSPolicy int
+
+const (
+	// VerifyAny accepts a JWS with at least one valid signature.
+	VerifyAny JWSPolicy = iota
+	// VerifyAll accepts a JWS only if all its signatures are valid.
+	VerifyAll
+)
+
+// JWSSigner is one signature of DumpsGeneralJSON: Serializer signs, with
+// Header added to its protected header, as Dumps does, and Unprotected as
+// the unprotected header, which may be nil.
+type JWSSigner struct {
+	Serializer  JSONWebSignatureSerializer
+	Header      map[string]interface{}
+	Unprotected map[string]interface{}
+}
+
+type jwsSignatureJSON struct {
+	Protected string                 `json:"protected,omitempty"`
+	Header    map[string]interface{} `json:"header,omitempty"`
+	Signature string                 `json:"signature"`
+}
+
+type jwsFlattenedJSON struct {
+	Payload string `json:"payload"`
+	jwsSignatureJSON
+}
+
+type jwsGeneralJSON struct {
+	Payload    string             `json:"payload"`
+	Signatures []jwsSignatureJSON `json:"signatures"`
+}
+
+// DumpsJSON is Dumps in the flattened JWS JSON serialization of RFC 7515,
+// with unprotected, which may be nil, as the unprotected header.
+func (jwss JSONWebSignatureSerializer) DumpsJSON(obj interface{}, header, unprotected map[string]interface{}) ([]byte, error) {
+	payload, err := jwss.dumpPayloadJSON(obj)
+	if err != nil {
+		return BlankBytes, err
+	}
+	signature, err := jwss.signJSON(payload, header, unprotected)
+	if err != nil {
+		return BlankBytes, err
+	}
+	return json.Marshal(jwsFlattenedJSON{Payload: payload, jwsSignatureJSON: signature})
+}
+
+// DumpsGeneralJSON returns obj in the general JWS JSON serialization of RFC
+// 7515, signed once by each of signers, in order. The payload is dumped by
+// the Serializer of the first, and the signers must agree on Unencoded.
+func DumpsGeneralJSON(obj interface{}, signers ...JWSSigner) ([]byte, error) {
+	if len(signers) == 0 {
+		return BlankBytes, fmt.Errorf("DumpsGeneralJSON needs at least one signer")
+	}
+	payload, err := signers[0].Serializer.dumpPayloadJSON(obj)
+	if err != nil {
+		return BlankBytes, err
+	}
+	document := jwsGeneralJSON{Payload: payload}
+	for _, s := range signers {
+		if s.Serializer.Unencoded != signers[0].Serializer.Unencoded {
+			return BlankBytes, fmt.Errorf("All signers must encode the payload alike")
+		}
+		signature, err := s.Serializer.signJSON(payload, s.Header, s.Unprotected)
+		if err != nil {
+			return BlankBytes, err
+		}
+		document.Signatures = append(document.Signatures, signature)
+	}
+	return json.Marshal(document)
+}
+
+func (jwss JSONWebSignatureSerializer) dumpPayloadJSON(obj interface{}) (string, error) {
+	if err := (&jwss).init(); err != nil {
+		return "", err
+	}
+	p, err := jwss.Serializer.Dump(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(jwss.encodePayload([]byte(p))), nil
+}
+
+// signJSON signs the payload, encoded by encodePayload, as Dumps does.
+func (jwss JSONWebSignatureSerializer) signJSON(payload string, headerfields, unprotected map[string]interface{}) (jwsSignatureJSON, error) {
+	if err := (&jwss).init(); err != nil {
+		return jwsSignatureJSON{}, err
+	}
+	signer := jwss.MakeSigner()
+	if !signer.initialized {
+		return jwsSignatureJSON{}, fmt.Errorf("JSONWebSignatureSerializer has no key to sign with %s", jwss.AlgorithmName)
+	}
+	header, err := jwss.makeHeader(headerfields)
+	if err != nil {
+		return jwsSignatureJSON{}, err
+	}
+	for name := range unprotected {
+		if _, ok := header[name]; ok {
+			return jwsSignatureJSON{}, fmt.Errorf("Header %q is both protected and unprotected", name)
+		}
+	}
+	h, err := jwss.Serializer.Dump(header)
+	if err != nil {
+		return jwsSignatureJSON{}, err
+	}
+	protected := B64encode([]byte(h))
+	sig, err := signer.GetSignatureE([]byte(protected + "." + payload))
+	if err != nil {
+		return jwsSignatureJSON{}, err
+	}
+	return jwsSignatureJSON{Protected: protected, Header: unprotected, Signature: string(sig)}, nil
+}
+
+// LoadsJSON verifies a JWS in the flattened or general JSON serialization of
+// RFC 7515 as Loads does, each signature with the algorithm of its protected
+// header, and returns the headers of the valid signatures, protected and
+// unprotected members together, and the payload. With VerifyAny, the error
+// of the first signature is returned if none is valid; with VerifyAll, the
+// error of the first invalid one.
+func (jwss JSONWebSignatureSerializer) LoadsJSON(s string, policy JWSPolicy) ([]map[string]interface{}, interface{}, error) {
+	if err := (&jwss).init(); err != nil {
+		return nil, nil, err
+	}
+	var raw struct {
+		Payload    *string                `json:"payload"`
+		Protected  *string                `json:"protected"`
+		Header     map[string]interface{} `json:"header"`
+		Signature  *string                `json:"signature"`
+		Signatures []jwsSignatureJSON     `json:"signatures"`
+	}
+	if err := json.Unmarshal(WantBytes(s), &raw); err != nil {
+		return nil, nil, newBadSignature(nil, err, fmt.Sprintf("Could not parse the JWS JSON serialization: %s", err))
+	}
+	if raw.Payload == nil {
+		return nil, nil, newBadSignature(nil, nil, `JWS has no "payload"`)
+	}
+	signatures := raw.Signatures
+	if signatures == nil {
+		if raw.Signature == nil {
+			return nil, nil, newBadSignature(nil, nil, `JWS has neither "signatures" nor "signature"`)
+		}
+		signature := jwsSignatureJSON{Header: raw.Header, Signature: *raw.Signature}
+		if raw.Protected != nil {
+			signature.Protected = *raw.Protected
+		}
+		signatures = []jwsSignatureJSON{signature}
+	} else if raw.Protected != nil || raw.Header != nil || raw.Signature != nil {
+		return nil, nil, newBadSignature(nil, nil, `JWS has both "signatures" and flattened members`)
+	}
+	if len(signatures) == 0 {
+		return nil, nil, newBadSignature(nil, nil, "JWS has no signatures")
+	}
+
+	var headers []map[string]interface{}
+	var first error
+	for _, signature := range signatures {
+		header, err := jwss.verifyJSON(*raw.Payload, signature)
+		if err != nil && first == nil {
+			first = err
+		}
+		if err != nil && policy == VerifyAll {
+			return nil, nil, err
+		}
+		if err == nil {
+			headers = append(headers, header)
+		}
+	}
+	if len(headers) == 0 {
+		return nil, nil, first
+	}
+	for _, header := range headers[1:] {
+		if isUnencoded(header) != isUnencoded(headers[0]) {
+			return nil, nil, newBadHeader(nil, header, nil, `The "b64" header differs between signatures`)
+		}
+	}
+	JSONpayload, err := decodePayload(headers[0], []byte(*raw.Payload))
+	if err != nil {
+		return headers, nil, err
+	}
+	payload, err := jwss.Serializer.Load(JSONpayload)
+	if err != nil {
+		return headers, nil, newBadPayload(err, "Could not unserialize the payload because an exception occurred")
+	}
+	return headers, payload, nil
+}
+
+// verifyJSON verifies one signature of a JWS JSON serialization and returns
+// its header.
+func (jwss JSONWebSignatureSerializer) verifyJSON(payload string, signature jwsSignatureJSON) (map[string]interface{}, error) {
+	JSONheader, err := B64decode([]byte(signature.Protected))
+	if err != nil {
+		return nil, newBadHeader(nil, nil, err, "Could not base64 decode the header because of an exception")
+	}
+	h, err := jwss.Serializer.Load(JSONheader)
+	if err != nil {
+		return nil, newBadHeader(nil, nil, err, "Could not unserialize header because it was malformed")
+	}
+	protected, ok := h.(map[string]interface{})
+	if !ok {
+		return nil, newBadHeader(nil, nil, nil, "Header payload is not a JSON object")
+	}
+	if _, ok := protected["alg"]; !ok {
+		return nil, newBadHeader(nil, protected, nil, `The "alg" header must be protected`)
+	}
+	for _, name := range []string{"crit", "b64"} {
+		if _, ok := signature.Header[name]; ok {
+			return nil, newBadHeader(nil, protected, nil, fmt.Sprintf("The %q header must be protected", name))
+		}
+	}
+	header := map[string]interface{}{}
+	for name, v := range protected {
+		header[name] = v
+	}
+	for name, v := range signature.Header {
+		if _, ok := protected[name]; ok {
+			return nil, newBadHeader(nil, protected, nil, fmt.Sprintf("Header %q is both protected and unprotected", name))
+		}
+		header[name] = v
+	}
+	signer, err := jwss.headerSigner(header)
+	if err != nil {
+		return nil, err
+	}
+	value := []byte(signature.Protected + "." + payload)
+	if signer.MatchSignature(value, []byte(signature.Signature)) == -1 {
+		return nil, newBadSignature(value, nil, fmt.Sprintf("Signature %q does not match", signature.Signature))
+	}
+	return header, nil
+}
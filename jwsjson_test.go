@@ -0,0 +1,138 @@
+package dangerous
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJWSJSONFlattened(t *testing.T) {
+	issuer, _ := NewJWS("secret-key", WithAlgorithmName("HS256"), WithKeyID("k1"))
+	signed, err := issuer.DumpsJSON("value", map[string]interface{}{"typ": "JOSE"}, map[string]interface{}{"x5u": "https://example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	var raw map[string]interface{}
+	json.Unmarshal(signed, &raw)
+	if len(raw) != 4 || raw["payload"] != B64encode([]byte(`"value"`)) || raw["header"] == nil {
+		t.Fatalf("Got %s", signed)
+	}
+
+	headers, payload, err := issuer.LoadsJSON(string(signed), VerifyAll)
+	if err != nil || payload != "value" || len(headers) != 1 {
+		t.Fatalf("Got %v, %v. Error:%v", headers, payload, err)
+	}
+	if h := headers[0]; h["alg"] != "HS256" || h["kid"] != "k1" || h["typ"] != "JOSE" || h["x5u"] != "https://example.com" {
+		t.Fatalf("Got %v", headers[0])
+	}
+	compact := raw["protected"].(string) + "." + raw["payload"].(string) + "." + raw["signature"].(string)
+	if _, payload, err := issuer.Loads(compact); err != nil || payload != "value" {
+		t.Fatalf("The compact form should load, got %v. Error:%v", payload, err)
+	}
+
+	if _, err := issuer.DumpsJSON("value", nil, map[string]interface{}{"kid": "k2"}); err == nil {
+		t.Fatalf("A header should not be both protected and unprotected.")
+	}
+	other, _ := NewJWS("other-key", WithAlgorithmName("HS256"))
+	if _, _, err := other.LoadsJSON(string(signed), VerifyAny); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+}
+
+func TestJWSJSONGeneral(t *testing.T) {
+	old, _ := NewJWS("old-secret", WithAlgorithmName("HS256"), WithKeyID("old"))
+	current, _ := NewJWS("", WithAlgorithmName("ES256"), WithSigningKey(testP256Key), WithKeyID("new"))
+	signed, err := DumpsGeneralJSON(map[string]interface{}{"sub": "joe"},
+		JWSSigner{Serializer: old},
+		JWSSigner{Serializer: current, Unprotected: map[string]interface{}{"note": "migration"}},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	var raw jwsGeneralJSON
+	if err := json.Unmarshal(signed, &raw); err != nil || len(raw.Signatures) != 2 {
+		t.Fatalf("Got %s. Error:%v", signed, err)
+	}
+
+	// Verifiers that know only the new key accept it during the migration.
+	newOnly, _ := NewJWS("", WithAlgorithmName("ES256"), WithVerificationKeys(testP256Key.Public()))
+	headers, payload, err := newOnly.LoadsJSON(string(signed), VerifyAny)
+	if err != nil || len(headers) != 1 || headers[0]["kid"] != "new" || headers[0]["note"] != "migration" {
+		t.Fatalf("Got %v. Error:%v", headers, err)
+	}
+	if payload.(map[string]interface{})["sub"] != "joe" {
+		t.Fatalf("Got %v", payload)
+	}
+	if _, _, err := newOnly.LoadsJSON(string(signed), VerifyAll); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("HS256 is not allowed, expected BadHeader, got %v", err)
+	}
+
+	both, _ := NewJWS("", WithAllowedAlgorithms("HS256", "ES256"), WithKeyResolver(KeyMap{
+		"old": {Key: []byte("old-secret")},
+		"new": {Key: &testP256Key.PublicKey},
+	}))
+	if headers, _, err := both.LoadsJSON(string(signed), VerifyAll); err != nil || len(headers) != 2 {
+		t.Fatalf("Got %v. Error:%v", headers, err)
+	}
+
+	tampered := strings.Replace(string(signed), raw.Payload, B64encode([]byte(`{"sub":"eve"}`)), 1)
+	for _, policy := range []JWSPolicy{VerifyAny, VerifyAll} {
+		if _, _, err := both.LoadsJSON(tampered, policy); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("Expected BadSignature, got %v", err)
+		}
+	}
+	if _, err := DumpsGeneralJSON("value"); err == nil {
+		t.Fatalf("DumpsGeneralJSON should need a signer.")
+	}
+}
+
+// Two parties sign the same document, each with their own key.
+func TestJWSJSONMultiParty(t *testing.T) {
+	alice, _ := NewJWS("", WithAlgorithmName("ES256"), WithSigningKey(testP256Key), WithKeyID("alice"))
+	bob, _ := NewJWS("", WithAlgorithmName("EdDSA"), WithSigningKey(testEdKey), WithKeyID("bob"))
+	verifier, _ := NewJWS("", WithAllowedAlgorithms("ES256", "EdDSA"), WithKeyResolver(JWKSet{Keys: []JWK{
+		{Key: &testP256Key.PublicKey, KeyID: "alice"},
+		{Key: testEdKey.Public(), KeyID: "bob"},
+	}}))
+
+	signed, _ := DumpsGeneralJSON("approved", JWSSigner{Serializer: alice}, JWSSigner{Serializer: bob})
+	if headers, payload, err := verifier.LoadsJSON(string(signed), VerifyAll); err != nil || len(headers) != 2 || payload != "approved" {
+		t.Fatalf("Got %v, %v. Error:%v", headers, payload, err)
+	}
+	// Bob's signature relabeled as Alice's does not count.
+	forged, _ := DumpsGeneralJSON("approved", JWSSigner{Serializer: alice},
+		JWSSigner{Serializer: bob, Header: map[string]interface{}{"kid": "alice"}})
+	if _, _, err := verifier.LoadsJSON(string(forged), VerifyAll); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+}
+
+func TestJWSJSONMalformed(t *testing.T) {
+	jwss, _ := NewJWS("secret-key", WithAlgorithmName("HS256"))
+	signed, _ := jwss.DumpsJSON("value", nil, nil)
+	var flat map[string]interface{}
+	json.Unmarshal(signed, &flat)
+	protected, payload, signature := flat["protected"].(string), flat["payload"].(string), flat["signature"].(string)
+	unprotectedAlg := B64encode([]byte(`{"typ":"JOSE"}`))
+
+	for _, document := range []string{
+		`not json`,
+		`[]`,
+		`{"protected":"` + protected + `","signature":"` + signature + `"}`,
+		`{"payload":"` + payload + `"}`,
+		`{"payload":"` + payload + `","signatures":[]}`,
+		`{"payload":"` + payload + `","signature":"` + signature + `","signatures":[{"protected":"` + protected + `","signature":"` + signature + `"}]}`,
+		`{"payload":"` + payload + `","protected":"` + unprotectedAlg + `","header":{"alg":"HS256"},"signature":"` + signature + `"}`,
+		`{"payload":"` + payload + `","protected":"` + protected + `","header":{"alg":"none"},"signature":"` + signature + `"}`,
+		`{"payload":"` + payload + `","protected":"!!","signature":"` + signature + `"}`,
+		`{"payload":"` + payload + `","signature":"` + signature + `"}`,
+	} {
+		if _, _, err := jwss.LoadsJSON(document, VerifyAny); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: expected BadSignature, got %v", document, err)
+		}
+	}
+	if _, payload, err := jwss.LoadsJSON(string(signed), VerifyAny); err != nil || payload != "value" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+}
@@ -0,0 +1,228 @@
+package dangerous
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Claims holds the registered claims of RFC 7519. Pass it to
+// TimedDumpsClaims, or embed it in a payload struct, to set any of them on a
+// token; zero fields are omitted.
+type Claims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  ClaimStrings `json:"aud,omitempty"`
+	ExpiresAt int64        `json:"exp,omitempty"`
+	NotBefore int64        `json:"nbf,omitempty"`
+	IssuedAt  int64        `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+}
+
+// ClaimStrings is a claim such as aud that may be a single string or an
+// array of strings. A single value is written as a string.
+type ClaimStrings []string
+
+func (cs ClaimStrings) MarshalJSON() ([]byte, error) {
+	if len(cs) == 1 {
+		return json.Marshal(cs[0])
+	}
+	return json.Marshal([]string(cs))
+}
+
+func (cs *ClaimStrings) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*cs = ClaimStrings{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*cs = list
+	return nil
+}
+
+// ClaimsValidator checks the registered claims of a JWT payload. Issuer and
+// Audience are only checked when set; the token must then name that issuer
+// and at least one of the audiences. Leeway is the clock skew, in seconds,
+// allowed when checking iat, exp and nbf; a token issued later than now plus
+// Leeway is refused with a SignatureFromFuture.
+type ClaimsValidator struct {
+	Issuer     string
+	Audience   []string
+	Leeway     int64
+	RequireExp bool
+	Clock      Clock
+}
+
+// Validate checks claims, the payload of a token. raw is the signed part of
+// the token, kept as the payload of the returned errors.
+func (cv ClaimsValidator) Validate(claims map[string]interface{}, raw []byte) error {
+	now := clockOrDefault(cv.Clock).Now().UTC().Unix()
+	iat, err := numericDateClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	exp, err := numericDateClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	nbf, err := numericDateClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	var issued time.Time
+	if iat != nil {
+		issued = time.Unix(*iat, 0).UTC()
+	}
+	if iat != nil && *iat-cv.Leeway > now {
+		return newSignatureFromFuture(raw, issued,
+			fmt.Sprintf("Token issued in the future, at %s", issued))
+	}
+	if exp == nil && cv.RequireExp {
+		return newBadSignature(raw, nil, "Missing expiry date")
+	}
+	if exp != nil && *exp+cv.Leeway < now {
+		return newSignatureExpired(raw, issued,
+			fmt.Sprintf("Signature expired, expired at %s", time.Unix(*exp, 0).UTC()))
+	}
+	if nbf != nil && *nbf-cv.Leeway > now {
+		return newBadTimeSignature(raw, issued, nil,
+			fmt.Sprintf("Token is not valid before %s", time.Unix(*nbf, 0).UTC()))
+	}
+	if cv.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != cv.Issuer {
+			return newBadSignature(raw, nil, fmt.Sprintf("Invalid issuer %q", claims["iss"]))
+		}
+	}
+	if len(cv.Audience) > 0 && !cv.audienceMatches(claims["aud"]) {
+		return newBadSignature(raw, nil, fmt.Sprintf("Invalid audience %v", claims["aud"]))
+	}
+	return nil
+}
+
+// audienceMatches accepts aud as a single string or an array of strings.
+func (cv ClaimsValidator) audienceMatches(aud interface{}) bool {
+	var audiences []interface{}
+	switch v := aud.(type) {
+	case string:
+		audiences = []interface{}{v}
+	case []interface{}:
+		audiences = v
+	}
+	for _, expected := range cv.Audience {
+		for _, a := range audiences {
+			if a == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDateClaim returns the claim name as seconds since the epoch, nil
+// if it is missing, or a BadPayload if it is not a NumericDate.
+func numericDateClaim(claims map[string]interface{}, name string) (*int64, error) {
+	v, ok := claims[name]
+	if !ok {
+		return nil, nil
+	}
+	var date int64
+	switch n := v.(type) {
+	case float64:
+		date = int64(n)
+	case int64:
+		date = n
+	case int:
+		date = int64(n)
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return nil, newBadPayload(err, fmt.Sprintf("Claim %q is not a NumericDate", name))
+		}
+		date = int64(f)
+	default:
+		return nil, newBadPayload(nil, fmt.Sprintf("Claim %q is not a NumericDate", name))
+	}
+	if date < 0 {
+		return nil, newBadPayload(nil, fmt.Sprintf("Claim %q is not a NumericDate", name))
+	}
+	return &date, nil
+}
+
+// claimsPayload turns obj into the JSON object a JWT carries, so that the
+// registered claims can be added to it. Numbers are kept as json.Number so
+// that large integers survive.
+func claimsPayload(obj interface{}) (map[string]interface{}, error) {
+	if m, ok := obj.(map[string]interface{}); ok {
+		claims := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			claims[k] = v
+		}
+		return claims, nil
+	}
+	dumped, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(dumped))
+	decoder.UseNumber()
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil || claims == nil {
+		return nil, fmt.Errorf("JWT payload must be a JSON object, got %T", obj)
+	}
+	return claims, nil
+}
+
+// MakeClaims adds iat, exp, iss and aud to the payload of a standard claims
+// token, keeping the values obj already has.
+func (jwss JSONWebSignatureSerializer) MakeClaims(obj interface{}) (map[string]interface{}, error) {
+	return jwss.makeClaims(obj, Claims{})
+}
+
+// makeClaims is MakeClaims with the non-zero fields of registered in place
+// of the values of the serializer.
+func (jwss JSONWebSignatureSerializer) makeClaims(obj interface{}, registered Claims) (map[string]interface{}, error) {
+	claims, err := claimsPayload(obj)
+	if err != nil {
+		return nil, err
+	}
+	iat := jwss.now()
+	defaults := map[string]interface{}{"iat": iat, "exp": iat + jwss.ExpiresIn}
+	if jwss.Issuer != "" {
+		defaults["iss"] = jwss.Issuer
+	}
+	if len(jwss.Audience) == 1 {
+		defaults["aud"] = jwss.Audience[0]
+	} else if len(jwss.Audience) > 1 {
+		defaults["aud"] = jwss.Audience
+	}
+	given, err := claimsPayload(registered)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range given {
+		defaults[k] = v
+	}
+	for k, v := range defaults {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return claims, nil
+}
+
+// ClaimsValidator returns the validator TimedLoads uses in standard claims
+// mode.
+func (jwss JSONWebSignatureSerializer) ClaimsValidator() ClaimsValidator {
+	return ClaimsValidator{
+		Issuer:     jwss.Issuer,
+		Audience:   jwss.Audience,
+		Leeway:     jwss.Leeway,
+		RequireExp: true,
+		Clock:      jwss.Clock,
+	}
+}
@@ -0,0 +1,177 @@
+package dangerous
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+type testSession struct {
+	Claims
+	UserID int64 `json:"user_id"`
+}
+
+func TestStandardClaims(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_jws, err := NewJWS("secret-key", WithStandardClaims(), WithExpiresIn(60), WithClock(clock),
+		WithIssuer("auth"), WithAudience("api"))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	session := testSession{Claims: Claims{Subject: "user-1", ID: "token-1", NotBefore: 1580000000}, UserID: 1 << 60}
+	signed, err := _jws.TimedDumps(session)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	header, payload, err := _jws.TimedLoads(string(signed))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if header["typ"] != "JWT" || header["exp"] != nil || header["iat"] != nil {
+		t.Fatalf("Unexpected header:%v", header)
+	}
+	claims := payload.(map[string]interface{})
+	expected := map[string]interface{}{
+		"iss": "auth", "aud": "api", "sub": "user-1", "jti": "token-1",
+		"iat": float64(1580000000), "nbf": float64(1580000000), "exp": float64(1580000060),
+	}
+	for k, v := range expected {
+		if claims[k] != v {
+			t.Fatalf("Claim %s is %v, expected %v", k, claims[k], v)
+		}
+	}
+	var decoded testSession
+	raw, _ := json.Marshal(claims)
+	if json.Unmarshal(raw, &decoded); decoded.Audience[0] != "api" || decoded.ExpiresAt != 1580000060 {
+		t.Fatalf("Could not decode the claims:%s", raw)
+	}
+
+	clock.Advance(61 * time.Second)
+	_, _, err = _jws.TimedLoads(string(signed))
+	var expired *SignatureExpired
+	if !errors.As(err, &expired) || !expired.DateSigned.Equal(time.Unix(1580000000, 0)) {
+		t.Fatalf("Expected SignatureExpired, got %v", err)
+	}
+	lenient := _jws
+	lenient.Leeway = 5
+	if _, _, err := lenient.TimedLoads(string(signed)); err != nil {
+		t.Fatalf("Leeway should accept the token. Error:%s", err)
+	}
+}
+
+func TestStandardClaimsMap(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_jws, _ := NewJWS("secret-key", WithStandardClaims(), WithClock(clock), WithAudience("api", "web"))
+	signed, _ := _jws.TimedDumps(map[string]interface{}{"exp": 1580000010})
+	_, payload, err := _jws.TimedLoads(string(signed))
+	if err != nil || payload.(map[string]interface{})["exp"] != float64(1580000010) {
+		t.Fatalf("exp of the payload should be kept. Error:%v", err)
+	}
+	if _, err := _jws.TimedDumps("value"); err == nil {
+		t.Fatalf("A JWT payload must be an object.")
+	}
+}
+
+func TestTimedDumpsClaims(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_jws, _ := NewJWS("secret-key", WithStandardClaims(), WithExpiresIn(60), WithClock(clock),
+		WithIssuer("auth"), WithAudience("api"))
+	registered := Claims{Subject: "user-1", ID: "token-1", NotBefore: 1580000000, Audience: ClaimStrings{"web"}}
+	signed, err := _jws.TimedDumpsClaims(map[string]interface{}{"user_id": 1, "jti": "mine"}, registered)
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	verifier, _ := NewJWS("secret-key", WithStandardClaims(), WithClock(clock), WithIssuer("auth"), WithAudience("web"))
+	header, payload, err := verifier.TimedLoads(string(signed))
+	if err != nil || header["typ"] != "JWT" {
+		t.Fatalf("Got %v. Error:%v", header, err)
+	}
+	expected := map[string]interface{}{
+		"iss": "auth", "aud": "web", "sub": "user-1", "jti": "mine", "user_id": float64(1),
+		"iat": float64(1580000000), "nbf": float64(1580000000), "exp": float64(1580000060),
+	}
+	if !reflect.DeepEqual(payload, expected) {
+		t.Fatalf("Got %v, expected %v", payload, expected)
+	}
+
+	plain, _ := NewJWS("secret-key")
+	if _, err := plain.TimedDumpsClaims(map[string]interface{}{}, registered); err == nil {
+		t.Fatalf("TimedDumpsClaims should need StandardClaims.")
+	}
+	if _, err := _jws.TimedDumpsClaims(map[string]interface{}{}, registered, "header"); err == nil {
+		t.Fatalf("TimedDumpsClaims should refuse a header that is not a map.")
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	for _, v := range []struct {
+		claims  Claims
+		encoded string
+	}{
+		{Claims{Audience: ClaimStrings{"api"}}, `{"aud":"api"}`},
+		{Claims{Audience: ClaimStrings{"api", "web"}}, `{"aud":["api","web"]}`},
+		{Claims{}, `{}`},
+	} {
+		data, err := json.Marshal(v.claims)
+		if err != nil || string(data) != v.encoded {
+			t.Fatalf("Got %s, expected %s. Error:%v", data, v.encoded, err)
+		}
+		var decoded Claims
+		if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, v.claims) {
+			t.Fatalf("Got %+v, expected %+v. Error:%v", decoded, v.claims, err)
+		}
+	}
+}
+
+func TestClaimsValidator(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	validator := ClaimsValidator{Issuer: "auth", Audience: []string{"api"}, RequireExp: true, Clock: clock}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"iss": "auth", "aud": []interface{}{"web", "api"}, "exp": float64(1580000010)}
+	}
+	if err := validator.Validate(valid(), nil); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	for _, v := range []struct {
+		name   string
+		change func(map[string]interface{})
+		kind   error
+	}{
+		{"missing exp", func(c map[string]interface{}) { delete(c, "exp") }, ErrBadSignature},
+		{"string exp", func(c map[string]interface{}) { c["exp"] = "soon" }, ErrBadPayload},
+		{"negative exp", func(c map[string]interface{}) { c["exp"] = float64(-1) }, ErrBadPayload},
+		{"expired", func(c map[string]interface{}) { c["exp"] = float64(1579999999) }, ErrSignatureExpired},
+		{"not before", func(c map[string]interface{}) { c["nbf"] = float64(1580000001) }, ErrBadTimeSignature},
+		{"future", func(c map[string]interface{}) { c["iat"] = float64(1580000001) }, ErrSignatureFromFuture},
+		{"issuer", func(c map[string]interface{}) { c["iss"] = "other" }, ErrBadSignature},
+		{"missing issuer", func(c map[string]interface{}) { delete(c, "iss") }, ErrBadSignature},
+		{"audience", func(c map[string]interface{}) { c["aud"] = "web" }, ErrBadSignature},
+		{"missing audience", func(c map[string]interface{}) { delete(c, "aud") }, ErrBadSignature},
+	} {
+		claims := valid()
+		v.change(claims)
+		if err := validator.Validate(claims, nil); !errors.Is(err, v.kind) {
+			t.Fatalf("%s: expected %s, got %v", v.name, v.kind, err)
+		}
+	}
+	lenient := validator
+	lenient.Leeway = 1
+	claims := valid()
+	claims["nbf"] = float64(1580000001)
+	if err := lenient.Validate(claims, nil); err != nil {
+		t.Fatalf("Leeway should accept nbf. Error:%s", err)
+	}
+}
+
+func TestLegacyTimedHeader(t *testing.T) {
+	_jws, _ := NewJWS("secret-key")
+	signed, _ := _jws.TimedDumps("value")
+	header, payload, err := _jws.TimedLoads(string(signed))
+	if err != nil || header["exp"] == nil || header["iat"] == nil || payload.(string) != "value" {
+		t.Fatalf("Legacy tokens should keep iat and exp in the header. Error:%v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package dangerous
+
+import "errors"
+
+// ErrUnknownKeyID is returned by KeyMap and JWKSet for a key ID they do not
+// have.
+var ErrUnknownKeyID = errors.New("unknown key ID")
+
+// KeyResolver finds the key that verifies a JWS from its kid, empty if the
+// token has none, and the rest of its header, which is not verified yet.
+//
+// Key of the returned JWK is a []byte secret for the HS* algorithms and a
+// public key, or a private key whose public half is used, for the others.
+// If its Algorithm is set, it must be the alg of the header.
+type KeyResolver interface {
+	ResolveKey(kid string, header map[string]interface{}) (JWK, error)
+}
+
+// KeyResolverFunc is a function used as a KeyResolver.
+type KeyResolverFunc func(kid string, header map[string]interface{}) (JWK, error)
+
+func (f KeyResolverFunc) ResolveKey(kid string, header map[string]interface{}) (JWK, error) {
+	return f(kid, header)
+}
+
+// KeyMap is a KeyResolver of static keys by key ID. The key of the empty ID,
+// if any, verifies tokens without a kid.
+type KeyMap map[string]JWK
+
+func (m KeyMap) ResolveKey(kid string, header map[string]interface{}) (JWK, error) {
+	key, ok := m[kid]
+	if !ok {
+		return JWK{}, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// ResolveKey makes a JWKSet a KeyResolver. It returns the first key with the
+// key ID kid that is not for "enc" use and whose algorithm, if set, is the
+// alg of the header.
+func (s JWKSet) ResolveKey(kid string, header map[string]interface{}) (JWK, error) {
+	alg, _ := header["alg"].(string)
+	for _, key := range s.Keys {
+		if key.KeyID == kid && key.Use != "enc" && (key.Algorithm == "" || key.Algorithm == alg) {
+			return key, nil
+		}
+	}
+	return JWK{}, ErrUnknownKeyID
+}
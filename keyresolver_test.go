@@ -0,0 +1,175 @@
+package dangerous
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyID(t *testing.T) {
+	issuer, _ := NewJWS("secret-key", WithKeyID("2024-01"))
+	token, _ := issuer.Dumps("value")
+	header, err := issuer.UnverifiedHeader(string(token))
+	if err != nil || header["kid"] != "2024-01" {
+		t.Fatalf("Got %v. Error:%v", header, err)
+	}
+	token, _ = issuer.Dumps("value", map[string]interface{}{"kid": "other"})
+	if header, _ := issuer.UnverifiedHeader(string(token)); header["kid"] != "other" {
+		t.Fatalf("Got %v", header)
+	}
+	token, _ = issuer.TimedDumps("value")
+	if header, _ := issuer.UnverifiedHeader(string(token)); header["kid"] != "2024-01" {
+		t.Fatalf("Got %v", header)
+	}
+
+	set := JWKSet{Keys: []JWK{
+		{Key: []byte("old"), KeyID: "hs-old"},
+		{Key: []byte("new"), KeyID: "hs-new"},
+		{Key: testP256Key, KeyID: "es", Algorithm: "ES256"},
+	}}
+	for alg, kid := range map[string]string{"HS256": "hs-new", "ES256": "es"} {
+		jwss, err := NewJWS("", WithJWKSet(set), WithAlgorithmName(alg))
+		if err != nil || jwss.KeyID != kid {
+			t.Fatalf("%s: got %q. Error:%v", alg, jwss.KeyID, err)
+		}
+	}
+	if jwss, _ := NewJWS("", WithJWKSet(set), WithKeyID("mine")); jwss.KeyID != "mine" {
+		t.Fatalf("Got %q", jwss.KeyID)
+	}
+}
+
+func TestKeyMap(t *testing.T) {
+	keys := KeyMap{
+		"hs-old": {Key: []byte("old-secret")},
+		"hs-new": {Key: []byte("new-secret"), Algorithm: "HS256"},
+		"rs":     {Key: &testRSAKey.PublicKey},
+		"ed":     {Key: testEdKey},
+	}
+	verifier, err := NewJWS("", WithAllowedAlgorithms("HS256", "RS256", "EdDSA"), WithKeyResolver(keys))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	issuers := []JSONWebSignatureSerializer{}
+	for _, opts := range [][]Option{
+		{WithAlgorithmName("HS256"), WithSecretKeys("old-secret"), WithKeyID("hs-old")},
+		{WithAlgorithmName("HS256"), WithSecretKeys("new-secret"), WithKeyID("hs-new")},
+		{WithAlgorithmName("RS256"), WithSigningKey(testRSAKey), WithKeyID("rs")},
+		{WithAlgorithmName("EdDSA"), WithSigningKey(testEdKey), WithKeyID("ed")},
+	} {
+		issuer, err := NewJWS("", opts...)
+		if err != nil {
+			t.Fatalf("Unexpected error:%s", err)
+		}
+		issuers = append(issuers, issuer)
+		token, _ := issuer.Dumps("value")
+		if _, payload, err := verifier.Loads(string(token)); err != nil || payload != "value" {
+			t.Fatalf("%s: got %v. Error:%v", issuer.KeyID, payload, err)
+		}
+		token, _ = issuer.TimedDumps("value")
+		if _, payload, err := verifier.TimedLoads(string(token)); err != nil || payload != "value" {
+			t.Fatalf("%s: got %v. Error:%v", issuer.KeyID, payload, err)
+		}
+	}
+
+	token, _ := issuers[0].Dumps("value", map[string]interface{}{"kid": "hs-new"})
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+	for _, kid := range []interface{}{"unknown", nil} {
+		token, _ := issuers[0].Dumps("value", map[string]interface{}{"kid": kid})
+		if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) || kid != nil && !errors.Is(err, ErrUnknownKeyID) {
+			t.Fatalf("kid %v: expected BadHeader, got %v", kid, err)
+		}
+	}
+	hs512, _ := NewJWS("old-secret", WithAlgorithmName("HS512"), WithKeyID("hs-old"))
+	token, _ = hs512.Dumps("value")
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("HS512 is not allowed, got %v", err)
+	}
+	if _, err := verifier.Dumps("value"); err == nil {
+		t.Fatalf("A verifier without keys should not sign.")
+	}
+	withoutKID, _ := NewJWS("", WithAlgorithmName("HS256"), WithKeyResolver(KeyMap{"": {Key: []byte("default")}}))
+	token, _ = JSONWebSignatureSerializer{Secret: "default", AlgorithmName: "HS256"}.Dumps("value")
+	if _, _, err := withoutKID.Loads(string(token)); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+}
+
+// The key a kid names must be used with its own kind of algorithm only.
+func TestKeyResolverConfusion(t *testing.T) {
+	keys := KeyMap{
+		"rs":     {Key: &testRSAKey.PublicKey},
+		"hs":     {Key: []byte("secret")},
+		"pinned": {Key: []byte("secret"), Algorithm: "HS512"},
+		"enc":    {Key: []byte("secret"), Use: "enc"},
+		"sign":   {Key: []byte("secret"), KeyOps: []string{"sign"}},
+	}
+	verifier, _ := NewJWS("", WithAllowedAlgorithms("HS256", "RS256"), WithKeyResolver(keys))
+
+	der, _ := x509.MarshalPKIXPublicKey(testRSAKey.Public())
+	forged, _ := NewJWS(string(der), WithAlgorithmName("HS256"), WithKeyID("rs"))
+	token, _ := forged.Dumps("admin")
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("An RSA key should not verify HS256, got %v", err)
+	}
+	for _, kid := range []string{"pinned", "enc", "sign"} {
+		issuer, _ := NewJWS("secret", WithAlgorithmName("HS256"), WithKeyID(kid))
+		token, _ := issuer.Dumps("value")
+		if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+			t.Fatalf("%s: expected BadHeader, got %v", kid, err)
+		}
+	}
+	token = []byte(forgeToken(`{"alg":"RS256","kid":"hs"}`, `"admin"`, []byte("sig")))
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("A secret should not verify RS256, got %v", err)
+	}
+	token = []byte(forgeToken(`{"alg":"HS256","kid":1}`, `"admin"`, []byte("sig")))
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("A numeric kid should be refused, got %v", err)
+	}
+	token = []byte(forgeToken(`{"alg":"none","kid":"hs"}`, `"admin"`, nil))
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("none should be refused, got %v", err)
+	}
+}
+
+func TestKeyResolvers(t *testing.T) {
+	set := JWKSet{Keys: []JWK{
+		{Key: testP256Key, KeyID: "a", Algorithm: "ES256"},
+		{Key: testP384Key, KeyID: "b", Algorithm: "ES384"},
+	}}
+	issuer, _ := NewJWS("", WithJWKSet(JWKSet{Keys: set.Keys[1:]}))
+	token, _ := issuer.Dumps("value")
+
+	verifier, _ := NewJWS("", WithAllowedAlgorithms("ES256", "ES384"), WithKeyResolver(set.Public()))
+	if _, payload, err := verifier.Loads(string(token)); err != nil || payload != "value" {
+		t.Fatalf("Got %v. Error:%v", payload, err)
+	}
+
+	// Two issuers, told apart by the prefix of their key IDs.
+	issuers := map[string]KeyResolver{
+		"first":  set.Public(),
+		"second": KeyMap{"b": {Key: &testP256Key.PublicKey}},
+	}
+	var seen string
+	byIssuer := KeyResolverFunc(func(kid string, header map[string]interface{}) (JWK, error) {
+		seen = kid
+		parts := strings.SplitN(kid, "/", 2)
+		resolver, ok := issuers[parts[0]]
+		if len(parts) != 2 || !ok {
+			return JWK{}, ErrUnknownKeyID
+		}
+		return resolver.ResolveKey(parts[1], header)
+	})
+	verifier, _ = NewJWS("", WithAllowedAlgorithms("ES256", "ES384"), WithKeyResolver(byIssuer))
+	token, _ = issuer.Dumps("value", map[string]interface{}{"kid": "first/b"})
+	if _, _, err := verifier.Loads(string(token)); err != nil || seen != "first/b" {
+		t.Fatalf("Got %q. Error:%v", seen, err)
+	}
+	token, _ = issuer.Dumps("value", map[string]interface{}{"kid": "second/b"})
+	if _, _, err := verifier.Loads(string(token)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Expected BadSignature, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package dangerous
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// MsgPackCodec encodes payloads as MessagePack. []byte is encoded as bin and
+// time.Time as the timestamp extension; other extension types are refused.
+// Decoded integers are int64, or uint64 if they do not fit.
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &msgpackWriter{}
+	if err := encodeValue(w, reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+func (MsgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	d := msgpackDecoder{data: data}
+	g, err := d.decode(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("msgpack: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return unmarshalGeneric(g, v)
+}
+
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) bytes() []byte          { return w.buf }
+func (w *msgpackWriter) newWriter() valueWriter { return &msgpackWriter{} }
+func (w *msgpackWriter) writeRaw(b []byte)      { w.buf = append(w.buf, b...) }
+func (w *msgpackWriter) writeNil()              { w.buf = append(w.buf, 0xc0) }
+
+func (w *msgpackWriter) writeBool(b bool) {
+	if b {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		w.writeUint(uint64(i))
+	case i >= -32:
+		w.buf = append(w.buf, byte(i))
+	case i >= math.MinInt8:
+		w.buf = append(w.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		w.buf = append(w.buf, 0xd1)
+		w.buf = appendUint16(w.buf, uint16(i))
+	case i >= math.MinInt32:
+		w.buf = append(w.buf, 0xd2)
+		w.buf = appendUint32(w.buf, uint32(i))
+	default:
+		w.buf = append(w.buf, 0xd3)
+		w.buf = appendUint64(w.buf, uint64(i))
+	}
+}
+
+func (w *msgpackWriter) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		w.buf = append(w.buf, byte(u))
+	case u <= math.MaxUint8:
+		w.buf = append(w.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		w.buf = append(w.buf, 0xcd)
+		w.buf = appendUint16(w.buf, uint16(u))
+	case u <= math.MaxUint32:
+		w.buf = append(w.buf, 0xce)
+		w.buf = appendUint32(w.buf, uint32(u))
+	default:
+		w.buf = append(w.buf, 0xcf)
+		w.buf = appendUint64(w.buf, u)
+	}
+}
+
+func (w *msgpackWriter) writeFloat32(f float32) {
+	w.buf = append(w.buf, 0xca)
+	w.buf = appendUint32(w.buf, math.Float32bits(f))
+}
+
+func (w *msgpackWriter) writeFloat64(f float64) {
+	w.buf = append(w.buf, 0xcb)
+	w.buf = appendUint64(w.buf, math.Float64bits(f))
+}
+
+// writeLength writes the header of a str, bin, array or map of n items. fix
+// is the fixed-size type byte, or 0 if there is none, and fixMax its limit.
+func (w *msgpackWriter) writeLength(n int, fix byte, fixMax int, b8, b16, b32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		w.buf = append(w.buf, fix|byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		w.buf = append(w.buf, b8, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, b16)
+		w.buf = appendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, b32)
+		w.buf = appendUint32(w.buf, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	w.writeLength(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) writeBytes(b []byte) {
+	w.writeLength(len(b), 0, 0, 0xc4, 0xc5, 0xc6)
+	w.buf = append(w.buf, b...)
+}
+
+func (w *msgpackWriter) writeArrayHeader(n int) {
+	w.writeLength(n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	w.writeLength(n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+// writeTime writes the timestamp extension, type -1, in its smallest form.
+func (w *msgpackWriter) writeTime(t time.Time) {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case nsec == 0 && sec >= 0 && sec <= math.MaxUint32:
+		w.buf = append(w.buf, 0xd6, 0xff)
+		w.buf = appendUint32(w.buf, uint32(sec))
+	case sec >= 0 && sec>>34 == 0:
+		w.buf = append(w.buf, 0xd7, 0xff)
+		w.buf = appendUint64(w.buf, uint64(nsec)<<34|uint64(sec))
+	default:
+		w.buf = append(w.buf, 0xc7, 12, 0xff)
+		w.buf = appendUint32(w.buf, uint32(nsec))
+		w.buf = appendUint64(w.buf, uint64(sec))
+	}
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("msgpack: unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// readLength reads a length of size bytes, refusing lengths of more items
+// than there are bytes left, which could only come from a hostile token.
+func (d *msgpackDecoder) readLength(size int) (int, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, fmt.Errorf("msgpack: length %d exceeds the data", n)
+	}
+	return int(n), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, fmt.Errorf("msgpack: data is nested too deeply")
+	}
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return d.decodeString(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.decodeArray(int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return d.decodeMap(int(c&0x0f), depth)
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, nil
+	case 0xca:
+		u, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.readUint(8)
+		return math.Float64frombits(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.read(n)
+		return append([]byte{}, raw...), err
+	case 0xdc, 0xdd:
+		n, err := d.readLength(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.readLength(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (c - 0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLength(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", c)
+}
+
+func (d *msgpackDecoder) decodeString(n int) (interface{}, error) {
+	b, err := d.read(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) decodeArray(n int, depth int) (interface{}, error) {
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int, depth int) (interface{}, error) {
+	keys, values := make([]interface{}, n), make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		keys[i], values[i] = k, v
+	}
+	return genericMap(keys, values)
+}
+
+// decodeExt decodes an extension of n data bytes. Only the timestamp
+// extension is supported.
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	typ, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != -1 {
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", int8(typ[0]))
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0).UTC(), nil
+	case 8:
+		u := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(u&(1<<34-1)), int64(u>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(b)
+		sec := int64(binary.BigEndian.Uint64(b[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	}
+	return nil, fmt.Errorf("msgpack: invalid timestamp of %d bytes", n)
+}
@@ -0,0 +1,492 @@
+package dangerous
+
+import (
+	"crypto"
+	"fmt"
+	"hash"
+	"net/http"
+)
+
+// Option configures the values built by NewSigner, NewSerializer and NewJWS.
+// Options that do not apply to the value being built are ignored.
+type Option func(*options) error
+
+type options struct {
+	secretKeys          []string
+	salt                string
+	sep                 string
+	keyDerivation       string
+	digestMethod        func() hash.Hash
+	algorithm           Signature
+	serializer          JSONAPI
+	codec               Codec
+	cipher              string
+	compress            bool
+	djangoLegacy        bool
+	fallbackSigners     []map[string]interface{}
+	algorithmName       string
+	allowedAlgorithms   []string
+	allowNone           bool
+	standardClaims      bool
+	issuer              string
+	audience            []string
+	leeway              int64
+	epoch               int64
+	detectLegacyEpoch   bool
+	maxTokenLength      int
+	maxDecompressedSize int64
+	jwkSet              *JWKSet
+	keyID               string
+	keyResolver         KeyResolver
+	httpClient          *http.Client
+	unencoded           bool
+	criticalHeaders     []string
+	jwksMaxAge          int64
+	jwksRefreshInterval int64
+	expiresIn           int64
+	clock               Clock
+	signingKey          crypto.Signer
+	verificationKeys    []crypto.PublicKey
+}
+
+func newOptions(opts []Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.jwkSet != nil {
+		if err := o.applyJWKSet(); err != nil {
+			return nil, err
+		}
+	}
+	if o.algorithm == nil && o.algorithmName != "" {
+		o.algorithm, _ = LookupAlgorithm(o.algorithmName)
+	}
+	return o, nil
+}
+
+// signer builds an unvalidated Signer from the options.
+func (o *options) signer(secret, salt string) Signer {
+	return Signer{
+		Secret:            secret,
+		SecretKeys:        o.secretKeys,
+		Salt:              salt,
+		Sep:               o.sep,
+		KeyDerivation:     o.keyDerivation,
+		DigestMethod:      o.digestMethod,
+		Algorithm:         o.algorithm,
+		Clock:             o.clock,
+		Leeway:            o.leeway,
+		Epoch:             o.epoch,
+		DetectLegacyEpoch: o.detectLegacyEpoch,
+		SigningKey:        o.signingKey,
+		VerificationKeys:  o.verificationKeys,
+	}
+}
+
+// applyJWKSet adds the keys of the WithJWKSet option to the others.
+func (o *options) applyJWKSet() error {
+	var names []string
+	var signingName, signingKID, secretKID string
+	seen := map[string]bool{}
+	for _, key := range o.jwkSet.Keys {
+		if key.Use == "enc" {
+			continue
+		}
+		switch k := key.Key.(type) {
+		case []byte:
+			o.secretKeys = append(o.secretKeys, string(k))
+			secretKID = key.KeyID
+		case crypto.Signer:
+			o.signingKey = k
+			signingName, signingKID = key.Algorithm, key.KeyID
+			o.verificationKeys = append(o.verificationKeys, k.Public())
+		default:
+			o.verificationKeys = append(o.verificationKeys, k)
+		}
+		if key.Algorithm == "" || seen[key.Algorithm] {
+			continue
+		}
+		if _, ok := LookupAlgorithm(key.Algorithm); !ok {
+			return fmt.Errorf("Invalid algorithm %q of JWK %q", key.Algorithm, key.KeyID)
+		}
+		seen[key.Algorithm] = true
+		names = append(names, key.Algorithm)
+	}
+	if o.algorithmName == "" && len(o.allowedAlgorithms) == 0 && o.algorithm == nil && len(names) > 0 {
+		o.algorithmName = names[0]
+		if signingName != "" {
+			o.algorithmName = signingName
+		}
+		o.allowedAlgorithms = names
+	}
+	if o.keyID == "" {
+		name := o.algorithmName
+		if name == "" && len(o.allowedAlgorithms) > 0 {
+			name = o.allowedAlgorithms[0]
+		}
+		if _, public := o.algorithm.(PublicKeySignature); public || isPublicKeyAlgorithm(name) {
+			o.keyID = signingKID
+		} else {
+			o.keyID = secretKID
+		}
+	}
+	return nil
+}
+
+func isPublicKeyAlgorithm(name string) bool {
+	alg, _ := LookupAlgorithm(name)
+	_, ok := alg.(PublicKeySignature)
+	return ok
+}
+
+// WithSecretKeys sets the secret keys, oldest to newest. The secret passed to
+// the constructor may then be empty.
+func WithSecretKeys(secrets ...string) Option {
+	return func(o *options) error {
+		for _, secret := range secrets {
+			if secret == "" {
+				return fmt.Errorf("secret key is empty")
+			}
+		}
+		o.secretKeys = secrets
+		return nil
+	}
+}
+
+// WithSalt sets the salt.
+func WithSalt(salt string) Option {
+	return func(o *options) error {
+		o.salt = salt
+		return nil
+	}
+}
+
+// WithSep sets the separator between value and signature.
+func WithSep(sep string) Option {
+	return func(o *options) error {
+		o.sep = sep
+		return nil
+	}
+}
+
+// WithKeyDerivation sets the key derivation: concat, django-concat, hmac or none.
+func WithKeyDerivation(keyDerivation string) Option {
+	return func(o *options) error {
+		o.keyDerivation = keyDerivation
+		return nil
+	}
+}
+
+// WithDigestMethod sets the digest used for key derivation and HMAC signatures.
+func WithDigestMethod(digestMethod func() hash.Hash) Option {
+	return func(o *options) error {
+		o.digestMethod = digestMethod
+		return nil
+	}
+}
+
+// WithAlgorithm sets the signature algorithm of a Signer or Serializer.
+func WithAlgorithm(algorithm Signature) Option {
+	return func(o *options) error {
+		o.algorithm = algorithm
+		return nil
+	}
+}
+
+// WithSerializer sets the JSONAPI used to dump and load payloads.
+func WithSerializer(serializer JSONAPI) Option {
+	return func(o *options) error {
+		o.serializer = serializer
+		return nil
+	}
+}
+
+// WithCodec sets the Codec a Serializer uses to dump and load payloads, in
+// place of its JSONAPI: JSON{}, GobCodec{}, MsgPackCodec{}, CBORCodec{} or
+// one of your own.
+func WithCodec(codec Codec) Option {
+	return func(o *options) error {
+		o.codec = codec
+		return nil
+	}
+}
+
+// WithCipher sets the cipher of an EncryptedSerializer, CipherAES256GCM or
+// CipherAES256CBCHMAC.
+func WithCipher(name string) Option {
+	return func(o *options) error {
+		if _, ok := cipherIDs[name]; !ok {
+			return fmt.Errorf("Unknown cipher %q", name)
+		}
+		o.cipher = name
+		return nil
+	}
+}
+
+// WithCompress makes a DjangoSerializer zlib-compress payloads when that
+// makes them shorter, as signing.dumps(compress=True).
+func WithCompress() Option {
+	return func(o *options) error {
+		o.compress = true
+		return nil
+	}
+}
+
+// WithDjangoLegacy makes a DjangoSigner or DjangoSerializer also accept the
+// sha1 signatures of Django before 3.1.
+func WithDjangoLegacy() Option {
+	return func(o *options) error {
+		o.djangoLegacy = true
+		return nil
+	}
+}
+
+// WithFallbackSigners sets the Signer kwargs tried after the main signer of a
+// Serializer fails to verify a value.
+func WithFallbackSigners(kwargs ...map[string]interface{}) Option {
+	return func(o *options) error {
+		o.fallbackSigners = kwargs
+		return nil
+	}
+}
+
+// WithAlgorithmName sets the algorithm by its registered name, e.g. HS256.
+// For a Signer or Serializer it is ignored if WithAlgorithm is given.
+func WithAlgorithmName(name string) Option {
+	return func(o *options) error {
+		if _, ok := LookupAlgorithm(name); !ok {
+			return fmt.Errorf("Invalid algorithm %q", name)
+		}
+		o.algorithmName = name
+		return nil
+	}
+}
+
+// WithAllowedAlgorithms sets the algorithms a JSONWebSignatureSerializer
+// accepts on Loads.
+func WithAllowedAlgorithms(names ...string) Option {
+	return func(o *options) error {
+		for _, name := range names {
+			if _, ok := LookupAlgorithm(name); !ok {
+				return fmt.Errorf("Invalid algorithm %q", name)
+			}
+		}
+		o.allowedAlgorithms = names
+		return nil
+	}
+}
+
+// WithAllowNone lets a JSONWebSignatureSerializer use the unsigned "none"
+// algorithm. It still has to be the AlgorithmName or an allowed algorithm.
+func WithAllowNone() Option {
+	return func(o *options) error {
+		o.allowNone = true
+		return nil
+	}
+}
+
+// WithExpiresIn sets the lifetime in seconds of timed JWS tokens.
+func WithExpiresIn(seconds int64) Option {
+	return func(o *options) error {
+		if seconds <= 0 {
+			return fmt.Errorf("expires in must be positive, got %d", seconds)
+		}
+		o.expiresIn = seconds
+		return nil
+	}
+}
+
+// WithClock sets the Clock used for timestamps and expiry checks.
+func WithClock(clock Clock) Option {
+	return func(o *options) error {
+		o.clock = clock
+		return nil
+	}
+}
+
+// WithSigningKey sets the private key used with a PublicKeySignature algorithm.
+func WithSigningKey(key crypto.Signer) Option {
+	return func(o *options) error {
+		o.signingKey = key
+		return nil
+	}
+}
+
+// WithVerificationKeys sets the public keys, oldest to newest, used with a
+// PublicKeySignature algorithm.
+func WithVerificationKeys(keys ...crypto.PublicKey) Option {
+	return func(o *options) error {
+		o.verificationKeys = keys
+		return nil
+	}
+}
+
+// WithStandardClaims makes a JSONWebSignatureSerializer put iat and exp in
+// the payload as RFC 7519 claims instead of in the header.
+func WithStandardClaims() Option {
+	return func(o *options) error {
+		o.standardClaims = true
+		return nil
+	}
+}
+
+// WithIssuer sets the iss claim issued and expected in standard claims mode.
+func WithIssuer(issuer string) Option {
+	return func(o *options) error {
+		o.issuer = issuer
+		return nil
+	}
+}
+
+// WithAudience sets the aud claim issued and expected in standard claims mode.
+func WithAudience(audience ...string) Option {
+	return func(o *options) error {
+		o.audience = audience
+		return nil
+	}
+}
+
+// WithLeeway sets the clock skew, in seconds, allowed when checking token
+// timestamps, expiry and not-before dates.
+func WithLeeway(seconds int64) Option {
+	return func(o *options) error {
+		if seconds < 0 {
+			return fmt.Errorf("leeway must not be negative, got %d", seconds)
+		}
+		o.leeway = seconds
+		return nil
+	}
+}
+
+// WithEpoch sets the Unix time from which SignTimestamp counts, such as
+// LegacyEpoch.
+func WithEpoch(epoch int64) Option {
+	return func(o *options) error {
+		if epoch < 0 {
+			return fmt.Errorf("epoch must not be negative, got %d", epoch)
+		}
+		o.epoch = epoch
+		return nil
+	}
+}
+
+// WithDetectLegacyEpoch makes UnSignTimestamp also accept timestamps counted
+// from LegacyEpoch, see Signer.
+func WithDetectLegacyEpoch() Option {
+	return func(o *options) error {
+		o.detectLegacyEpoch = true
+		return nil
+	}
+}
+
+// WithMaxTokenLength sets the length of the longest token a Serializer
+// loads. A negative length means no limit.
+func WithMaxTokenLength(length int) Option {
+	return func(o *options) error {
+		o.maxTokenLength = length
+		return nil
+	}
+}
+
+// WithMaxDecompressedSize sets the size, in bytes, to which a Serializer
+// inflates a compressed payload at most. A negative size means no limit.
+func WithMaxDecompressedSize(size int64) Option {
+	return func(o *options) error {
+		o.maxDecompressedSize = size
+		return nil
+	}
+}
+
+// WithJWKSet takes its keys from a JSON Web Key Set, in order, after the
+// keys of the other options: oct keys are secret keys, the last private key
+// is the signing key and every RSA, EC or OKP key is a verification key.
+// Keys for "enc" use are skipped. Unless the algorithms are set by other
+// options, the "alg" members of the keys are the allowed algorithms, the one
+// of the signing key, or else the first, signing. The kid of the newest key
+// of the signing algorithm is the key ID, unless WithKeyID is given.
+func WithJWKSet(set JWKSet) Option {
+	return func(o *options) error {
+		if len(set.Keys) == 0 {
+			return fmt.Errorf("JWK Set has no keys")
+		}
+		o.jwkSet = &set
+		return nil
+	}
+}
+
+// WithKeyID sets the kid header a JSONWebSignatureSerializer writes.
+func WithKeyID(kid string) Option {
+	return func(o *options) error {
+		o.keyID = kid
+		return nil
+	}
+}
+
+// WithKeyResolver makes a JSONWebSignatureSerializer verify tokens with the
+// key resolver finds from their header: a KeyMap, a JWKSet, a
+// KeyResolverFunc or one of your own.
+func WithKeyResolver(resolver KeyResolver) Option {
+	return func(o *options) error {
+		o.keyResolver = resolver
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client a RemoteJWKS fetches with.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) error {
+		o.httpClient = client
+		return nil
+	}
+}
+
+// WithJWKSMaxAge sets the time, in seconds, a RemoteJWKS caches a set served
+// without a Cache-Control max-age.
+func WithJWKSMaxAge(seconds int64) Option {
+	return func(o *options) error {
+		if seconds <= 0 {
+			return fmt.Errorf("JWKS max age must be positive, got %d", seconds)
+		}
+		o.jwksMaxAge = seconds
+		return nil
+	}
+}
+
+// WithJWKSRefreshInterval sets the time, in seconds, a RemoteJWKS waits at
+// least between two fetches.
+func WithJWKSRefreshInterval(seconds int64) Option {
+	return func(o *options) error {
+		if seconds <= 0 {
+			return fmt.Errorf("JWKS refresh interval must be positive, got %d", seconds)
+		}
+		o.jwksRefreshInterval = seconds
+		return nil
+	}
+}
+
+// WithUnencodedPayload makes a JSONWebSignatureSerializer sign payloads as
+// is, with the "b64": false header of RFC 7797.
+func WithUnencodedPayload() Option {
+	return func(o *options) error {
+		o.unencoded = true
+		return nil
+	}
+}
+
+// WithCriticalHeaders sets the header names, other than b64, that a
+// JSONWebSignatureSerializer accepts in the crit header of a token. The
+// application must check these headers itself.
+func WithCriticalHeaders(names ...string) Option {
+	return func(o *options) error {
+		for _, name := range names {
+			if registeredHeaders[name] || name == "" {
+				return fmt.Errorf("Header %q cannot be critical", name)
+			}
+		}
+		o.criticalHeaders = names
+		return nil
+	}
+}
@@ -0,0 +1,405 @@
+package dangerous
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Salts of the keys Rails derives from secret_key_base for its cookies.
+const (
+	RailsSignedCookieSalt                 = "signed cookie"
+	RailsAuthenticatedEncryptedCookieSalt = "authenticated encrypted cookie"
+)
+
+const railsGCMNonceSize = 12
+
+var railsSep = []byte("--")
+
+// RailsKeyGenerator derives keys from a secret_key_base with PBKDF2, as
+// ActiveSupport::KeyGenerator does. Rails.application.key_generator uses
+// 1000 iterations, with sha256 since the Rails 7.0 defaults and sha1 before.
+type RailsKeyGenerator struct {
+	SecretKeyBase string
+	Iterations    int              // 65536 if 0, as ActiveSupport
+	DigestMethod  func() hash.Hash // sha1 if nil
+}
+
+// GenerateKey returns the keySize byte key for salt, 64 bytes if keySize is
+// 0. MessageEncryptor with aes-256-gcm needs a 32 byte key.
+func (kg RailsKeyGenerator) GenerateKey(salt string, keySize int) []byte {
+	if kg.Iterations == 0 {
+		kg.Iterations = 1 << 16
+	}
+	if kg.DigestMethod == nil {
+		kg.DigestMethod = sha1.New
+	}
+	if keySize == 0 {
+		keySize = 64
+	}
+	return pbkdf2(kg.DigestMethod, WantBytes(kg.SecretKeyBase), WantBytes(salt), kg.Iterations, keySize)
+}
+
+// pbkdf2 is PBKDF2 of RFC 8018 with an HMAC pseudorandom function.
+func pbkdf2(digestMethod func() hash.Hash, password, salt []byte, iterations, keySize int) []byte {
+	prf := hmac.New(digestMethod, password)
+	var key []byte
+	for block := uint32(1); len(key) < keySize; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(appendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keySize]
+}
+
+// RailsMetadata is the purpose and expiry of a Rails message. Without them a
+// message is sent as it is, otherwise in a "_rails" envelope.
+type RailsMetadata struct {
+	Purpose   string
+	ExpiresAt time.Time
+	ExpiresIn int64 // seconds, used if ExpiresAt is zero
+}
+
+// railsEnvelope is the "_rails" metadata envelope of Rails 5.2 to 7.0.
+type railsEnvelope struct {
+	Rails *railsMetadata `json:"_rails"`
+}
+
+type railsMetadata struct {
+	Message string  `json:"message"`
+	Exp     *string `json:"exp"`
+	Pur     *string `json:"pur"`
+}
+
+// railsWrap puts message in an envelope if meta asks for one, as
+// ActiveSupport::Messages::Metadata.wrap.
+func railsWrap(message []byte, meta RailsMetadata, now time.Time) ([]byte, error) {
+	expiresAt := meta.ExpiresAt
+	if expiresAt.IsZero() && meta.ExpiresIn != 0 {
+		expiresAt = now.Add(time.Duration(meta.ExpiresIn) * time.Second)
+	}
+	if expiresAt.IsZero() && meta.Purpose == "" {
+		return message, nil
+	}
+	var envelope railsEnvelope
+	envelope.Rails = &railsMetadata{Message: base64.StdEncoding.EncodeToString(message)}
+	if !expiresAt.IsZero() {
+		exp := expiresAt.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+		envelope.Rails.Exp = &exp
+	}
+	if meta.Purpose != "" {
+		envelope.Rails.Pur = &meta.Purpose
+	}
+	return json.Marshal(envelope)
+}
+
+// railsUnwrap returns the message in data if its purpose matches and it has
+// not expired, as ActiveSupport::Messages::Metadata.verify. Data that is not
+// an envelope is a message without purpose or expiry.
+func railsUnwrap(data []byte, purpose string, now time.Time, leeway int64) ([]byte, error) {
+	var envelope railsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Rails == nil {
+		if purpose != "" {
+			return nil, newBadSignature(nil, nil, "Purpose does not match")
+		}
+		return data, nil
+	}
+	message, err := base64.StdEncoding.Strict().DecodeString(envelope.Rails.Message)
+	if err != nil {
+		return nil, newBadPayload(err, "Could not base64 decode the message")
+	}
+	if pur := envelope.Rails.Pur; (pur == nil && purpose != "") || (pur != nil && *pur != purpose) {
+		return nil, newBadSignature(nil, nil, "Purpose does not match")
+	}
+	if envelope.Rails.Exp != nil {
+		exp, err := time.Parse(time.RFC3339Nano, *envelope.Rails.Exp)
+		if err != nil {
+			return nil, newBadTimeSignature(nil, time.Time{}, err, "Malformed expiry")
+		}
+		if !now.Before(exp.Add(time.Duration(leeway) * time.Second)) {
+			return message, newSignatureExpired(nil, exp.UTC(), fmt.Sprintf("Message expired at %s", *envelope.Rails.Exp))
+		}
+	}
+	return message, nil
+}
+
+// railsLoad loads message with api, into dst if it is not nil.
+func railsLoad(message []byte, api JSONAPI, dst interface{}) (interface{}, error) {
+	if dst != nil {
+		return dst, LoadPayloadInto(message, api, dst)
+	}
+	return LoadPayload(message, api)
+}
+
+// RailsMessageVerifier signs and verifies messages as
+// ActiveSupport::MessageVerifier does: the strict base64 of the message,
+// "--" and the hex HMAC of that base64. Signed cookies use a verifier whose
+// Secret is the RailsSignedCookieSalt key of the application's key
+// generator, and the purpose "cookie.<name>" since Rails 6.0.
+//
+// Only JSON messages are supported, not Ruby's Marshal, so Rails must use
+// the :json cookies serializer. Values are URL-decoded in cookies.
+type RailsMessageVerifier struct {
+	Secret       string
+	DigestMethod func() hash.Hash // sha1 if nil
+	SerializerOP JSONAPI          // JSON if nil
+	Clock        Clock            // DefaultClock if nil
+	Leeway       int64            // seconds
+}
+
+// NewRailsMessageVerifier returns a RailsMessageVerifier. It accepts the
+// WithDigestMethod, WithSerializer, WithClock and WithLeeway options.
+func NewRailsMessageVerifier(secret string, opts ...Option) (RailsMessageVerifier, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return RailsMessageVerifier{}, err
+	}
+	rv := RailsMessageVerifier{
+		Secret:       secret,
+		DigestMethod: o.digestMethod,
+		SerializerOP: o.serializer,
+		Clock:        o.clock,
+		Leeway:       o.leeway,
+	}
+	if err := rv.init(); err != nil {
+		return RailsMessageVerifier{}, err
+	}
+	return rv, nil
+}
+
+func (rv *RailsMessageVerifier) init() error {
+	if rv.Secret == "" {
+		return fmt.Errorf("RailsMessageVerifier secret is empty")
+	}
+	rv.SetDefault()
+	return nil
+}
+
+// SetDefault fills in the defaults of a verifier built as a struct literal.
+func (rv *RailsMessageVerifier) SetDefault() {
+	if rv.DigestMethod == nil {
+		rv.DigestMethod = sha1.New
+	}
+	if rv.SerializerOP == nil {
+		rv.SerializerOP = JSON{}
+	}
+}
+
+func (rv RailsMessageVerifier) digest(data []byte) []byte {
+	mac := hmac.New(rv.DigestMethod, WantBytes(rv.Secret))
+	mac.Write(data)
+	return WantBytes(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Generate returns value signed, as MessageVerifier#generate.
+func (rv RailsMessageVerifier) Generate(value interface{}, meta RailsMetadata) ([]byte, error) {
+	if err := (&rv).init(); err != nil {
+		return BlankBytes, err
+	}
+	message, err := DumpPayload(value, rv.SerializerOP)
+	if err != nil {
+		return BlankBytes, err
+	}
+	wrapped, err := railsWrap(WantBytes(message), meta, clockOrDefault(rv.Clock).Now())
+	if err != nil {
+		return BlankBytes, err
+	}
+	data := WantBytes(base64.StdEncoding.EncodeToString(wrapped))
+	signed, _ := Concentrate(data, railsSep, rv.digest(data))
+	return signed, nil
+}
+
+// Verify returns the value of a signed message whose purpose is purpose, as
+// MessageVerifier#verify. Like Serializer.TimedLoads, the value is also
+// returned with a SignatureExpired.
+func (rv RailsMessageVerifier) Verify(signed string, purpose string) (interface{}, error) {
+	return rv.verify(signed, purpose, nil)
+}
+
+// VerifyInto is Verify that decodes the value into dst.
+func (rv RailsMessageVerifier) VerifyInto(signed string, purpose string, dst interface{}) error {
+	_, err := rv.verify(signed, purpose, dst)
+	return err
+}
+
+func (rv RailsMessageVerifier) verify(signed string, purpose string, dst interface{}) (interface{}, error) {
+	if err := (&rv).init(); err != nil {
+		return nil, err
+	}
+	signedvalue := WantBytes(signed)
+	index := bytes.LastIndex(signedvalue, railsSep)
+	if index <= 0 || index+len(railsSep) == len(signedvalue) {
+		return nil, newBadSignature(nil, nil, fmt.Sprintf("No %q found in value", railsSep))
+	}
+	data, digest := signedvalue[:index], signedvalue[index+len(railsSep):]
+	if !hmac.Equal(digest, rv.digest(data)) {
+		return nil, newBadSignature(nil, nil, fmt.Sprintf("Signature %q does not match", digest))
+	}
+	wrapped, err := base64.StdEncoding.Strict().DecodeString(string(data))
+	if err != nil {
+		return nil, newBadPayload(err, "Could not base64 decode the message")
+	}
+	return railsOpen(wrapped, purpose, rv.SerializerOP, rv.Clock, rv.Leeway, dst)
+}
+
+// railsOpen unwraps and loads a verified or decrypted message.
+func railsOpen(wrapped []byte, purpose string, api JSONAPI, clock Clock, leeway int64, dst interface{}) (interface{}, error) {
+	message, err := railsUnwrap(wrapped, purpose, clockOrDefault(clock).Now(), leeway)
+	if message == nil {
+		return nil, err
+	}
+	value, errload := railsLoad(message, api, dst)
+	if err == nil {
+		err = errload
+	}
+	return value, err
+}
+
+// RailsMessageEncryptor encrypts messages as ActiveSupport::MessageEncryptor
+// does with the aes-256-gcm cipher: the strict base64 of the ciphertext, of
+// the 12 byte IV and of the 16 byte authentication tag, joined by "--".
+// Encrypted cookies use an encryptor whose Secret is the 32 byte
+// RailsAuthenticatedEncryptedCookieSalt key of the application's key
+// generator.
+//
+// As with RailsMessageVerifier, only JSON messages are supported.
+type RailsMessageEncryptor struct {
+	Secret       string  // 32 bytes
+	SerializerOP JSONAPI // JSON if nil
+	Clock        Clock   // DefaultClock if nil
+	Leeway       int64   // seconds
+}
+
+// NewRailsMessageEncryptor returns a RailsMessageEncryptor. It accepts the
+// WithSerializer, WithClock and WithLeeway options.
+func NewRailsMessageEncryptor(secret string, opts ...Option) (RailsMessageEncryptor, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return RailsMessageEncryptor{}, err
+	}
+	re := RailsMessageEncryptor{
+		Secret:       secret,
+		SerializerOP: o.serializer,
+		Clock:        o.clock,
+		Leeway:       o.leeway,
+	}
+	if _, err := re.gcm(); err != nil {
+		return RailsMessageEncryptor{}, err
+	}
+	re.SetDefault()
+	return re, nil
+}
+
+// SetDefault fills in the defaults of an encryptor built as a struct literal.
+func (re *RailsMessageEncryptor) SetDefault() {
+	if re.SerializerOP == nil {
+		re.SerializerOP = JSON{}
+	}
+}
+
+// gcm returns the aes-256-gcm cipher of Secret, refusing a secret of another
+// length, which aes.NewCipher would take for AES-128 or AES-192.
+func (re RailsMessageEncryptor) gcm() (cipher.AEAD, error) {
+	if len(re.Secret) != 32 {
+		return nil, fmt.Errorf("aes-256-gcm needs a 32 byte secret, got %d", len(re.Secret))
+	}
+	block, err := aes.NewCipher(WantBytes(re.Secret))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptAndSign returns value encrypted, as MessageEncryptor#encrypt_and_sign.
+func (re RailsMessageEncryptor) EncryptAndSign(value interface{}, meta RailsMetadata) ([]byte, error) {
+	iv := make([]byte, railsGCMNonceSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return BlankBytes, err
+	}
+	return re.encrypt(value, meta, iv)
+}
+
+func (re RailsMessageEncryptor) encrypt(value interface{}, meta RailsMetadata, iv []byte) ([]byte, error) {
+	(&re).SetDefault()
+	aead, err := re.gcm()
+	if err != nil {
+		return BlankBytes, err
+	}
+	message, err := DumpPayload(value, re.SerializerOP)
+	if err != nil {
+		return BlankBytes, err
+	}
+	wrapped, err := railsWrap(WantBytes(message), meta, clockOrDefault(re.Clock).Now())
+	if err != nil {
+		return BlankBytes, err
+	}
+	sealed := aead.Seal(nil, iv, wrapped, nil)
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+	encoded := bytes.Join([][]byte{
+		WantBytes(base64.StdEncoding.EncodeToString(ciphertext)),
+		WantBytes(base64.StdEncoding.EncodeToString(iv)),
+		WantBytes(base64.StdEncoding.EncodeToString(tag)),
+	}, railsSep)
+	return encoded, nil
+}
+
+// DecryptAndVerify returns the value of an encrypted message whose purpose is
+// purpose, as MessageEncryptor#decrypt_and_verify. Like
+// Serializer.TimedLoads, the value is also returned with a SignatureExpired.
+func (re RailsMessageEncryptor) DecryptAndVerify(encrypted string, purpose string) (interface{}, error) {
+	return re.decrypt(encrypted, purpose, nil)
+}
+
+// DecryptAndVerifyInto is DecryptAndVerify that decodes the value into dst.
+func (re RailsMessageEncryptor) DecryptAndVerifyInto(encrypted string, purpose string, dst interface{}) error {
+	_, err := re.decrypt(encrypted, purpose, dst)
+	return err
+}
+
+func (re RailsMessageEncryptor) decrypt(encrypted string, purpose string, dst interface{}) (interface{}, error) {
+	(&re).SetDefault()
+	aead, err := re.gcm()
+	if err != nil {
+		return nil, err
+	}
+	parts := bytes.Split(WantBytes(encrypted), railsSep)
+	if len(parts) != 3 {
+		return nil, newBadSignature(nil, nil, "Message is not ciphertext--iv--tag")
+	}
+	var decoded [3][]byte
+	for p, part := range parts {
+		if decoded[p], err = base64.StdEncoding.Strict().DecodeString(string(part)); err != nil {
+			return nil, newBadSignature(nil, err, "Could not base64 decode the message")
+		}
+	}
+	ciphertext, iv, tag := decoded[0], decoded[1], decoded[2]
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return nil, newBadSignature(nil, nil, "Invalid IV or authentication tag")
+	}
+	wrapped, err := aead.Open(nil, iv, append(ciphertext, tag...), nil)
+	if err != nil {
+		return nil, newBadSignature(nil, err, "Message could not be decrypted")
+	}
+	return railsOpen(wrapped, purpose, re.SerializerOP, re.Clock, re.Leeway, dst)
+}
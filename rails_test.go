@@ -0,0 +1,173 @@
+package dangerous
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+func TestRailsKeyGenerator(t *testing.T) {
+	for _, v := range []struct {
+		kg      RailsKeyGenerator
+		salt    string
+		size    int
+		derived string
+	}{
+		{RailsKeyGenerator{SecretKeyBase: "secret_key_base", Iterations: 1000}, RailsSignedCookieSalt, 0,
+			"fd738d6346ab414ad2f79c8fac11846ac5ca02374052f3feaa356958e2bd4536fc71cad079a7b9ab02066b19f7ab21720c7c0c2d549241c724242c05dd7a6d68"},
+		{RailsKeyGenerator{SecretKeyBase: "secret_key_base", Iterations: 1000}, RailsAuthenticatedEncryptedCookieSalt, 32,
+			"f131f32634d09ded0399c579217f0bc2763437e2c69d45c8900bd9d3568af199"},
+		{RailsKeyGenerator{SecretKeyBase: "secret_key_base", DigestMethod: sha256.New}, RailsAuthenticatedEncryptedCookieSalt, 32,
+			"63781a5386c4f9c7431d48fd635b37b087322730c2b8b2aca6e22dd4c58ea7db"},
+	} {
+		if key := v.kg.GenerateKey(v.salt, v.size); hex.EncodeToString(key) != v.derived {
+			t.Fatalf("Got %x, expected %s", key, v.derived)
+		}
+	}
+}
+
+func TestRailsMessageVerifier(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1600000000-60, 0))
+	for _, v := range []struct {
+		opts    []Option
+		value   interface{}
+		meta    RailsMetadata
+		message string
+	}{
+		{nil, map[string]interface{}{"user_id": 1.0}, RailsMetadata{},
+			"eyJ1c2VyX2lkIjoxfQ==--ed19ed99d73906f553751edd3c5fda0f6c095a93"},
+		{[]Option{WithDigestMethod(sha256.New)}, "<hi> & é", RailsMetadata{Purpose: "login", ExpiresIn: 60},
+			"eyJfcmFpbHMiOnsibWVzc2FnZSI6IklseDFNREF6WTJocFhIVXdNRE5sSUZ4MU1EQXlOaUREcVNJPSIsImV4cCI6IjIwMjAtMDktMTNUMTI6MjY6NDAuMDAwWiIsInB1ciI6ImxvZ2luIn19--44d57ca3ff4f8dc3a29bfda8cb5e9bf8fff7a7d7954068f6cabeab827d2bca67"},
+	} {
+		rv, err := NewRailsMessageVerifier("secret", append(v.opts, WithClock(clock))...)
+		if err != nil {
+			t.Fatalf("Unexpected error:%s", err)
+		}
+		message, err := rv.Generate(v.value, v.meta)
+		if err != nil || string(message) != v.message {
+			t.Fatalf("Got %s, expected %s. Error:%v", message, v.message, err)
+		}
+		value, err := rv.Verify(v.message, v.meta.Purpose)
+		if err != nil || value == nil {
+			t.Fatalf("Verifying failed. Error:%v", err)
+		}
+		if _, err := rv.Verify(v.message, "other"); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("Another purpose should be refused, got %v", err)
+		}
+		if _, err := rv.Verify(v.message[:len(v.message)-1]+"0", v.meta.Purpose); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("A wrong digest should be refused, got %v", err)
+		}
+	}
+
+	rv, _ := NewRailsMessageVerifier("secret", WithDigestMethod(sha256.New), WithClock(clock))
+	message, _ := rv.Generate("value", RailsMetadata{ExpiresAt: time.Unix(1600000000, 0)})
+	clock.Advance(60 * time.Second)
+	value, err := rv.Verify(string(message), "")
+	var expired *SignatureExpired
+	if !errors.As(err, &expired) || value != "value" || expired.DateSigned.Unix() != 1600000000 {
+		t.Fatalf("Expected SignatureExpired with the value, got %v", err)
+	}
+	for _, message := range []string{"", "--", "abc--", "--abc", "abc"} {
+		if _, err := rv.Verify(message, ""); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%q: expected BadSignature, got %v", message, err)
+		}
+	}
+	if _, err := NewRailsMessageVerifier(""); err == nil {
+		t.Fatalf("An empty secret should be refused.")
+	}
+	empty := RailsMessageVerifier{}
+	empty.SetDefault()
+	if _, err := empty.Generate("value", RailsMetadata{}); err == nil {
+		t.Fatalf("Generate should refuse an empty secret.")
+	}
+	if _, err := empty.Verify(string(message), ""); err == nil || errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify should refuse an empty secret, got %v", err)
+	}
+}
+
+func TestRailsSignedCookie(t *testing.T) {
+	// cookies.signed[:user_id] = 42 with the :json serializer.
+	kg := RailsKeyGenerator{SecretKeyBase: "secret_key_base", Iterations: 1000}
+	rv, _ := NewRailsMessageVerifier(string(kg.GenerateKey(RailsSignedCookieSalt, 0)))
+	cookie := "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOm51bGwsInB1ciI6ImNvb2tpZS51c2VyX2lkIn19--af2ed90a87c16e0c69116d642c11c6475b1fd731"
+	var userID int
+	if err := rv.VerifyInto(cookie, "cookie.user_id", &userID); err != nil || userID != 42 {
+		t.Fatalf("Got %d. Error:%v", userID, err)
+	}
+	if generated, _ := rv.Generate(42, RailsMetadata{Purpose: "cookie.user_id"}); string(generated) != cookie {
+		t.Fatalf("Got %s, expected %s", generated, cookie)
+	}
+	if _, err := rv.Verify(cookie, ""); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("A cookie should need its purpose, got %v", err)
+	}
+}
+
+func TestRailsMessageEncryptor(t *testing.T) {
+	kg := RailsKeyGenerator{SecretKeyBase: "secret_key_base", Iterations: 1000}
+	key := string(kg.GenerateKey(RailsAuthenticatedEncryptedCookieSalt, 32))
+	clock := dangeroustest.NewFakeClock(time.Unix(1600000000-60, 0))
+	re, err := NewRailsMessageEncryptor(key, WithClock(clock))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	iv := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	for _, v := range []struct {
+		value     interface{}
+		meta      RailsMetadata
+		encrypted string
+	}{
+		{map[string]int{"user_id": 1}, RailsMetadata{}, "SRO/816ilU+YELcJKw==--AAECAwQFBgcICQoL--E9grj+aQ4Q4sOJt1LQ5STw=="},
+		{"hello", RailsMetadata{Purpose: "cookie.remember_token", ExpiresIn: 60},
+			"SROV8lq5plXeCPYaO1A48VFJijWZIU6WocANUOIG9YGHsy8YT6vleUTSy66snt31lX2cUHrhO/h0kKbNLrBKxPtNs6g9eTnCRPhR0MV6Gd+hGnP398dX7DeoMD+vx6agfdFEUg==--AAECAwQFBgcICQoL--x1k6CEIe+ajA3yGK/5UfbQ=="},
+	} {
+		encrypted, err := re.encrypt(v.value, v.meta, iv)
+		if err != nil || string(encrypted) != v.encrypted {
+			t.Fatalf("Got %s, expected %s. Error:%v", encrypted, v.encrypted, err)
+		}
+		if _, err := re.DecryptAndVerify(v.encrypted, v.meta.Purpose); err != nil {
+			t.Fatalf("Decrypting failed. Error:%s", err)
+		}
+	}
+
+	var token string
+	if err := re.DecryptAndVerifyInto("SROV8lq5plXeCPYaO1A48VFJijWZIU6WocANUOIG9YGHsy8YT6vleUTSy66snt31lX2cUHrhO/h0kKbNLrBKxPtNs6g9eTnCRPhR0MV6Gd+hGnP398dX7DeoMD+vx6agfdFEUg==--AAECAwQFBgcICQoL--x1k6CEIe+ajA3yGK/5UfbQ==",
+		"cookie.remember_token", &token); err != nil || token != "hello" {
+		t.Fatalf("Got %q. Error:%v", token, err)
+	}
+	encrypted, _ := re.EncryptAndSign("value", RailsMetadata{ExpiresIn: 10})
+	again, _ := re.EncryptAndSign("value", RailsMetadata{ExpiresIn: 10})
+	if string(encrypted) == string(again) {
+		t.Fatalf("IVs should not repeat.")
+	}
+	clock.Advance(10 * time.Second)
+	if _, err := re.DecryptAndVerify(string(encrypted), ""); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("Expected SignatureExpired, got %v", err)
+	}
+	for _, encrypted := range []string{
+		"SRO/816ilU+YELcJKw==--AAECAwQFBgcICQoL--E9grj+aQ4Q4sOJt1LQ5STx==",
+		"SRO/816ilU+YELcJKw==--AAECAwQFBgcICQoL--E9grj+aQ4Q4sOJt1LQ5STw",
+		"SRO/816ilU+YELcJKw==--AAECAwQFBgcICQoL",
+		"SRO/816ilU+YELcJKw==--AAECAwQFBgcICQ==--E9grj+aQ4Q4sOJt1LQ5STw==",
+		"TRO/816ilU+YELcJKw==--AAECAwQFBgcICQoL--E9grj+aQ4Q4sOJt1LQ5STw==",
+	} {
+		if _, err := re.DecryptAndVerify(encrypted, ""); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: expected BadSignature, got %v", encrypted, err)
+		}
+	}
+	if _, err := NewRailsMessageEncryptor("short"); err == nil {
+		t.Fatalf("A short secret should be refused.")
+	}
+	for _, size := range []int{16, 24} {
+		literal := RailsMessageEncryptor{Secret: key[:size]}
+		if _, err := literal.EncryptAndSign("value", RailsMetadata{}); err == nil {
+			t.Fatalf("A %d byte secret should not encrypt.", size)
+		}
+		if _, err := literal.DecryptAndVerify(string(encrypted), ""); err == nil || errors.Is(err, ErrBadSignature) {
+			t.Fatalf("A %d byte secret should not decrypt, got %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package dangerous
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]func() Signature{}
+
+	// JwsAlgorithms holds an instance of each built-in algorithm. It is
+	// filled once when the package is initialized and never written again,
+	// so algorithms added with RegisterAlgorithm are not in it.
+	//
+	// Deprecated: use LookupAlgorithm and Algorithms, which see every
+	// registered algorithm, and RegisterAlgorithm. The package does not read
+	// this map, so changing it has no effect.
+	JwsAlgorithms = map[string]interface{}{}
+)
+
+func init() {
+	for name, alg := range map[string]Signature{
+		"HS256": HMACAlgorithm{DigestMethod: sha256.New},
+		"HS384": HMACAlgorithm{DigestMethod: sha512.New384},
+		"HS512": HMACAlgorithm{DigestMethod: sha512.New},
+		"RS256": RSAPKCS1v15Algorithm{Hash: crypto.SHA256},
+		"RS384": RSAPKCS1v15Algorithm{Hash: crypto.SHA384},
+		"RS512": RSAPKCS1v15Algorithm{Hash: crypto.SHA512},
+		"ES256": ECDSAAlgorithm{Hash: crypto.SHA256, Curve: elliptic.P256()},
+		"ES384": ECDSAAlgorithm{Hash: crypto.SHA384, Curve: elliptic.P384()},
+		"ES512": ECDSAAlgorithm{Hash: crypto.SHA512, Curve: elliptic.P521()},
+		"PS256": RSAPSSAlgorithm{Hash: crypto.SHA256},
+		"PS384": RSAPSSAlgorithm{Hash: crypto.SHA384},
+		"PS512": RSAPSSAlgorithm{Hash: crypto.SHA512},
+		"EdDSA": Ed25519Algorithm{},
+		"none":  SigningAlgorithm{},
+	} {
+		alg := alg
+		RegisterAlgorithm(name, func() Signature { return alg })
+		JwsAlgorithms[name] = alg
+	}
+}
+
+// RegisterAlgorithm makes an algorithm available under name to
+// JSONWebSignatureSerializer and to the WithAlgorithmName option. It is safe
+// to call concurrently, but a name can only be registered once.
+func RegisterAlgorithm(name string, factory func() Signature) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("algorithm name and factory are required")
+	}
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	if _, ok := algorithms[name]; ok {
+		return fmt.Errorf("algorithm %q is already registered", name)
+	}
+	algorithms[name] = factory
+	return nil
+}
+
+// LookupAlgorithm returns a new instance of the algorithm registered under
+// name.
+func LookupAlgorithm(name string) (Signature, bool) {
+	algorithmsMu.RLock()
+	factory, ok := algorithms[name]
+	algorithmsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// Algorithms returns the sorted names of the registered algorithms.
+func Algorithms() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
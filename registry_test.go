@@ -0,0 +1,68 @@
+package dangerous
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLookupAlgorithm(t *testing.T) {
+	for _, name := range []string{"HS256", "HS384", "HS512", "RS256", "ES256", "PS256", "EdDSA", "none"} {
+		if _, ok := LookupAlgorithm(name); !ok {
+			t.Fatalf("Algorithm %s is not registered.", name)
+		}
+	}
+	if _, ok := LookupAlgorithm("not exist"); ok {
+		t.Fatalf("Unexpected algorithm.")
+	}
+	if _, ok := JwsAlgorithms["HS256"].(HMACAlgorithm); !ok || JwsAlgorithms["none"] == nil {
+		t.Fatalf("JwsAlgorithms should hold the built-in algorithms, got %v", JwsAlgorithms)
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	if err := RegisterAlgorithm("HS256", func() Signature { return SigningAlgorithm{} }); err == nil {
+		t.Fatalf("Registering a name twice should fail.")
+	}
+	if err := RegisterAlgorithm("", func() Signature { return SigningAlgorithm{} }); err == nil {
+		t.Fatalf("Registering an empty name should fail.")
+	}
+	if err := RegisterAlgorithm("X-REVERSE", nil); err == nil {
+		t.Fatalf("Registering a nil factory should fail.")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			LookupAlgorithm("HS256")
+			Algorithms()
+		}()
+	}
+	if err := RegisterAlgorithm("X-REVERSE", func() Signature { return _ReverseAlgorithm{} }); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	wg.Wait()
+	if _, ok := JwsAlgorithms["X-REVERSE"]; ok {
+		t.Fatalf("JwsAlgorithms should not be written after init.")
+	}
+
+	_jws, err := NewJWS("secret-key", WithAlgorithmName("X-REVERSE"))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	signed, _ := _jws.Dumps("value")
+	if _, payload, err := _jws.Loads(string(signed)); err != nil || payload.(string) != "value" {
+		t.Fatalf("Registered algorithm is not available. Error:%s", err)
+	}
+	_signer, err := NewSigner("secret-key", WithAlgorithmName("X-REVERSE"))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if _, ok := _signer.Algorithm.(_ReverseAlgorithm); !ok {
+		t.Fatalf("NewSigner did not use the registered algorithm.")
+	}
+	if _, err := NewSigner("secret-key", WithAlgorithmName("not exist")); err == nil {
+		t.Fatalf("NewSigner should reject an unregistered algorithm.")
+	}
+}
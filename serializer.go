@@ -3,8 +3,8 @@ package dangerous
 import (
 	"bytes"
 	"crypto/sha512"
+	"errors"
 	"fmt"
-	"strings"
 )
 
 var (
@@ -13,28 +13,101 @@ var (
 )
 
 type Serializer struct {
-	Secret          string
-	Salt            string
-	SerializerOP    JSONAPI // Can override it becomes easier
-	Signer          Signer
-	Signerkwargs    map[string]interface{}
-	FallbackSigners []map[string]interface{}
+	Secret              string
+	SecretKeys          []string // oldest to newest, see Signer
+	Salt                string
+	SerializerOP        JSONAPI // Can override it becomes easier
+	Codec               Codec   // replaces SerializerOP if set
+	Signer              Signer
+	Signerkwargs        map[string]interface{}
+	FallbackSigners     []map[string]interface{}
+	Clock               Clock // passed on to Signer if it has none
+	Leeway              int64 // seconds, passed on to Signer if it has none
+	MaxTokenLength      int   // DefaultMaxTokenLength if 0, no limit if negative
+	MaxDecompressedSize int64 // bytes, DefaultMaxDecompressedSize if 0, no limit if negative
+	unsigners           []interface{}
+	initialized         bool
+}
+
+// NewSerializer returns a validated Serializer with its signers built once.
+// Like NewSigner, the result can be shared between goroutines as long as its
+// fields are not changed.
+func NewSerializer(secret string, opts ...Option) (Serializer, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return Serializer{}, err
+	}
+	if _, ok := o.algorithm.(PublicKeySignature); !ok && secret == "" && len(o.secretKeys) == 0 {
+		return Serializer{}, fmt.Errorf("Serializer secret is empty")
+	}
+	ser := Serializer{
+		Secret:          secret,
+		SecretKeys:      o.secretKeys,
+		Salt:            o.salt,
+		SerializerOP:    o.serializer,
+		Codec:           o.codec,
+		FallbackSigners: o.fallbackSigners,
+		Clock:           o.clock,
+		Leeway:          o.leeway,
+
+		MaxTokenLength:      o.maxTokenLength,
+		MaxDecompressedSize: o.maxDecompressedSize,
+	}
+	if ser.Salt == "" {
+		ser.Salt = "itsdangerous"
+	}
+	ser.Signer = o.signer(secret, ser.Salt)
+	if err := ser.init(); err != nil {
+		return Serializer{}, err
+	}
+	return ser, nil
+}
+
+func (ser *Serializer) init() error {
+	ser.SetDefault()
+	unsigners := ser.IterUnSigners()
+	for p, unsigner := range unsigners {
+		signer := unsigner.(Signer)
+		if err := signer.init(); err != nil {
+			return err
+		}
+		unsigners[p] = signer
+	}
+	ser.Signer = unsigners[0].(Signer)
+	ser.unsigners = unsigners
+	ser.initialized = true
+	return nil
 }
 
 func (ser *Serializer) SetDefault() {
-	if ser.Secret == "" {
+	if ser.initialized {
+		return
+	}
+	if len(ser.SecretKeys) > 0 {
+		ser.Secret = ser.SecretKeys[len(ser.SecretKeys)-1]
+	}
+	if ser.Secret == "" && !ser.Signer.isPublicKey() {
 		panic("Secret is necessary")
 	}
 	if ser.Salt == "" {
 		ser.Salt = "itsdangerous"
 	}
+	if ser.Codec != nil {
+		ser.SerializerOP = codecJSONAPI(ser.Codec)
+	}
 	if ser.SerializerOP == nil {
 		ser.SerializerOP = JSON{}
 	}
-	if ser.Signer.Secret == "" {
-		ser.Signer = Signer{Secret: ser.Secret, Salt: ser.Salt}
+	if ser.Signer.Secret == "" && len(ser.Signer.SecretKeys) == 0 && !ser.Signer.isPublicKey() {
+		ser.Signer = Signer{Secret: ser.Secret, SecretKeys: ser.SecretKeys, Salt: ser.Salt}
 	}
 	ApplyKwargs(&ser.Signer, ser.Signerkwargs)
+	if ser.Signer.Clock == nil {
+		ser.Signer.Clock = ser.Clock
+	}
+	if ser.Signer.Leeway == 0 {
+		ser.Signer.Leeway = ser.Leeway
+	}
 	if len(ser.FallbackSigners) == 0 {
 		ser.FallbackSigners = DefaultFallbackSigners
 	}
@@ -42,6 +115,9 @@ func (ser *Serializer) SetDefault() {
 }
 
 func (ser Serializer) IterUnSigners() []interface{} {
+	if ser.initialized {
+		return append([]interface{}(nil), ser.unsigners...)
+	}
 	allfallback := make([]interface{}, len(ser.FallbackSigners)+1)
 	allfallback[0] = ser.Signer
 	for p, kw := range ser.FallbackSigners {
@@ -55,12 +131,17 @@ func (ser Serializer) IterUnSigners() []interface{} {
 func (ser Serializer) PreDumps(objx interface{}, dumpfunc func(interface{}, interface{}) (string, error)) ([]byte, error) {
 	(&ser).SetDefault()
 	PayloadDump, err := dumpfunc(objx, ser.SerializerOP)
-	rv := ser.Signer.Sign(PayloadDump)
-	return rv, err
+	if err != nil {
+		return BlankBytes, err
+	}
+	return ser.Signer.SignE(PayloadDump)
 }
 
 func (ser Serializer) PreLoads(s string, loadfunc func([]byte, interface{}) (interface{}, error)) (interface{}, error) {
 	(&ser).SetDefault()
+	if err := ser.checkLength(s); err != nil {
+		return nil, err
+	}
 	var _err error
 	var _result interface{}
 	for _, signer := range ser.IterUnSigners() {
@@ -80,26 +161,31 @@ func (ser Serializer) PreLoads(s string, loadfunc func([]byte, interface{}) (int
 func (ser Serializer) PreTimedDumps(objx interface{}, dumpfunc func(interface{}, interface{}) (string, error)) ([]byte, error) {
 	(&ser).SetDefault()
 	PayloadDump, err := dumpfunc(objx, ser.SerializerOP)
-	rv := ser.Signer.SignTimestamp(PayloadDump)
-	return rv, err
+	if err != nil {
+		return BlankBytes, err
+	}
+	return ser.Signer.SignTimestampE(PayloadDump)
 }
 
 // totally different from function `PreLoads`
 
 func (ser Serializer) PreTimedLoads(s string, MaxAge int64, loadfunc func([]byte, interface{}) (interface{}, error)) (interface{}, error) {
 	(&ser).SetDefault()
+	if err := ser.checkLength(s); err != nil {
+		return nil, err
+	}
 	var _payload interface{}
 	var _err error
 	for _, signer := range ser.IterUnSigners() {
 		base64d, _, err := signer.(Signer).UnSignTimestamp(s, MaxAge)
 		_err = err
-		if err != nil && !strings.Contains(err.Error(), "BadTimeSignature") && !strings.Contains(err.Error(), "SignatureExpired") {
+		if err != nil && !errors.Is(err, ErrBadTimeSignature) {
 			continue
 		}
 		payload, errload := loadfunc(base64d, ser.SerializerOP)
 		_payload = payload
-		if errload != nil {
-			_err = fmt.Errorf("%s AND %s", err.Error(), errload.Error())
+		if err == nil {
+			_err = errload
 		}
 		break
 	}
@@ -123,12 +209,26 @@ func (ser Serializer) TimedLoads(s string, MaxAge int64) (interface{}, error) {
 	return ser.PreTimedLoads(s, MaxAge, LoadPayload)
 }
 
+// LoadsInto is Loads that decodes the payload into dst, which must be a
+// pointer. The serializer must be a JSONIntoAPI.
+func (ser Serializer) LoadsInto(s string, dst interface{}) error {
+	_, err := ser.PreLoads(s, loadPayloadInto(dst, LoadPayloadInto))
+	return err
+}
+
+// TimedLoadsInto is TimedLoads that decodes the payload into dst. Like
+// TimedLoads, dst is also filled in if the signature expired.
+func (ser Serializer) TimedLoadsInto(s string, MaxAge int64, dst interface{}) error {
+	_, err := ser.PreTimedLoads(s, MaxAge, loadPayloadInto(dst, LoadPayloadInto))
+	return err
+}
+
 func (ser Serializer) URLSafeDumps(objx interface{}) ([]byte, error) {
 	return ser.PreDumps(objx, URLSafeDumpPayload)
 }
 
 func (ser Serializer) URLSafeLoads(s string) (interface{}, error) {
-	return ser.PreLoads(s, URLSafeLoadPayload)
+	return ser.PreLoads(s, ser.urlSafeLoadPayload(LoadPayload))
 }
 
 func (ser Serializer) URLSafeTimedDumps(objx interface{}) ([]byte, error) {
@@ -136,7 +236,39 @@ func (ser Serializer) URLSafeTimedDumps(objx interface{}) ([]byte, error) {
 }
 
 func (ser Serializer) URLSafeTimedLoads(s string, MaxAge int64) (interface{}, error) {
-	return ser.PreTimedLoads(s, MaxAge, URLSafeLoadPayload)
+	return ser.PreTimedLoads(s, MaxAge, ser.urlSafeLoadPayload(LoadPayload))
+}
+
+func (ser Serializer) URLSafeLoadsInto(s string, dst interface{}) error {
+	_, err := ser.PreLoads(s, ser.urlSafeLoadPayload(loadPayloadInto(dst, LoadPayloadInto)))
+	return err
+}
+
+func (ser Serializer) URLSafeTimedLoadsInto(s string, MaxAge int64, dst interface{}) error {
+	_, err := ser.PreTimedLoads(s, MaxAge, ser.urlSafeLoadPayload(loadPayloadInto(dst, LoadPayloadInto)))
+	return err
+}
+
+// checkLength refuses a token longer than MaxTokenLength.
+func (ser Serializer) checkLength(s string) error {
+	max := limit(int64(ser.MaxTokenLength), int64(DefaultMaxTokenLength))
+	if max >= 0 && int64(len(s)) > max {
+		return newBadPayload(ErrPayloadTooLarge, fmt.Sprintf("Token is longer than %d bytes", max))
+	}
+	return nil
+}
+
+// urlSafeLoadPayload returns the loadfunc of PreLoads and PreTimedLoads that
+// decodes a URL-safe payload, inflating it to at most MaxDecompressedSize
+// bytes, and loads it with load.
+func (ser Serializer) urlSafeLoadPayload(load func([]byte, interface{}) (interface{}, error)) func([]byte, interface{}) (interface{}, error) {
+	return func(payload []byte, api interface{}) (interface{}, error) {
+		data, err := preURLSafeLoadPayload(payload, limit(ser.MaxDecompressedSize, DefaultMaxDecompressedSize))
+		if err != nil {
+			return data, err
+		}
+		return load(data, api)
+	}
 }
 
 /*-------------------------------------------------------------------------------*/
@@ -146,19 +278,42 @@ func (ser Serializer) URLSafeTimedLoads(s string, MaxAge int64) (interface{}, er
 func LoadPayload(payload []byte, api interface{}) (interface{}, error) {
 	data, err := api.(JSONAPI).Load(payload)
 	if err != nil {
-		err = fmt.Errorf("BadPayload-Could not load the payload because an exception"+
-			" occurred on unserializing the data. origin error=`%s`", err)
+		err = newBadPayload(err, "Could not load the payload because an exception"+
+			" occurred on unserializing the data.")
 	}
 	return data, err
 }
 
+// LoadPayloadInto is LoadPayload that decodes into dst.
+func LoadPayloadInto(payload []byte, api interface{}, dst interface{}) error {
+	if err := loadInto(api.(JSONAPI), payload, dst); err != nil {
+		return newBadPayload(err, "Could not load the payload because an exception"+
+			" occurred on unserializing the data.")
+	}
+	return nil
+}
+
+// loadPayloadInto adapts a LoadPayloadInto function to the loadfunc of
+// PreLoads and PreTimedLoads.
+func loadPayloadInto(dst interface{}, load func([]byte, interface{}, interface{}) error) func([]byte, interface{}) (interface{}, error) {
+	return func(payload []byte, api interface{}) (interface{}, error) {
+		return dst, load(payload, api, dst)
+	}
+}
+
 func DumpPayload(vx interface{}, api interface{}) (string, error) {
 	return api.(JSONAPI).Dump(vx)
 }
 
 // URLSafe
 
+// PreURLSafeLoadPayload decodes a URL-safe payload, inflating it to at most
+// DefaultMaxDecompressedSize bytes.
 func PreURLSafeLoadPayload(payload []byte) ([]byte, error) {
+	return preURLSafeLoadPayload(payload, DefaultMaxDecompressedSize)
+}
+
+func preURLSafeLoadPayload(payload []byte, maxSize int64) ([]byte, error) {
 	decompress := false
 	if bytes.HasPrefix(payload, Sep) {
 		payload = payload[1:]
@@ -166,12 +321,19 @@ func PreURLSafeLoadPayload(payload []byte) ([]byte, error) {
 	}
 	JSONPayload, err := B64decode(payload)
 	if err != nil {
-		return JSONPayload, fmt.Errorf("Could not base64 decode the payload because of an exception, original_error=%s", err)
+		return JSONPayload, newBadPayload(err, "Could not base64 decode the payload because of an exception")
 	}
 	if decompress {
-		JSONPayload, err = UnCompress(JSONPayload)
+		JSONPayload, err = UnCompressLimit(JSONPayload, maxSize)
+		var bad *BadPayload
+		if errors.As(err, &bad) {
+			return JSONPayload, err
+		}
+		if err != nil {
+			return JSONPayload, newBadPayload(err, "Could not zlib decompress the payload before decoding the payload")
+		}
 	}
-	return JSONPayload, err
+	return JSONPayload, nil
 }
 
 func PreURLSafeDumpPayload(JSONPayload []byte) ([]byte, error) {
@@ -198,6 +360,14 @@ func URLSafeLoadPayload(payload []byte, api interface{}) (interface{}, error) {
 	return LoadPayload(data, api)
 }
 
+func URLSafeLoadPayloadInto(payload []byte, api interface{}, dst interface{}) error {
+	data, err := PreURLSafeLoadPayload(payload)
+	if err != nil {
+		return err
+	}
+	return LoadPayloadInto(data, api, dst)
+}
+
 func URLSafeDumpPayload(obj interface{}, api interface{}) (string, error) {
 	str, err := DumpPayload(obj, api)
 	_byte := WantBytes(str)
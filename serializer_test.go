@@ -5,9 +5,12 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"errors"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
 )
 
 var (
@@ -37,7 +40,7 @@ func TestChangedValue(t *testing.T) {
 			t.Fatalf("Loading failed. Error:%s", err)
 		}
 		changed := _func(string(signed))
-		if _, err := serializer.Loads(changed); !strings.Contains(err.Error(), "BadSignature") {
+		if _, err := serializer.Loads(changed); !errors.Is(err, ErrBadSignature) {
 			t.Fatalf("Loading failed, because of unexpected error:%s. Expected:BadSignature.", err.Error())
 		}
 	}
@@ -46,7 +49,7 @@ func TestChangedValue(t *testing.T) {
 func TestBadSignatureException(t *testing.T) {
 	dump, _ := serializer.Dumps(value)
 	badsigned := dump[:len(dump)-1]
-	if _, err := serializer.Loads(string(badsigned)); !strings.Contains(err.Error(), "BadSignature") {
+	if _, err := serializer.Loads(string(badsigned)); !errors.Is(err, ErrBadSignature) {
 		t.Fatalf("Loading failed, because of unexpected error:`%s`. Expected:BadSignature.", err.Error())
 	}
 }
@@ -55,7 +58,7 @@ func TestBadPayloadException(t *testing.T) {
 	original, _ := serializer.Dumps(value)
 	payload, _ := RSplit(original, []byte("."))
 	bad := Signer{Secret: "secret_key", Salt: "itsdangerous"}.Sign(string(payload[:len(payload)-1]))
-	if _, err := serializer.Loads(string(bad)); !strings.Contains(err.Error(), "BadPayload") {
+	if _, err := serializer.Loads(string(bad)); !errors.Is(err, ErrBadPayload) {
 		t.Fatalf("Test_bad_payload_exception failed, because of unexpected error.")
 	}
 }
@@ -69,7 +72,7 @@ func TestAltSalt(t *testing.T) {
 	}
 
 	serializer.Salt = "changed"
-	if _, err := serializer.Loads(string(original)); !strings.Contains(err.Error(), "BadSignature") {
+	if _, err := serializer.Loads(string(original)); !errors.Is(err, ErrBadSignature) {
 		t.Fatalf(err.Error())
 	}
 	serializer.Salt = ""
@@ -127,8 +130,8 @@ func TestDigests(t *testing.T) {
 	factory.Signerkwargs = map[string]interface{}{"DigestMethod": sha512.New}
 	Sha512Value, _ := factory.Dumps([]int{42})
 
-	if !bytes.Equal(DefaultValue, Sha256Value) {
-		t.Fatalf("DefaultValue does not equal to Sha256Value.")
+	if !bytes.Equal(DefaultValue, Sha1Value) {
+		t.Fatalf("DefaultValue does not equal to Sha1Value.")
 	}
 	if !bytes.Equal(Sha1Value, []byte("[42].-9cNi0CxsSB3hZPNCe9a2eEs1ZM")) {
 		t.Fatalf("Sha1Value does not equal to `[42].-9cNi0CxsSB3hZPNCe9a2eEs1ZM`.")
@@ -143,14 +146,17 @@ func TestDigests(t *testing.T) {
 
 // Timed
 func TestMaxAge(t *testing.T) {
-	signed, _ := serializer.TimedDumps(value)
-	_, err := serializer.TimedLoads(string(signed), 10)
+	clock := dangeroustest.NewFakeClock(time.Now())
+	_ser := Serializer{Secret: "secret_key", Clock: clock}
+	signed, _ := _ser.TimedDumps(value)
+	clock.Advance(time.Second)
+	_, err := _ser.TimedLoads(string(signed), 1)
 	if err != nil {
 		t.Fatalf("Unexpected error occurred when loads data. Error:%s", err)
 	}
-	time.Sleep(2 * time.Second)
-	payload, err2 := serializer.TimedLoads(string(signed), 1)
-	if err2 == nil {
+	clock.Advance(time.Second)
+	payload, err2 := _ser.TimedLoads(string(signed), 1)
+	if !errors.Is(err2, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Did not receive expected error.")
 	}
 	if payload.(string) != value {
@@ -160,23 +166,25 @@ func TestMaxAge(t *testing.T) {
 }
 
 func TestTimedDigests(t *testing.T) {
-	tser := Serializer{Secret: "dev key", Salt: "dev salt"}
+	tser := Serializer{Secret: "dev key", Salt: "dev salt",
+		Signerkwargs: map[string]interface{}{"DigestMethod": sha256.New},
+	}
 	tryload := `"value".Xjq_FQ._7SrxmrHFESAmzLxOP73vbITuxL0BnWaZJoj8Pxaux8`
 	payload, err := tser.TimedLoads(tryload, 0)
-	if payload.(string) != value || !strings.Contains(err.Error(), "SignatureExpired") {
+	if payload.(string) != value || !errors.Is(err, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Unexpected payload.")
 	}
 
 	tryload = `"value".Xjq_FQ._7SrxmrHFESAmzLxOP73vbITuxL0BnWaZJoj8Px1234`
 	payload, err = tser.TimedLoads(tryload, 0)
-	if payload != nil || !strings.Contains(err.Error(), "BadSignature") {
+	if payload != nil || !errors.Is(err, ErrBadSignature) {
 		t.Fatalf("Load failed. Unexpected payload.")
 	}
 
 	tryload = `"value".XjrAxA.rejDXOq0ijt9SyqWvx7onhYyFF4hJlqhYU2vjOTE5l8gKyQwKq8XAw8r8cJp6T7_P1594Ckk1oLlwelVsgxbTQ`
 	tser.Signerkwargs = map[string]interface{}{"DigestMethod": sha512.New}
 	payload, err = tser.TimedLoads(tryload, 0)
-	if payload.(string) != value || !strings.Contains(err.Error(), "SignatureExpired") {
+	if payload.(string) != value || !errors.Is(err, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Unexpected payload.")
 	}
 
@@ -196,8 +204,8 @@ func TestUrlDigests(t *testing.T) {
 	factory.Signerkwargs = map[string]interface{}{"DigestMethod": sha512.New}
 	Sha512Value, _ := factory.URLSafeDumps(value)
 
-	if !bytes.Equal(DefaultValue, Sha256Value) {
-		t.Fatalf("DefaultValue does not equal to Sha256Value.")
+	if !bytes.Equal(DefaultValue, Sha1Value) {
+		t.Fatalf("DefaultValue does not equal to Sha1Value.")
 	}
 	if !bytes.Equal(Sha1Value, []byte("InZhbHVlIg.zsqEp7ga91kJ6rH3MKOepF1Iv9s")) {
 		t.Fatalf("Sha1Value does not equal to `InZhbHVlIg.zsqEp7ga91kJ6rH3MKOepF1Iv9s`.")
@@ -219,22 +227,103 @@ func TestTimedurlDigests(t *testing.T) {
 	tryload := `InZhbHVlIg.Xjz2MQ.jMZtbnQCgTRbNpCOwQfOq6GW2qM`
 	tser.Signerkwargs = map[string]interface{}{"DigestMethod": sha1.New}
 	payload, err := tser.URLSafeTimedLoads(tryload, 0)
-	if payload.(string) != value || !strings.Contains(err.Error(), "SignatureExpired") {
+	if payload.(string) != value || !errors.Is(err, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Unexpected payload or error.")
 	}
 
 	tryload = `InZhbHVlIg.Xjz2JQ.L0cc1AQhoRx5efnPBu6gXwaYV0Onr5Rm_wFRjdDWJeg`
 	tser.Signerkwargs = map[string]interface{}{"DigestMethod": sha256.New}
 	payload, err = tser.URLSafeTimedLoads(tryload, 0)
-	if payload.(string) != value || !strings.Contains(err.Error(), "SignatureExpired") {
+	if payload.(string) != value || !errors.Is(err, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Unexpected payload or error.")
 	}
 
 	tryload = `InZhbHVlIg.Xjz14A.w69pHzxjZFMg6j8459Dqy-3GqryhHCNrhEW9oFs-cnBNcjyOM_a-y9cmHuMeReXYyxuzFYl8XjJ5xEt1hJqQ2Q`
 	tser.Signerkwargs = map[string]interface{}{"DigestMethod": sha512.New}
 	payload, err = tser.URLSafeTimedLoads(tryload, 0)
-	if payload.(string) != value || !strings.Contains(err.Error(), "SignatureExpired") {
+	if payload.(string) != value || !errors.Is(err, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Unexpected payload or error.")
 	}
 
 }
+
+func TestSecretKeys(t *testing.T) {
+	old := Serializer{Secret: "old_key"}
+	rotated := Serializer{SecretKeys: []string{"old_key", "new_key"}}
+	oldsigned, _ := old.TimedDumps(value)
+	signed, _ := rotated.TimedDumps(value)
+	for _, s := range [][]byte{oldsigned, signed} {
+		if load, err := rotated.TimedLoads(string(s), 10); err != nil || load.(string) != value {
+			t.Fatalf("Loading failed. Error:%s", err)
+		}
+	}
+	if _, err := old.TimedLoads(string(signed), 10); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Old serializer should not load values signed by the new key.")
+	}
+}
+
+func TestNewSerializer(t *testing.T) {
+	_ser, err := NewSerializer("dev key", WithSalt("dev salt"), WithDigestMethod(sha1.New))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	dump, _ := _ser.Dumps([]int{42})
+	if !bytes.Equal(dump, []byte("[42].-9cNi0CxsSB3hZPNCe9a2eEs1ZM")) {
+		t.Fatalf("Unexpected value:%s", dump)
+	}
+
+	fallback, _ := NewSerializer("dev key", WithSalt("dev salt"),
+		WithFallbackSigners(map[string]interface{}{"DigestMethod": sha1.New}))
+	if load, err := fallback.Loads(string(dump)); err != nil || len(load.([]interface{})) != 1 {
+		t.Fatalf("Loading failed. Error:%s", err)
+	}
+
+	if _, err := NewSerializer(""); err == nil {
+		t.Fatalf("NewSerializer should reject an empty secret.")
+	}
+	if _, err := NewSerializer("dev key", WithKeyDerivation("inv")); err == nil {
+		t.Fatalf("NewSerializer should reject an invalid key derivation.")
+	}
+}
+
+func TestPayloadLimits(t *testing.T) {
+	ser, _ := NewSerializer("secret-key", WithMaxDecompressedSize(1000))
+	large := `"` + strings.Repeat("a", 1000) + `"`
+	bomb := ser.Signer.Sign("." + B64encode(Compress([]byte(large))))
+	var bad *BadPayload
+	_, err := ser.URLSafeLoads(string(bomb))
+	if !errors.As(err, &bad) || !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+	var value string
+	if err := ser.URLSafeLoadsInto(string(bomb), &value); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+	unlimited, _ := NewSerializer("secret-key", WithMaxDecompressedSize(-1))
+	if loaded, err := unlimited.URLSafeLoads(string(bomb)); err != nil || len(loaded.(string)) != 1000 {
+		t.Fatalf("Loading failed. Error:%v", err)
+	}
+	fits := ser.Signer.Sign("." + B64encode(Compress([]byte(large[:999]+`"`))))
+	if _, err := ser.URLSafeLoads(string(fits)); err != nil {
+		t.Fatalf("Loading failed. Error:%s", err)
+	}
+
+	short, _ := NewSerializer("secret-key", WithMaxTokenLength(len(fits)-1))
+	if _, err := short.URLSafeTimedLoads(string(fits), -1); !errors.As(err, &bad) || !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+	if _, err := short.Loads(string(fits)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+
+	if _, err := UnCompress([]byte("not zlib")); err == nil {
+		t.Fatalf("Invalid data should not decompress.")
+	}
+	compressed := Compress([]byte(large))
+	if _, err := UnCompress(compressed[:len(compressed)-2]); err == nil {
+		t.Fatalf("Truncated data should not decompress.")
+	}
+	if _, err := PreURLSafeLoadPayload([]byte(".AAAA")); !errors.Is(err, ErrBadPayload) || errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package dangerous
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/hmac"
 	"crypto/sha1"
 	"fmt"
@@ -13,6 +14,10 @@ var (
 	BlankBytes          = []byte("")
 	DefaultSep          = "."
 	DefaultDigestMethod = sha1.New
+
+	// LegacyEpoch is 2011-01-01, from which itsdangerous before 1.0 counted
+	// its timestamps.
+	LegacyEpoch int64 = 1293840000
 )
 
 type Signature interface {
@@ -44,23 +49,119 @@ func (ha HMACAlgorithm) VerifySignature(key, value, sig []byte) bool {
 	return ByteCompare(sig, ha.GetSignature(key, value))
 }
 
+// Signer signs with its newest secret key and verifies against all of them.
+// SecretKeys is ordered from oldest to newest and, when set, Secret becomes
+// its last item; when it is empty, Secret is the only key.
+//
+// With a PublicKeySignature algorithm the secret is not used: SigningKey
+// signs and VerificationKeys, also oldest to newest, verify. A Signer that
+// only verifies needs no SigningKey; without VerificationKeys, the public
+// half of SigningKey verifies.
+//
+// Leeway is the clock skew, in seconds, that UnSignTimestamp tolerates
+// between the signing and the verifying hosts. A timestamp further in the
+// future is refused, unless MaxAge is negative: then the timestamp is not
+// checked at all.
+//
+// SignTimestamp writes the seconds since Epoch, the Unix epoch if it is 0,
+// and UnSignTimestamp reads them back as Unix time. Set Epoch to LegacyEpoch
+// to sign as itsdangerous 0.x did. With DetectLegacyEpoch, UnSignTimestamp
+// reads a timestamp below LegacyEpoch, which would be before 2011, as
+// counted from LegacyEpoch and any other as Unix time, whatever Epoch is, so
+// that tokens of both kinds are accepted during a migration.
 type Signer struct {
-	Secret        string
-	Salt          string
-	Sep           string
-	SecretBytes   []byte
-	SaltBytes     []byte
-	SepBytes      []byte
-	KeyDerivation string // concat, django-concat, hmac
-	DigestMethod  func() hash.Hash
-	Algorithm     Signature // HMACAlgorithm, NoneAlgorithm
+	Secret            string
+	SecretKeys        []string
+	Salt              string
+	Sep               string
+	SecretBytes       []byte
+	SecretKeysBytes   [][]byte
+	SaltBytes         []byte
+	SepBytes          []byte
+	KeyDerivation     string // concat, django-concat, hmac
+	DigestMethod      func() hash.Hash
+	Algorithm         Signature // HMACAlgorithm, NoneAlgorithm, PublicKeySignature
+	SigningKey        crypto.Signer
+	VerificationKeys  []crypto.PublicKey
+	Clock             Clock // DefaultClock if nil
+	Leeway            int64 // seconds
+	Epoch             int64 // Unix time, see above
+	DetectLegacyEpoch bool
+	initialized       bool
+	err               error // the validation error of SetDefault
+}
+
+// NewSigner returns a validated Signer with its defaults filled in. Its
+// methods never modify it, so it can be shared between goroutines as long
+// as its fields are not changed.
+func NewSigner(secret string, opts ...Option) (Signer, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return Signer{}, err
+	}
+	signer := o.signer(secret, o.salt)
+	if err := signer.init(); err != nil {
+		return Signer{}, err
+	}
+	return signer, nil
+}
+
+func (signer *Signer) init() error {
+	if err := signer.validate(); err != nil {
+		return err
+	}
+	signer.SetDefault()
+	signer.initialized = true
+	return nil
 }
 
+func (signer Signer) validate() error {
+	if signer.isPublicKey() {
+		if signer.SigningKey == nil && len(signer.VerificationKeys) == 0 {
+			return fmt.Errorf("Signer has neither a signing key nor verification keys")
+		}
+	} else if signer.Secret == "" && len(signer.SecretKeys) == 0 {
+		return fmt.Errorf("Signer secret is empty")
+	}
+	if signer.Sep != "" && bytes.ContainsAny(Base64Alphabet, signer.Sep) {
+		return fmt.Errorf("The given separator %q cannot be used because it may be"+
+			" contained in the signature itself. Alphanumeric"+
+			" characters and `-_=` must not be used", signer.Sep)
+	}
+	switch signer.KeyDerivation {
+	case "", "concat", "django-concat", "hmac", "none":
+	default:
+		return fmt.Errorf("Unknown key derivation method %q", signer.KeyDerivation)
+	}
+	return nil
+}
+
+// SetDefault fills in the defaults of a Signer built as a struct literal.
+// An invalid configuration, such as a separator that may appear in a
+// signature, is kept and returned as an error by SignE, SignTimestampE,
+// UnSign and UnSignTimestamp; NewSigner returns it right away.
 func (signer *Signer) SetDefault() {
-	if signer.Secret == "" {
+	if signer.initialized {
+		return
+	}
+	if len(signer.SecretKeys) > 0 {
+		signer.Secret = signer.SecretKeys[len(signer.SecretKeys)-1]
+	}
+	if signer.Secret == "" && !signer.isPublicKey() {
 		panic("Signer secret is empty.")
 	}
+	signer.err = signer.validate()
+	if len(signer.VerificationKeys) == 0 && signer.SigningKey != nil {
+		signer.VerificationKeys = []crypto.PublicKey{signer.SigningKey.Public()}
+	}
+	if len(signer.SecretKeys) == 0 {
+		signer.SecretKeys = []string{signer.Secret}
+	}
 	signer.SecretBytes = WantBytes(signer.Secret)
+	signer.SecretKeysBytes = make([][]byte, len(signer.SecretKeys))
+	for p, secret := range signer.SecretKeys {
+		signer.SecretKeysBytes[p] = WantBytes(secret)
+	}
 	if signer.Salt == "" {
 		signer.Salt = "itsdangerous.Signer"
 	}
@@ -70,12 +171,6 @@ func (signer *Signer) SetDefault() {
 	signer.SepBytes = WantBytes(signer.Sep)
 	signer.SaltBytes = WantBytes(signer.Salt)
 
-	if bytes.Contains(Base64Alphabet, signer.SepBytes) {
-		fmt.Println(
-			"The given separator cannot be used because it may be" +
-				" contained in the signature itself. Alphanumeric" +
-				" characters and `-_=` must not be used. Now we set Sep to DefaultSep(.)")
-	}
 	if signer.KeyDerivation == "" {
 		signer.KeyDerivation = "django-concat"
 	}
@@ -87,80 +182,153 @@ func (signer *Signer) SetDefault() {
 	}
 }
 
+func (signer Signer) isPublicKey() bool {
+	_, ok := signer.Algorithm.(PublicKeySignature)
+	return ok
+}
+
 func IsValidStruct(t interface{}) bool {
 	_, ok := t.(Signature)
 	return ok
 }
 
+// DeriveKey derives the signing key from the newest secret.
 func (signer *Signer) DeriveKey() ([]byte, error) {
+	return signer.deriveKey(signer.SecretBytes)
+}
+
+func (signer *Signer) deriveKey(secret []byte) ([]byte, error) {
 
 	if signer.KeyDerivation == "concat" {
-		msg, _ := Concentrate(signer.SaltBytes, signer.SecretBytes)
+		msg, _ := Concentrate(signer.SaltBytes, secret)
 		funcs := signer.DigestMethod()
 		funcs.Write(msg)
 		return funcs.Sum(nil), nil
 
 	} else if signer.KeyDerivation == "django-concat" {
 		msg, _ := Concentrate(signer.SaltBytes, []byte("signer"))
-		msg, _ = Concentrate(msg, signer.SecretBytes)
+		msg, _ = Concentrate(msg, secret)
 		funcs := signer.DigestMethod()
 		funcs.Write(msg)
 		return funcs.Sum(nil), nil
 
 	} else if signer.KeyDerivation == "hmac" {
-		mac := hmac.New(signer.DigestMethod, signer.SecretBytes)
+		mac := hmac.New(signer.DigestMethod, secret)
 		mac.Write(signer.SaltBytes)
 		return mac.Sum(nil), nil
 
 	} else if signer.KeyDerivation == "none" {
-		return signer.SecretBytes, nil
+		return secret, nil
 
 	}
 	return []byte("Error"), fmt.Errorf("Unknown key derivation method")
 }
 
+// GetSignature returns the base64 signature of value, or an empty
+// signature if the Signer can not sign, as a PublicKeySignature Signer
+// without a SigningKey. GetSignatureE returns the reason.
 func (signer Signer) GetSignature(value []byte) []byte {
+	sig, _ := signer.GetSignatureE(value)
+	return sig
+}
+
+// GetSignatureE is GetSignature that returns an error when the Signer can
+// not sign.
+func (signer Signer) GetSignatureE(value []byte) ([]byte, error) {
+	if alg, ok := signer.Algorithm.(PublicKeySignature); ok {
+		if signer.SigningKey == nil {
+			return BlankBytes, fmt.Errorf("Signer has no signing key")
+		}
+		sig, err := alg.SignWithKey(signer.SigningKey, value)
+		if err != nil {
+			return BlankBytes, err
+		}
+		return WantBytes(B64encode(sig)), nil
+	}
 	key, err := signer.DeriveKey()
 	if err != nil {
-		panic(fmt.Sprintf("Signer.GetSignature: %s.", err))
+		return BlankBytes, err
 	}
 	sig := signer.Algorithm.(Signature).GetSignature(key, value)
-	return WantBytes(B64encode(sig))
+	return WantBytes(B64encode(sig)), nil
 }
 
+// Sign returns value followed by Sep and its signature, or an empty token
+// if the Signer can not sign. SignE returns the reason.
 func (signer Signer) Sign(value string) []byte {
+	signed, _ := signer.SignE(value)
+	return signed
+}
+
+// SignE is Sign that returns an error when the Signer can not sign.
+func (signer Signer) SignE(value string) ([]byte, error) {
 	(&signer).SetDefault()
+	if signer.err != nil {
+		return BlankBytes, signer.err
+	}
 	valuebyte := WantBytes(value)
-	msg, _ := Concentrate(valuebyte, signer.SepBytes)
-	msg, _ = Concentrate(msg, signer.GetSignature(valuebyte))
-	return msg
+	sig, err := signer.GetSignatureE(valuebyte)
+	if err != nil {
+		return BlankBytes, err
+	}
+	return Concentrate(valuebyte, signer.SepBytes, sig)
 }
 
 func (signer Signer) VerifySignature(value []byte, sig []byte) bool {
+	return signer.MatchSignature(value, sig) != -1
+}
+
+// MatchSignature returns the index in SecretKeys, or VerificationKeys for a
+// PublicKeySignature, of the key that made sig, trying the newest key first,
+// or -1 if no key matches.
+func (signer Signer) MatchSignature(value []byte, sig []byte) int {
 	(&signer).SetDefault()
-	key, err := signer.DeriveKey()
-	if err != nil {
-		return false
-	}
 	sigb, err := B64decode(sig)
 	if err != nil {
-		return false
+		return -1
 	}
-	return signer.Algorithm.(Signature).VerifySignature(key, value, sigb)
+	if alg, ok := signer.Algorithm.(PublicKeySignature); ok {
+		for p := len(signer.VerificationKeys) - 1; p >= 0; p-- {
+			if alg.VerifyWithKey(signer.VerificationKeys[p], value, sigb) {
+				return p
+			}
+		}
+		return -1
+	}
+	for p := len(signer.SecretKeysBytes) - 1; p >= 0; p-- {
+		key, err := signer.deriveKey(signer.SecretKeysBytes[p])
+		if err != nil {
+			return -1
+		}
+		if signer.Algorithm.(Signature).VerifySignature(key, value, sigb) {
+			return p
+		}
+	}
+	return -1
 }
 
 func (signer Signer) UnSign(signedvalues string) ([]byte, error) {
+	value, _, err := signer.UnSignKey(signedvalues)
+	return value, err
+}
+
+// UnSignKey is like UnSign, but also returns the index of the key that
+// verified the signature as MatchSignature does, or -1 on error.
+func (signer Signer) UnSignKey(signedvalues string) ([]byte, int, error) {
 	(&signer).SetDefault()
+	if signer.err != nil {
+		return BlankBytes, -1, signer.err
+	}
 	signedvalue := WantBytes(signedvalues)
 	sep := signer.SepBytes
 	if !bytes.Contains(signedvalue, sep) {
-		return BlankBytes, fmt.Errorf("BadSignature: No %s found in value", signer.Sep)
+		return BlankBytes, -1, newBadSignature(nil, nil, fmt.Sprintf("No %q found in value", signer.Sep))
 	}
 	value, sig := RSplit(signedvalue, sep)
-	if signer.VerifySignature(value, sig) {
-		return value, nil
+	if p := signer.MatchSignature(value, sig); p != -1 {
+		return value, p, nil
 	}
-	return BlankBytes, fmt.Errorf("BadSignature: Signature %s does not match. Value: %s", sig, value)
+	return BlankBytes, -1, newBadSignature(value, nil, fmt.Sprintf("Signature %q does not match", sig))
 }
 
 func (signer Signer) Validate(signedvalues string) bool {
@@ -172,17 +340,32 @@ func (signer Signer) Validate(signedvalues string) bool {
 }
 
 func (signer Signer) GetTimestamp() int64 {
-	return time.Now().UTC().Unix()
+	return clockOrDefault(signer.Clock).Now().UTC().Unix()
 }
 
+// SignTimestamp signs value and the current time, or returns an empty token
+// if the Signer can not sign. SignTimestampE returns the reason.
 func (signer Signer) SignTimestamp(values string) []byte {
+	signed, _ := signer.SignTimestampE(values)
+	return signed
+}
+
+// SignTimestampE is SignTimestamp that returns an error when the Signer can
+// not sign.
+func (signer Signer) SignTimestampE(values string) ([]byte, error) {
 	(&signer).SetDefault()
+	if signer.err != nil {
+		return BlankBytes, signer.err
+	}
 	value := WantBytes(values)
-	timestamp := WantBytes(B64encode(Int2Bytes(signer.GetTimestamp())))
+	timestamp := WantBytes(B64encode(Int2Bytes(signer.GetTimestamp() - signer.Epoch)))
 	sep := WantBytes(signer.Sep)
 	value, _ = Concentrate(value, sep, timestamp)
-	value, _ = Concentrate(value, sep, signer.GetSignature(value))
-	return value
+	sig, err := signer.GetSignatureE(value)
+	if err != nil {
+		return BlankBytes, err
+	}
+	return Concentrate(value, sep, sig)
 }
 
 func (signer Signer) UnSignTimestamp(values string, MaxAge int64) ([]byte, int64, error) {
@@ -193,24 +376,51 @@ func (signer Signer) UnSignTimestamp(values string, MaxAge int64) ([]byte, int64
 	}
 	sep := WantBytes(signer.Sep)
 	if !bytes.Contains(result, sep) {
-		return result, 0, fmt.Errorf("BadTimeSignature-timestamp missing")
+		return result, 0, newBadTimeSignature(result, time.Time{}, nil, "timestamp missing")
 	}
 	value, ts := RSplit(result, sep)
 	decode, err := B64decode(ts)
 	if err != nil {
-		return value, 0, fmt.Errorf("BadTimeSignature-%s", err)
-	}
-	timestamp := Bytes2Int(decode)
-	if err != nil {
-		return value, timestamp, fmt.Errorf("BadTimeSignature-Malformed timestamp")
+		return value, 0, newBadTimeSignature(value, time.Time{}, err, "Malformed timestamp")
 	}
-	if MaxAge >= 0 {
-		age := signer.GetTimestamp() - timestamp
-		if age > MaxAge {
-			return value, timestamp, fmt.Errorf("SignatureExpired-Signature age %d > %d seconds", age, MaxAge)
+	timestamp := signer.unixTimestamp(Bytes2Int(decode))
+	return value, timestamp, signer.checkTimestamp(value, timestamp, MaxAge)
+}
+
+// unixTimestamp returns the Unix time of a timestamp read by UnSignTimestamp.
+func (signer Signer) unixTimestamp(timestamp int64) int64 {
+	if signer.DetectLegacyEpoch {
+		if timestamp < LegacyEpoch {
+			return timestamp + LegacyEpoch
 		}
+		return timestamp
+	}
+	return timestamp + signer.Epoch
+}
+
+// checkTimestamp returns the error UnSignTimestamp gives for value signed at
+// timestamp, if any.
+func (signer Signer) checkTimestamp(value []byte, timestamp, MaxAge int64) error {
+	return checkTimestamp(value, timestamp, signer.GetTimestamp(), MaxAge, signer.Leeway, signer.Leeway)
+}
+
+// checkTimestamp refuses a timestamp more than skew seconds after now, or
+// more than MaxAge plus leeway seconds before it. A negative MaxAge accepts
+// any timestamp, as a max_age of None does in itsdangerous.
+func checkTimestamp(value []byte, timestamp, now, MaxAge, skew, leeway int64) error {
+	if MaxAge < 0 {
+		return nil
+	}
+	age := now - timestamp
+	if -age > skew {
+		return newSignatureFromFuture(value, time.Unix(timestamp, 0).UTC(),
+			fmt.Sprintf("Signature is %d seconds in the future", -age))
+	}
+	if age > MaxAge+leeway {
+		return newSignatureExpired(value, time.Unix(timestamp, 0).UTC(),
+			fmt.Sprintf("Signature age %d > %d seconds", age, MaxAge))
 	}
-	return value, timestamp, nil
+	return nil
 }
 
 func (signer Signer) ValidateTimestamp(signedvalue string, MaxAge int64) bool {
@@ -2,10 +2,13 @@ package dangerous
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"crypto/sha512"
-	"strings"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
 )
 
 var (
@@ -29,7 +32,7 @@ func Test_no_separator(t *testing.T) {
 	if signer.Validate(string(signed)) {
 		t.Fatalf("Validate failed.")
 	}
-	if _, err := signer.UnSign(string(signed)); !strings.Contains(err.Error(), "BadSignature") {
+	if _, err := signer.UnSign(string(signed)); !errors.Is(err, ErrBadSignature) {
 		t.Fatalf("Unsign failed.")
 	}
 }
@@ -41,7 +44,7 @@ func Test_broken_signature(t *testing.T) {
 	if signer.VerifySignature([]byte(value), badsig) {
 		t.Fatalf("Verify Signature failed.")
 	}
-	if _, err := signer.UnSign(string(signed)); !strings.Contains(err.Error(), "BadSignature") {
+	if _, err := signer.UnSign(string(signed)); !errors.Is(err, ErrBadSignature) {
 		t.Fatalf("Unsign failed.")
 	}
 }
@@ -52,14 +55,30 @@ func Test_changed_value(t *testing.T) {
 	if signer.VerifySignature([]byte(value), signed) {
 		t.Fatalf("Verify Signature failed.")
 	}
-	if _, err := signer.UnSign(string(signed)); !strings.Contains(err.Error(), "BadSignature") {
+	if _, err := signer.UnSign(string(signed)); !errors.Is(err, ErrBadSignature) {
 		t.Fatalf("Unsign failed.")
 	}
 }
 
 func Test_invalid_separator(t *testing.T) {
-	_signer := Signer{Secret: "secret-key", Sep: "-"}
-	_signer.Sign(value)
+	for _, sep := range []string{"-", "a!"} {
+		_signer := Signer{Secret: "secret-key", Sep: sep}
+		if signed, err := _signer.SignE(value); err == nil || len(signed) != 0 {
+			t.Fatalf("%q: SignE should refuse an invalid separator, got %s", sep, signed)
+		}
+		if signed := _signer.Sign(value); len(signed) != 0 {
+			t.Fatalf("%q: Sign should return an empty token, got %s", sep, signed)
+		}
+		if _, err := _signer.SignTimestampE(value); err == nil {
+			t.Fatalf("%q: SignTimestampE should refuse an invalid separator.", sep)
+		}
+		if _, err := _signer.UnSign(value + sep + "sig"); err == nil || errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%q: UnSign should refuse an invalid separator, got %v", sep, err)
+		}
+		if _, _, err := _signer.UnSignTimestamp(value+sep+"sig", -1); err == nil || errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%q: UnSignTimestamp should refuse an invalid separator, got %v", sep, err)
+		}
+	}
 }
 
 func Test_key_derivation(t *testing.T) {
@@ -117,14 +136,17 @@ func Test_algorithm(t *testing.T) {
 
 // timed
 func Test_max_age(t *testing.T) {
-	signed := signer.SignTimestamp(value)
-	_, _, err := signer.UnSignTimestamp(string(signed), 2)
+	clock := dangeroustest.NewFakeClock(time.Now())
+	_signer := Signer{Secret: "secret-key", Clock: clock}
+	signed := _signer.SignTimestamp(value)
+	clock.Advance(2 * time.Second)
+	_, _, err := _signer.UnSignTimestamp(string(signed), 2)
 	if err != nil {
 		t.Fatalf("Unexpected error occurred when loads data. Error:%s", err)
 	}
-	time.Sleep(2 * time.Second)
-	payload, _, err2 := signer.UnSignTimestamp(string(signed), 1)
-	if err2 == nil {
+	clock.Advance(time.Second)
+	payload, _, err2 := _signer.UnSignTimestamp(string(signed), 2)
+	if !errors.Is(err2, ErrSignatureExpired) {
 		t.Fatalf("Load failed. Did not receive expected error.")
 	}
 	if string(payload) != value {
@@ -132,10 +154,87 @@ func Test_max_age(t *testing.T) {
 	}
 }
 
+func Test_leeway(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_signer, _ := NewSigner("secret-key", WithClock(clock), WithLeeway(5))
+	signed := _signer.SignTimestamp(value)
+	clock.Set(time.Unix(1580000000+10+5, 0))
+	if _, _, err := _signer.UnSignTimestamp(string(signed), 10); err != nil {
+		t.Fatalf("Leeway should accept the signature. Error:%s", err)
+	}
+	clock.Advance(time.Second)
+	if _, _, err := _signer.UnSignTimestamp(string(signed), 10); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("Expected SignatureExpired, got %v", err)
+	}
+
+	clock.Set(time.Unix(1580000000-5, 0))
+	if _, _, err := _signer.UnSignTimestamp(string(signed), 10); err != nil {
+		t.Fatalf("Leeway should accept a signature from the near future. Error:%s", err)
+	}
+	clock.Advance(-time.Second)
+	_, ts, err := _signer.UnSignTimestamp(string(signed), 10)
+	var future *SignatureFromFuture
+	if !errors.As(err, &future) || ts != 1580000000 || !future.DateSigned.Equal(time.Unix(1580000000, 0)) {
+		t.Fatalf("Expected SignatureFromFuture, got %v", err)
+	}
+	if !errors.Is(err, ErrBadTimeSignature) || errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("SignatureFromFuture should only be a BadTimeSignature.")
+	}
+	// Without a max age the timestamp is not checked, as in itsdangerous.
+	if _, _, err := _signer.UnSignTimestamp(string(signed), -1); err != nil {
+		t.Fatalf("A negative MaxAge should accept any timestamp. Error:%s", err)
+	}
+	unbounded, _ := NewSigner("secret-key", WithClock(clock))
+	clock.Set(time.Unix(1580000000-1, 0))
+	if _, _, err := unbounded.UnSignTimestamp(string(signed), -1); err != nil {
+		t.Fatalf("A negative MaxAge should accept any timestamp. Error:%s", err)
+	}
+}
+
+func Test_legacy_epoch(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1600000000, 0))
+	legacy, _ := NewSigner("secret-key", WithEpoch(LegacyEpoch), WithDigestMethod(sha1.New), WithClock(clock))
+	unix, _ := NewSigner("secret-key", WithDigestMethod(sha1.New), WithClock(clock))
+	detect, _ := NewSigner("secret-key", WithDetectLegacyEpoch(), WithDigestMethod(sha1.New), WithClock(clock))
+	// TimestampSigner("secret-key").sign("my string") of itsdangerous 0.24.
+	signed := "my string.Ej-hgA.VmZccG3biEQcNPWsH7I8fw97gpQ"
+	if got, err := legacy.SignTimestampE("my string"); err != nil || string(got) != signed {
+		t.Fatalf("Got %s, expected %s. Error:%v", got, signed, err)
+	}
+	if _, _, err := unix.UnSignTimestamp(signed, 3600); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("A legacy timestamp should look expired without its epoch, got %v", err)
+	}
+	for _, _signer := range []Signer{legacy, detect} {
+		if value, ts, err := _signer.UnSignTimestamp(signed, 3600); err != nil || string(value) != "my string" || ts != 1600000000 {
+			t.Fatalf("Got %s at %d. Error:%v", value, ts, err)
+		}
+	}
+	unixSigned := unix.SignTimestamp("my string")
+	if _, ts, err := detect.UnSignTimestamp(string(unixSigned), 3600); err != nil || ts != 1600000000 {
+		t.Fatalf("Detection should keep accepting Unix timestamps, got %d. Error:%v", ts, err)
+	}
+	if _, err := NewSigner("secret-key", WithEpoch(-1)); err == nil {
+		t.Fatalf("A negative epoch should be refused.")
+	}
+
+	ser, _ := NewSerializer("secret-key", WithEpoch(LegacyEpoch), WithClock(clock))
+	token, _ := ser.URLSafeTimedDumps([]int{1})
+	clock.Advance(10 * time.Second)
+	detecting, _ := NewSerializer("secret-key", WithDetectLegacyEpoch(), WithClock(clock))
+	if _, err := detecting.URLSafeTimedLoads(string(token), 10); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if _, err := detecting.URLSafeTimedLoads(string(token), 9); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("Expected SignatureExpired, got %v", err)
+	}
+}
+
 func Test_return_timestamp(t *testing.T) {
-	signed := signer.SignTimestamp(value)
-	_, ts, err := signer.UnSignTimestamp(string(signed), 0)
-	if err != nil || time.Now().UTC().Unix()-ts > 5 {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_signer := Signer{Secret: "secret-key", Clock: clock}
+	signed := _signer.SignTimestamp(value)
+	_, ts, err := _signer.UnSignTimestamp(string(signed), 0)
+	if err != nil || ts != 1580000000 {
 		t.Fatalf("Cant not get the timestamp")
 	}
 }
@@ -143,7 +242,7 @@ func Test_return_timestamp(t *testing.T) {
 func Test_timestamp_missing(t *testing.T) {
 	signed := signer.Sign(value)
 	_, _, err := signer.UnSignTimestamp(string(signed), 10)
-	if !strings.Contains(err.Error(), "BadTimeSignature") {
+	if !errors.Is(err, ErrBadTimeSignature) {
 		t.Fatalf("Unexpected error occurred when loads data. Error:%s", err)
 	}
 }
@@ -151,7 +250,78 @@ func Test_timestamp_missing(t *testing.T) {
 func Test_malformed_timestamp(t *testing.T) {
 	signed := signer.Sign(value + ".____________")
 	_, _, err := signer.UnSignTimestamp(string(signed), 10)
-	if !strings.Contains(err.Error(), "SignatureExpired") {
+	if !errors.Is(err, ErrSignatureExpired) {
 		t.Fatalf("Unexpected error occurred when loads data. Error:%s", err)
 	}
 }
+
+func Test_secret_keys(t *testing.T) {
+	old := Signer{Secret: "old-key"}
+	oldsigned := old.Sign(value)
+
+	rotated := Signer{SecretKeys: []string{"old-key", "new-key"}}
+	signed := rotated.Sign(value)
+	if newsigned := (Signer{Secret: "new-key"}).Sign(value); !bytes.Equal(signed, newsigned) {
+		t.Fatalf("Sign should use the newest key.")
+	}
+	for p, s := range [][]byte{oldsigned, signed} {
+		v, key, err := rotated.UnSignKey(string(s))
+		if err != nil || string(v) != value || key != p {
+			t.Fatalf("UnSignKey failed. key:%d, expected:%d, error:%s", key, p, err)
+		}
+	}
+	if _, err := old.UnSign(string(signed)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Old signer should not verify the new key.")
+	}
+	othersigned := Signer{Secret: "other-key"}.Sign(value)
+	if _, key, err := rotated.UnSignKey(string(othersigned)); key != -1 || err == nil {
+		t.Fatalf("Unknown key should not match.")
+	}
+}
+
+func Test_new_signer(t *testing.T) {
+	_signer, err := NewSigner("secret-key", WithSalt("salt"), WithKeyDerivation("hmac"), WithDigestMethod(sha512.New))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	literal := Signer{Secret: "secret-key", Salt: "salt", KeyDerivation: "hmac", DigestMethod: sha512.New}
+	signed := _signer.Sign(value)
+	if expected := literal.Sign(value); !bytes.Equal(signed, expected) {
+		t.Fatalf("NewSigner should sign like the struct literal.")
+	}
+
+	done := make(chan bool)
+	for i := 0; i < 8; i++ {
+		go func() {
+			signed := _signer.Sign(value)
+			v, err := _signer.UnSign(string(signed))
+			done <- err == nil && string(v) == value
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if !<-done {
+			t.Fatalf("Unsign failed.")
+		}
+	}
+}
+
+func Test_new_signer_invalid(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithSep("-")},
+		{WithSep("a")},
+		{WithSep("a!")},
+		{WithSep("!=")},
+		{WithKeyDerivation("inv")},
+		{WithSecretKeys("old-key", "")},
+	} {
+		if signer, err := NewSigner("secret-key", opts...); err == nil || signer.Secret != "" {
+			t.Fatalf("NewSigner should reject invalid options with a zero Signer.")
+		}
+	}
+	if _, err := NewSigner(""); err == nil {
+		t.Fatalf("NewSigner should reject an empty secret.")
+	}
+	if _, err := NewSigner("", WithSecretKeys("old-key", "new-key")); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+}
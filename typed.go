@@ -0,0 +1,54 @@
+package dangerous
+
+// TypedSerializer is a Serializer for payloads of type T. Loads decodes
+// straight into a T, so that integer fields keep their precision and the
+// payload schema is checked by the compiler instead of type assertions.
+type TypedSerializer[T any] struct {
+	Serializer Serializer
+}
+
+// NewTypedSerializer returns a TypedSerializer built like NewSerializer.
+func NewTypedSerializer[T any](secret string, opts ...Option) (TypedSerializer[T], error) {
+	ser, err := NewSerializer(secret, opts...)
+	return TypedSerializer[T]{Serializer: ser}, err
+}
+
+func (ts TypedSerializer[T]) Dumps(obj T) ([]byte, error) {
+	return ts.Serializer.Dumps(obj)
+}
+
+func (ts TypedSerializer[T]) Loads(s string) (T, error) {
+	var obj T
+	err := ts.Serializer.LoadsInto(s, &obj)
+	return obj, err
+}
+
+func (ts TypedSerializer[T]) TimedDumps(obj T) ([]byte, error) {
+	return ts.Serializer.TimedDumps(obj)
+}
+
+func (ts TypedSerializer[T]) TimedLoads(s string, MaxAge int64) (T, error) {
+	var obj T
+	err := ts.Serializer.TimedLoadsInto(s, MaxAge, &obj)
+	return obj, err
+}
+
+func (ts TypedSerializer[T]) URLSafeDumps(obj T) ([]byte, error) {
+	return ts.Serializer.URLSafeDumps(obj)
+}
+
+func (ts TypedSerializer[T]) URLSafeLoads(s string) (T, error) {
+	var obj T
+	err := ts.Serializer.URLSafeLoadsInto(s, &obj)
+	return obj, err
+}
+
+func (ts TypedSerializer[T]) URLSafeTimedDumps(obj T) ([]byte, error) {
+	return ts.Serializer.URLSafeTimedDumps(obj)
+}
+
+func (ts TypedSerializer[T]) URLSafeTimedLoads(s string, MaxAge int64) (T, error) {
+	var obj T
+	err := ts.Serializer.URLSafeTimedLoadsInto(s, MaxAge, &obj)
+	return obj, err
+}
@@ -0,0 +1,110 @@
+package dangerous
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xiaoxfan/dangerous/dangeroustest"
+)
+
+type testUser struct {
+	ID    int64    `json:"id"`
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+var bigUser = testUser{ID: 1<<53 + 1, Name: "name", Roles: []string{"admin"}}
+
+func TestTypedSerializer(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	ts, err := NewTypedSerializer[testUser]("secret-key", WithClock(clock))
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	for _, v := range []struct {
+		dumps func(testUser) ([]byte, error)
+		loads func(string) (testUser, error)
+	}{
+		{ts.Dumps, ts.Loads},
+		{ts.URLSafeDumps, ts.URLSafeLoads},
+		{ts.TimedDumps, func(s string) (testUser, error) { return ts.TimedLoads(s, 10) }},
+		{ts.URLSafeTimedDumps, func(s string) (testUser, error) { return ts.URLSafeTimedLoads(s, 10) }},
+	} {
+		signed, _ := v.dumps(bigUser)
+		loaded, err := v.loads(string(signed))
+		if err != nil || loaded.ID != bigUser.ID || loaded.Roles[0] != "admin" {
+			t.Fatalf("Loading failed. Got %v, error:%v", loaded, err)
+		}
+		if _, err := v.loads(string(signed) + "x"); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("Expected BadSignature, got %v", err)
+		}
+	}
+
+	signed, _ := ts.TimedDumps(bigUser)
+	clock.Advance(11 * time.Second)
+	if loaded, err := ts.TimedLoads(string(signed), 10); !errors.Is(err, ErrSignatureExpired) || loaded.ID != bigUser.ID {
+		t.Fatalf("Expired payload should still be decoded. Error:%v", err)
+	}
+	other, _ := NewSerializer("secret-key")
+	signed, _ = other.Dumps(map[string]interface{}{"id": "not a number"})
+	if _, err := ts.Loads(string(signed)); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("Expected BadPayload, got %v", err)
+	}
+}
+
+func TestLoadsInto(t *testing.T) {
+	signed, _ := serializer.URLSafeDumps(bigUser)
+	var loaded map[string]interface{}
+	if err := serializer.URLSafeLoadsInto(string(signed), &loaded); err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+	if id, _ := loaded["id"].(json.Number).Int64(); id != bigUser.ID {
+		t.Fatalf("Large integer lost its precision: %v", loaded["id"])
+	}
+	for _, data := range []string{`{"a":1}]`, `{"a":1}}`, `{"a":1} {"a":2}`, `{"a":1} x`, `1 2`} {
+		var v interface{}
+		if err := (JSON{}).LoadInto([]byte(data), &v); err == nil {
+			t.Fatalf("%s: trailing data should be refused.", data)
+		}
+	}
+	var v map[string]int
+	if err := (JSON{}).LoadInto([]byte(" {\"a\":1}\n"), &v); err != nil || v["a"] != 1 {
+		t.Fatalf("Got %v. Error:%v", v, err)
+	}
+}
+
+func TestJWSLoadsInto(t *testing.T) {
+	clock := dangeroustest.NewFakeClock(time.Unix(1580000000, 0))
+	_jws, _ := NewJWS("secret-key", WithClock(clock), WithExpiresIn(10))
+	signed, _ := _jws.Dumps(bigUser)
+	var user testUser
+	header, err := _jws.LoadsInto(string(signed), &user)
+	if err != nil || user.ID != bigUser.ID || header["alg"] != DefaultAlgorithm {
+		t.Fatalf("Loading failed. Got %v, error:%v", user, err)
+	}
+
+	signed, _ = _jws.TimedDumps(bigUser)
+	clock.Advance(11 * time.Second)
+	user = testUser{}
+	if _, err := _jws.TimedLoadsInto(string(signed), &user); !errors.Is(err, ErrSignatureExpired) || user.ID != bigUser.ID {
+		t.Fatalf("Expired payload should still be decoded. Error:%v", err)
+	}
+	user = testUser{}
+	if _, err := _jws.TimedLoadsInto(string(signed)+"x", &user); !errors.Is(err, ErrBadSignature) || user.ID != 0 {
+		t.Fatalf("Unverified payload should not be decoded. Error:%v", err)
+	}
+
+	literal := JSONWebSignatureSerializer{Secret: "secret"}
+	signed, _ = literal.Dumps(bigUser)
+	user = testUser{}
+	if _, err := literal.LoadsInto(string(signed), &user); err != nil || user.ID != bigUser.ID {
+		t.Fatalf("A struct literal should load. Got %v, error:%v", user, err)
+	}
+	signed, _ = literal.TimedDumps(bigUser)
+	user = testUser{}
+	if _, err := literal.TimedLoadsInto(string(signed), &user); err != nil || user.ID != bigUser.ID {
+		t.Fatalf("A struct literal should load. Got %v, error:%v", user, err)
+	}
+}
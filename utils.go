@@ -3,11 +3,25 @@ package dangerous
 import (
 	"bytes"
 	"compress/zlib"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
 )
 
+var (
+	// DefaultMaxDecompressedSize is the size, in bytes, to which UnCompress,
+	// and a Serializer without MaxDecompressedSize, inflate a payload at most.
+	DefaultMaxDecompressedSize int64 = 1 << 20
+	// DefaultMaxTokenLength is the length of the longest token loaded by a
+	// Serializer without MaxTokenLength.
+	DefaultMaxTokenLength = 1 << 20
+
+	// ErrPayloadTooLarge is wrapped by the BadPayload returned for a token or
+	// a decompressed payload over its limit.
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
 func ByteCompare(a, b []byte) bool {
 	return bytes.Compare(a, b) == 0
 }
@@ -57,11 +71,49 @@ func Compress(src []byte) []byte {
 	return in.Bytes()
 }
 
+// UnCompress inflates zlib data to at most DefaultMaxDecompressedSize bytes,
+// see UnCompressLimit.
 func UnCompress(data []byte) ([]byte, error) {
-	b := bytes.NewReader(data)
+	return UnCompressLimit(data, DefaultMaxDecompressedSize)
+}
+
+// UnCompressLimit inflates zlib data. It stops as soon as the data inflates
+// to more than maxSize bytes, and returns a BadPayload wrapping
+// ErrPayloadTooLarge. A negative maxSize means no limit.
+func UnCompressLimit(data []byte, maxSize int64) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if maxSize >= 0 {
+		src = io.LimitReader(r, maxSize+1)
+	}
 	var out bytes.Buffer
-	r, err := zlib.NewReader(b)
-	io.Copy(&out, r)
-	r.Close()
-	return out.Bytes(), err
+	if _, err := io.Copy(&out, src); err != nil {
+		return nil, err
+	}
+	if maxSize >= 0 && int64(out.Len()) > maxSize {
+		return nil, newBadPayload(ErrPayloadTooLarge,
+			fmt.Sprintf("Payload decompresses to more than %d bytes", maxSize))
+	}
+	return out.Bytes(), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// limit returns n, or def if n is 0. A negative result means no limit.
+func limit(n, def int64) int64 {
+	if n == 0 {
+		return def
+	}
+	return n
 }